@@ -0,0 +1,204 @@
+// Package azure adds the azure target type, sending lines to an Azure Monitor
+// Log Analytics workspace with the Data Collector API.
+package azure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+const (
+	// log type of targets that don't set one
+	defaultLogType = "Logspout"
+	// domain of workspaces in Azure's public cloud
+	defaultDomain = "ods.opinsights.azure.com"
+	// largest request the Data Collector API accepts
+	maxRequest = 30 << 20
+)
+
+func init() {
+	router.RegisterAdapter("azure", router.AdapterType{
+		New:          newAzureAdapter,
+		ValidateAddr: validateAddr,
+		Options:      "azure",
+	})
+	router.RegisterTargetOptions("azure", func() router.TargetOptions { return new(Config) })
+}
+
+// checks an address is a workspace ID
+func validateAddr(addr string) error {
+	if addr == "" || strings.ContainsAny(addr, "/:,.") {
+		return fmt.Errorf("azure addr must be the workspace ID, not %q", addr)
+	}
+	return nil
+}
+
+// azureAdapter posts batches of lines as JSON arrays of records, one
+// request per log type in the batch
+type azureAdapter struct {
+	route  *router.Route
+	client *http.Client
+	config Config
+	url    string
+}
+
+func newAzureAdapter(route *router.Route) (router.Adapter, error) {
+	target := route.Target
+	options, ok := target.Options("azure").(*Config)
+	if !ok {
+		return nil, fmt.Errorf("azure targets need azure shared_key or shared_key_file")
+	}
+	config := *options
+	domain := config.Domain
+	if domain == "" {
+		domain = defaultDomain
+	}
+	proxy, err := target.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	return &azureAdapter{
+		route: route,
+		client: &http.Client{
+			Timeout: route.Conn().WriteTimeoutDuration(),
+			Transport: &http.Transport{
+				Proxy:           proxy,
+				DialContext:     route.Conn().Dialer().DialContext,
+				TLSClientConfig: route.TLSConfig(""),
+			},
+		},
+		config: config,
+		url:    "https://" + target.Addr + "." + domain + "/api/logs?api-version=2016-04-01",
+	}, nil
+}
+
+func (a *azureAdapter) Stream(logstream chan *attach.Log) {
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.encode)
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		return a.send, func() {}
+	})
+}
+
+// encodes a line as a record, with the line's parsed fields as columns
+// alongside its container's
+func (a *azureAdapter) encode(logline *attach.Log, buf *bytes.Buffer) error {
+	target := a.route.Target
+	record := router.GetDoc()
+	defer router.PutDoc(record)
+	for field, value := range logline.Fields {
+		record[field] = value
+	}
+	record[target.FieldName("timestamp")] = logline.Timestamp
+	record[target.FieldName("message")] = logline.Data
+	record[target.FieldName("container")] = logline.Name
+	record[target.FieldName("container_id")] = logline.ID
+	record[target.FieldName("image")] = logline.Image
+	record[target.FieldName("stream")] = logline.Type
+	if logline.Severity != "" {
+		record[target.FieldName("severity")] = logline.Severity
+	}
+	if k8s := attach.CachedK8sContainer(logline.Name); k8s != nil {
+		record[target.FieldName("k8s_pod")] = k8s.Pod
+		record[target.FieldName("k8s_container")] = k8s.Name
+		record[target.FieldName("k8s_namespace")] = k8s.Namespace
+	}
+	for field, value := range target.Fields {
+		record[field] = value
+	}
+	return json.NewEncoder(buf).Encode(record)
+}
+
+// posts a batch's records, grouped by their log type
+func (a *azureAdapter) send(batch []*router.BatchItem) {
+	var logTypes []string
+	grouped := make(map[string][]*router.BatchItem)
+	for _, item := range batch {
+		logType, err := a.config.LogTypeFor(item.Log, defaultLogType)
+		if err != nil {
+			logging.Logger("azure").Error("log type failed", "route", a.route.ID, "err", err)
+			a.route.Status().Failed(err)
+			a.route.Status().Dropped("error", 1)
+			continue
+		}
+		if _, ok := grouped[logType]; !ok {
+			logTypes = append(logTypes, logType)
+		}
+		grouped[logType] = append(grouped[logType], item)
+	}
+	for _, logType := range logTypes {
+		items := grouped[logType]
+		if err := a.post(logType, items); err != nil {
+			logging.Logger("azure").Error("send failed", "route", a.route.ID, "log_type", logType, "err", err)
+			a.route.Status().Failed(err)
+			a.route.Status().Dropped("error", len(items))
+			continue
+		}
+		for _, item := range items {
+			a.route.Status().Sent(item.Buf.Len())
+		}
+	}
+}
+
+// posts records of a log type as one request, signed with the workspace's
+// shared key
+func (a *azureAdapter) post(logType string, items []*router.BatchItem) error {
+	body := router.GetBuffer()
+	defer router.PutBuffer(body)
+	body.WriteByte('[')
+	for i, item := range items {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(bytes.TrimSuffix(item.Buf.Bytes(), []byte("\n")))
+	}
+	body.WriteByte(']')
+	if body.Len() > maxRequest {
+		return fmt.Errorf("request of %d bytes is larger than the %d the API accepts", body.Len(), maxRequest)
+	}
+	key, err := a.config.CurrentSharedKey()
+	if err != nil {
+		return err
+	}
+	date := time.Now().UTC().Format(http.TimeFormat)
+	req, err := http.NewRequest("POST", a.url, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Log-Type", logType)
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("time-generated-field", a.route.Target.FieldName("timestamp"))
+	req.Header.Set("Authorization", "SharedKey "+a.route.Target.Addr+":"+signature(key, body.Len(), date))
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// returns the base64 HMAC-SHA256 of a request's signed parts, as the Data
+// Collector API's SharedKey authorization has it
+func signature(key []byte, length int, date string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("POST\n" + strconv.Itoa(length) + "\napplication/json\nx-ms-date:" + date + "\n/api/logs"))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
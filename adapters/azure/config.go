@@ -0,0 +1,114 @@
+package azure
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// Config configures azure targets, which send lines to a Log
+// Analytics workspace with the Data Collector API, as their target's azure
+// options
+type Config struct {
+	// the workspace's primary or secondary key, base64 encoded as the
+	// portal shows it
+	SharedKey string `json:"shared_key,omitempty"`
+	// file the key is read from for each request, instead of SharedKey, so
+	// it can be rotated
+	SharedKeyFile string `json:"shared_key_file,omitempty"`
+	// custom log type lines are recorded as, which Log Analytics suffixes
+	// with _CL for the table name. Logspout by default. It's a template
+	// like Target.Template if it has actions, so lines can be spread
+	// across tables.
+	LogType string `json:"log_type,omitempty"`
+	// domain of the workspace's endpoint, ods.opinsights.azure.com, Azure's
+	// public cloud, by default
+	Domain string `json:"domain,omitempty"`
+
+	logType *template.Template
+}
+
+// letters, digits and underscores, as Log Analytics allows in log types
+var azureLogType = regexp.MustCompile(`^[A-Za-z0-9_]{1,100}$`)
+
+func (c *Config) Validate() error {
+	if (c.SharedKey == "") == (c.SharedKeyFile == "") {
+		return fmt.Errorf("azure must set one of shared_key and shared_key_file")
+	}
+	if c.SharedKey != "" {
+		if _, err := base64.StdEncoding.DecodeString(c.SharedKey); err != nil {
+			return fmt.Errorf("azure shared_key must be base64")
+		}
+	}
+	c.logType = nil
+	if strings.Contains(c.LogType, "{{") {
+		tmpl, err := router.CompileTemplate(c.LogType)
+		if err != nil {
+			return fmt.Errorf("azure log_type: %s", err)
+		}
+		c.logType = tmpl
+	} else if c.LogType != "" && !azureLogType.MatchString(c.LogType) {
+		return fmt.Errorf("azure log_type must be up to 100 letters, digits and underscores, not %q", c.LogType)
+	}
+	if strings.ContainsAny(c.Domain, "/:") {
+		return fmt.Errorf("azure domain must be a domain name, not %q", c.Domain)
+	}
+	return nil
+}
+
+// returns a copy with the shared key replaced
+func (c *Config) Redacted() router.TargetOptions {
+	copied := *c
+	if c.SharedKey != "" {
+		copied.SharedKey = router.RedactedSecret
+	}
+	return &copied
+}
+
+// returns the shared key file
+func (c *Config) CredentialFiles() []string {
+	if c.SharedKeyFile == "" {
+		return nil
+	}
+	return []string{c.SharedKeyFile}
+}
+
+// returns the log type a line is recorded as, or fallback if LogType is
+// empty
+func (c *Config) LogTypeFor(logline *attach.Log, fallback string) (string, error) {
+	if c.logType == nil {
+		if c.LogType == "" {
+			return fallback, nil
+		}
+		return c.LogType, nil
+	}
+	logType, err := router.RenderTemplate(c.logType, logline)
+	if err == nil && !azureLogType.MatchString(logType) {
+		err = fmt.Errorf("azure log_type rendered %q, which isn't letters, digits and underscores", logType)
+	}
+	return logType, err
+}
+
+// returns the decoded shared key, reading it from SharedKeyFile if that's
+// set
+func (c *Config) CurrentSharedKey() ([]byte, error) {
+	encoded := c.SharedKey
+	if c.SharedKeyFile != "" {
+		data, err := os.ReadFile(c.SharedKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("azure shared_key_file: %s", err)
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("azure shared key must be base64")
+	}
+	return key, nil
+}
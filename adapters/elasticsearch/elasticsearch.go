@@ -0,0 +1,293 @@
+// Package elasticsearch adds the es and es+tls target types, indexing lines
+// in Elasticsearch.
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// whether to discover the cluster's other nodes from the ones configured,
+// from ES_SNIFF
+var Sniff = false
+
+// credentials requests are made with, from ES_USERNAME and ES_PASSWORD or
+// ES_API_KEY. They're read for each request, so they can be changed as
+// secrets are rotated.
+var credentials struct {
+	sync.RWMutex
+	username, password, apiKey string
+}
+
+// sets the credentials requests are made with from now on
+func SetCredentials(username, password, apiKey string) {
+	credentials.Lock()
+	defer credentials.Unlock()
+	credentials.username, credentials.password, credentials.apiKey = username, password, apiKey
+}
+
+// authTransport adds the current credentials to requests
+type authTransport struct {
+	http.RoundTripper
+}
+
+func (t authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	credentials.RLock()
+	username, password, apiKey := credentials.username, credentials.password, credentials.apiKey
+	credentials.RUnlock()
+	if apiKey != "" || username != "" {
+		req = req.Clone(req.Context())
+		if apiKey != "" {
+			req.Header.Set("Authorization", "ApiKey "+apiKey)
+		} else {
+			req.SetBasicAuth(username, password)
+		}
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+var bulkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "logspout",
+	Name:      "bulk_request_duration_seconds",
+	Help:      "Latency of bulk requests to Elasticsearch.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"route"})
+
+func init() {
+	prometheus.MustRegister(bulkDuration)
+	for _, scheme := range []string{"es", "es+tls"} {
+		router.RegisterAdapter(scheme, router.AdapterType{
+			New:            newElasticsearchAdapter,
+			DefaultPort:    "9200",
+			DefaultParsers: []string{"json"},
+			// Elasticsearch only accepts gzip request bodies
+			Compressions: []string{"gzip"},
+			Signs:        true,
+			// the bulk indexer flushes by size and interval
+			BatchesByBytes: true,
+		})
+	}
+}
+
+// context key for the time a bulk flush started
+type flushStartKey struct{}
+
+// elasticsearchAdapter indexes lines as documents through the bulk API, in
+// daily logstash-YYYY.MM.DD indexes
+type elasticsearchAdapter struct {
+	route   *router.Route
+	indexer esutil.BulkIndexer
+	log     *slog.Logger
+	debug   bool
+}
+
+func newElasticsearchAdapter(route *router.Route) (router.Adapter, error) {
+	target := route.Target
+	esLog := logging.Logger("elasticsearch")
+	// node hostnames are kept unresolved and idle connections are recycled,
+	// so the transport re-resolves them as connections are re-established
+	resolver, err := router.NewAddrResolver(target, 0)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http://"
+	if target.UsesTLS() {
+		scheme = "https://"
+	}
+	var addrs []string
+	for _, hostport := range resolver.Hosts() {
+		addrs = append(addrs, scheme+hostport)
+	}
+	proxy, err := target.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	cfg := elasticsearch.Config{
+		Addresses:            addrs,
+		DiscoverNodesOnStart: Sniff,
+		Transport: route.SigningTransport(authTransport{&http.Transport{
+			Proxy:                 proxy,
+			DialContext:           route.Conn().Dialer().DialContext,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       router.ResolveInterval,
+			ResponseHeaderTimeout: route.Conn().WriteTimeoutDuration(),
+			TLSClientConfig:       route.TLSConfig(""),
+		}}),
+		CompressRequestBody: target.Compression == "gzip",
+	}
+	if Sniff {
+		cfg.DiscoverNodesInterval = 5 * time.Minute
+	}
+	client, err := elasticsearch.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	indexer, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Client:        client,
+		NumWorkers:    max(target.Workers, 1),
+		FlushBytes:    route.Batch().MaxBytes,
+		FlushInterval: route.Batch().Latency(),
+		OnError: func(ctx context.Context, err error) {
+			// items of a failed flush are also reported to onFailure
+			esLog.Error("bulk request failed", "route", route.ID, "err", err)
+		},
+		OnFlushStart: func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, flushStartKey{}, time.Now())
+		},
+		OnFlushEnd: func(ctx context.Context) {
+			if start, ok := ctx.Value(flushStartKey{}).(time.Time); ok {
+				bulkDuration.WithLabelValues(route.ID).Observe(time.Since(start).Seconds())
+				route.Status().SendDuration(time.Since(start))
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &elasticsearchAdapter{route: route, indexer: indexer, log: esLog, debug: logging.Debugging("elasticsearch")}, nil
+}
+
+func (a *elasticsearchAdapter) Stream(logstream chan *attach.Log) {
+	route, target, indexer, esLog, debug := a.route, a.route.Target, a.indexer, a.log, a.debug
+	defer indexer.Close(context.Background())
+	// each worker flushes once it holds a batch's bytes
+	route.Status().SetQueueCapacity(0, max(target.Workers, 1)*route.Batch().MaxBytes)
+
+	if debug {
+		// stopped once the route's lines are all indexed
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					stats := indexer.Stats()
+					esLog.Debug("indexer stats", "route", route.ID, "indexed", stats.NumIndexed, "failed", stats.NumFailed)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	onFailure := func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+		if err == nil {
+			err = fmt.Errorf("failed to index into %s: %s %s", res.Index, res.Error.Type, res.Error.Reason)
+		}
+		esLog.Error("indexing failed", "route", route.ID, "err", err)
+		route.Status().Failed(err)
+		route.Status().Dropped("error", 1)
+	}
+
+	const indexDateStampLayout = "2006.01.02"
+	// index names only change daily, so the last one is kept
+	var index string
+	var indexDay [3]int
+	for logline := range logstream {
+		logline, ok := route.Process(logline)
+		if !ok {
+			continue
+		}
+
+		k8sContainer := attach.CachedK8sContainer(logline.Name)
+		if debug {
+			esLog.Debug("container", "name", logline.Name, "k8s", k8sContainer)
+		}
+
+		timestamp := logline.Timestamp
+		if year, month, day := timestamp.Date(); index == "" || indexDay != [3]int{year, int(month), day} {
+			index, indexDay = "logstash-"+timestamp.Format(indexDateStampLayout), [3]int{year, int(month), day}
+		}
+		doc := router.GetDoc()
+		fillDoc(target, logline, timestamp, k8sContainer, doc)
+		body := router.GetBuffer()
+		err := json.NewEncoder(body).Encode(doc)
+		if debug {
+			esLog.Debug("indexing", "route", route.ID, "doc", doc)
+		}
+		router.PutDoc(doc)
+		if err != nil {
+			esLog.Error("encoding failed", "route", route.ID, "err", err)
+			route.Status().Dropped("encoding", 1)
+			router.PutBuffer(body)
+			continue
+		}
+		// the bulk body adds its own newline
+		body.Truncate(body.Len() - 1)
+		size := body.Len()
+		route.Status().Queued(1, size)
+		err = indexer.Add(context.Background(), esutil.BulkIndexerItem{
+			Action: "index",
+			Index:  index,
+			Body:   bytes.NewReader(body.Bytes()),
+			OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+				route.Status().Dequeued(1, size)
+				route.Status().Sent(size)
+				router.PutBuffer(body)
+			},
+			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+				route.Status().Dequeued(1, size)
+				onFailure(ctx, item, res, err)
+				router.PutBuffer(body)
+			},
+		})
+		if err != nil {
+			esLog.Error("queueing failed", "route", route.ID, "err", err)
+			route.Status().Dequeued(1, size)
+			route.Status().Failed(err)
+			route.Status().Dropped("error", 1)
+			router.PutBuffer(body)
+			continue
+		}
+	}
+}
+
+// fills doc with what's indexed for a line: its fields, with the message,
+// timestamp, container and host added and renamed by the target's
+// field_names, and the target's static fields
+func fillDoc(target router.Target, logline *attach.Log, timestamp time.Time, k8sContainer *attach.K8sContainer, doc map[string]interface{}) {
+	for field, value := range logline.Fields {
+		doc[field] = value
+	}
+	// lines are indexed with their message unless they have one, or a parser
+	// made their data the document
+	if _, present := doc[target.FieldName("message")]; !present && !logline.Document {
+		doc[target.FieldName("message")] = logline.Data
+	}
+	if _, present := doc[target.FieldName("@timestamp")]; !present {
+		doc[target.FieldName("@timestamp")] = timestamp
+	}
+	if logline.Truncated {
+		doc[target.FieldName("truncated")] = true
+	}
+	doc[target.FieldName("container")] = logline.Name
+	doc[target.FieldName("image")] = logline.Image
+	// lines received from elsewhere, as by GELF, keep their own host
+	if _, present := doc[target.FieldName("host")]; !present {
+		doc[target.FieldName("host")] = router.Hostname
+	}
+	if k8sContainer != nil {
+		doc[target.FieldName("k8s_pod")] = k8sContainer.Pod
+		doc[target.FieldName("k8s_container")] = k8sContainer.Name
+		doc[target.FieldName("k8s_namespace")] = k8sContainer.Namespace
+	}
+	for field, value := range target.Fields {
+		doc[field] = value
+	}
+}
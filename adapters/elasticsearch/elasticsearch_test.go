@@ -0,0 +1,39 @@
+package elasticsearch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func TestFillDocMessage(t *testing.T) {
+	renamed := router.Target{FieldNames: map[string]string{"message": "log"}}
+	tests := []struct {
+		name    string
+		target  router.Target
+		logline attach.Log
+		key     string
+		// the indexed message, or nil for none
+		want interface{}
+	}{
+		{"plain", router.Target{}, attach.Log{Data: "hello"}, "message", "hello"},
+		// parsers none, broken JSON or JSON cut short leave the line unparsed
+		{"unparsed", router.Target{}, attach.Log{Data: `{"level":"info"`}, "message", `{"level":"info"`},
+		{"document", router.Target{}, attach.Log{Data: `{"level":"info"}`, Fields: map[string]interface{}{"level": "info"}, Document: true}, "message", nil},
+		{"own message", router.Target{}, attach.Log{Data: `{"message":"hi"}`, Fields: map[string]interface{}{"message": "hi"}, Document: true}, "message", "hi"},
+		{"renamed", renamed, attach.Log{Data: "hello"}, "log", "hello"},
+		{"renamed own message", renamed, attach.Log{Data: "hello", Fields: map[string]interface{}{"log": "kept"}}, "log", "kept"},
+	}
+	for _, test := range tests {
+		doc := make(map[string]interface{})
+		fillDoc(test.target, &test.logline, time.Now(), nil, doc)
+		if doc[test.key] != test.want {
+			t.Errorf("%s: got %s %v, want %v", test.name, test.key, doc[test.key], test.want)
+		}
+		if test.key != "message" && doc["message"] != nil {
+			t.Errorf("%s: got message %v as well as %s", test.name, doc["message"], test.key)
+		}
+	}
+}
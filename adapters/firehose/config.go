@@ -0,0 +1,28 @@
+package firehose
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Config configures firehose targets, which put lines as records to
+// an Amazon Data Firehose delivery stream, as their target's firehose
+// options
+type Config struct {
+	// region of the delivery stream, the AWS_REGION or shared config one
+	// by default
+	Region string `json:"region,omitempty"`
+	// URL of the Firehose API to use instead of the region's, such as a VPC
+	// endpoint's
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("firehose endpoint must be an http or https URL, not %q", c.Endpoint)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package firehose adds the firehose target type, putting lines as records
+// to an Amazon Data Firehose delivery stream.
+package firehose
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/firehose"
+	"github.com/aws/aws-sdk-go-v2/service/firehose/types"
+
+	"github.com/jimmidyson/logspout/adapters/s3"
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// PutRecordBatch's limits
+const (
+	maxBatchRecords = 500
+	maxBatchBytes   = 4 << 20
+	maxRecordBytes  = 1000 << 10
+)
+
+// how often records Firehose fails in a batch are put again, and how long
+// to wait before the first retry, doubling for each after
+const (
+	maxRetries   = 3
+	retryBackoff = 200 * time.Millisecond
+)
+
+// what delivery stream names can be
+var streamName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+func init() {
+	router.RegisterAdapter("firehose", router.AdapterType{
+		New:          newFirehoseAdapter,
+		ValidateAddr: validateAddr,
+		Options:      "firehose",
+	})
+	router.RegisterTargetOptions("firehose", func() router.TargetOptions { return new(Config) })
+}
+
+// checks an address is a delivery stream name
+func validateAddr(addr string) error {
+	if !streamName.MatchString(addr) {
+		return fmt.Errorf("firehose addr must be a delivery stream name, not %q", addr)
+	}
+	return nil
+}
+
+// firehoseAdapter puts batches of lines as records, a JSON line each
+type firehoseAdapter struct {
+	route  *router.Route
+	client *firehose.Client
+}
+
+func newFirehoseAdapter(route *router.Route) (router.Adapter, error) {
+	var config Config
+	if options, ok := route.Target.Options("firehose").(*Config); ok {
+		config = *options
+	}
+	awsConfig, err := s3.AWSConfig(route, config.Region)
+	if err != nil {
+		return nil, err
+	}
+	client := firehose.NewFromConfig(awsConfig, func(o *firehose.Options) {
+		if config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(config.Endpoint)
+		}
+	})
+	return &firehoseAdapter{route: route, client: client}, nil
+}
+
+func (a *firehoseAdapter) Stream(logstream chan *attach.Log) {
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.route.Target.LineEncoder())
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		return a.send, func() {}
+	})
+}
+
+// puts a batch in as few requests as PutRecordBatch's limits allow. Lines
+// too big for a record are dropped.
+func (a *firehoseAdapter) send(batch []*router.BatchItem) {
+	var chunk []*router.BatchItem
+	size := 0
+	for _, item := range batch {
+		if item.Buf.Len() > maxRecordBytes {
+			err := fmt.Errorf("line of %d bytes is larger than the %d a record can be", item.Buf.Len(), maxRecordBytes)
+			logging.Logger("firehose").Error("send failed", "route", a.route.ID, "err", err)
+			a.route.Status().Failed(err)
+			a.route.Status().Dropped("error", 1)
+			continue
+		}
+		if len(chunk) == maxBatchRecords || size+item.Buf.Len() > maxBatchBytes {
+			a.put(chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, item)
+		size += item.Buf.Len()
+	}
+	if len(chunk) > 0 {
+		a.put(chunk)
+	}
+}
+
+// puts items as one batch of records, putting those Firehose fails again
+// up to maxRetries times
+func (a *firehoseAdapter) put(items []*router.BatchItem) {
+	ctx := context.Background()
+	if timeout := a.route.Conn().WriteTimeoutDuration(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var err error
+	for attempt := 0; len(items) > 0 && attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(retryBackoff << (attempt - 1)):
+			case <-ctx.Done():
+			}
+		}
+		var failed []*router.BatchItem
+		if failed, err = a.putRecords(ctx, items); err != nil {
+			// the SDK has already retried the request
+			break
+		}
+		items = failed
+	}
+	if len(items) > 0 {
+		if err == nil {
+			err = fmt.Errorf("%d records failed after %d retries", len(items), maxRetries)
+		}
+		logging.Logger("firehose").Error("send failed", "route", a.route.ID, "err", err)
+		a.route.Status().Failed(err)
+		a.route.Status().Dropped("error", len(items))
+	}
+}
+
+// puts items as records, counting those put as sent, and returns those
+// Firehose failed
+func (a *firehoseAdapter) putRecords(ctx context.Context, items []*router.BatchItem) ([]*router.BatchItem, error) {
+	records := make([]types.Record, len(items))
+	for i, item := range items {
+		records[i] = types.Record{Data: item.Buf.Bytes()}
+	}
+	output, err := a.client.PutRecordBatch(ctx, &firehose.PutRecordBatchInput{
+		DeliveryStreamName: aws.String(a.route.Target.Addr),
+		Records:            records,
+	})
+	if err != nil {
+		return items, err
+	}
+	var failed []*router.BatchItem
+	for i, result := range output.RequestResponses {
+		if result.ErrorCode != nil {
+			failed = append(failed, items[i])
+			continue
+		}
+		a.route.Status().Sent(items[i].Buf.Len())
+	}
+	return failed, nil
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// the schema avro records are written with. Fields that aren't strings are
+// written as their JSON.
+const avroSchema = `{"type": "record", "name": "Log", "namespace": "io.logspout", "fields": [
+	{"name": "id", "type": "string"},
+	{"name": "name", "type": "string"},
+	{"name": "image", "type": "string"},
+	{"name": "type", "type": "string"},
+	{"name": "data", "type": "string"},
+	{"name": "time", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+	{"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+	{"name": "severity", "type": "string", "default": ""},
+	{"name": "truncated", "type": "boolean", "default": false},
+	{"name": "fields", "type": {"type": "map", "values": "string"}, "default": {}}
+]}`
+
+// writes a line as an avro binary record of avroSchema
+func encodeAvro(logline *attach.Log, buf *bytes.Buffer) error {
+	b := buf.AvailableBuffer()
+	for _, s := range []string{logline.ID, logline.Name, logline.Image, logline.Type, logline.Data} {
+		b = appendAvroString(b, s)
+	}
+	b = binary.AppendVarint(b, logline.Time.UnixMicro())
+	b = binary.AppendVarint(b, logline.Timestamp.UnixMicro())
+	b = appendAvroString(b, logline.Severity)
+	if logline.Truncated {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	// maps are written as a block of their entries, then an empty block
+	if len(logline.Fields) > 0 {
+		b = binary.AppendVarint(b, int64(len(logline.Fields)))
+		keys := make([]string, 0, len(logline.Fields))
+		for key := range logline.Fields {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			value, ok := logline.Fields[key].(string)
+			if !ok {
+				encoded, err := json.Marshal(logline.Fields[key])
+				if err != nil {
+					return err
+				}
+				value = string(encoded)
+			}
+			b = appendAvroString(appendAvroString(b, key), value)
+		}
+	}
+	b = append(b, 0)
+	buf.Write(b)
+	return nil
+}
+
+// avro writes longs zig-zag varint encoded, as binary.AppendVarint does,
+// and strings as their length followed by their bytes
+func appendAvroString(b []byte, s string) []byte {
+	return append(binary.AppendVarint(b, int64(len(s))), s...)
+}
@@ -0,0 +1,195 @@
+package kafka
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// Config configures kafka and kafka+tls targets, as their target's kafka
+// options
+type Config struct {
+	// topic lines are produced to, logspout by default. It's a template
+	// like Target.Template if it has actions, so lines can be spread
+	// across topics.
+	Topic string `json:"topic,omitempty"`
+	// what records are keyed by, which decides their partition: container
+	// for the container's ID, the default, or pod for its Kubernetes pod,
+	// either of which keep each container's lines in order, or a template
+	Key string `json:"key,omitempty"`
+	// acknowledgement a record is sent once it has: all, the default, once
+	// every in-sync replica has it, leader once the partition's leader has
+	// it, or none, which doesn't wait
+	Acks string `json:"acks,omitempty"`
+	// turns off idempotent production, which with acks all has the brokers
+	// discard records resent after a failover, for brokers or ACLs that
+	// don't allow it. It's always off for other acks.
+	DisableIdempotence bool `json:"disable_idempotence,omitempty"`
+	// how the brokers are authenticated with, if they require it
+	SASL *SASLConfig `json:"sasl,omitempty"`
+	// how records are encoded: json by default, or avro, whose schema is
+	// registered with SchemaRegistry
+	Encoding string `json:"encoding,omitempty"`
+	// where the schema of avro records is registered
+	SchemaRegistry *SchemaRegistryConfig `json:"schema_registry,omitempty"`
+
+	topic, key *template.Template
+}
+
+// SchemaRegistryConfig is a Confluent Schema Registry, which avro records'
+// schema is registered with under the subject <topic>-value
+type SchemaRegistryConfig struct {
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// SASLConfig authenticates with Kafka brokers. Over kafka+tls, a cert and
+// key in the target's tls authenticate with mutual TLS instead, or as well.
+type SASLConfig struct {
+	// plain, scram-sha-256 or scram-sha-512
+	Mechanism string `json:"mechanism"`
+	Username  string `json:"username"`
+	Password  string `json:"password,omitempty"`
+	// file the password is read from each time the brokers are
+	// authenticated with, instead of Password, so it can be rotated
+	PasswordFile string `json:"password_file,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	c.topic, c.key = nil, nil
+	if strings.Contains(c.Topic, "{{") {
+		tmpl, err := router.CompileTemplate(c.Topic)
+		if err != nil {
+			return fmt.Errorf("kafka topic: %s", err)
+		}
+		c.topic = tmpl
+	}
+	switch c.Key {
+	case "", "container", "pod":
+	default:
+		tmpl, err := router.CompileTemplate(c.Key)
+		if err != nil {
+			return fmt.Errorf("kafka key: %s", err)
+		}
+		c.key = tmpl
+	}
+	switch c.Acks {
+	case "", "all", "leader", "none":
+	default:
+		return fmt.Errorf("kafka acks must be all, leader or none, not %q", c.Acks)
+	}
+	switch c.Encoding {
+	case "", "json":
+		if c.SchemaRegistry != nil {
+			return fmt.Errorf("kafka schema_registry is only used by the avro encoding")
+		}
+	case "avro":
+		if c.SchemaRegistry == nil || c.SchemaRegistry.URL == "" {
+			return fmt.Errorf("kafka encoding avro requires a schema_registry url")
+		}
+		u, err := url.Parse(c.SchemaRegistry.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("kafka schema_registry url must be an http or https URL, not %q", c.SchemaRegistry.URL)
+		}
+	default:
+		return fmt.Errorf("kafka encoding must be json or avro, not %q", c.Encoding)
+	}
+	if c.SASL != nil {
+		return c.SASL.normalize()
+	}
+	return nil
+}
+
+// returns a copy with the SASL and schema registry passwords replaced
+func (c *Config) Redacted() router.TargetOptions {
+	copied := *c
+	if c.SASL != nil && c.SASL.Password != "" {
+		sasl := *c.SASL
+		sasl.Password = router.RedactedSecret
+		copied.SASL = &sasl
+	}
+	if c.SchemaRegistry != nil && c.SchemaRegistry.Password != "" {
+		registry := *c.SchemaRegistry
+		registry.Password = router.RedactedSecret
+		copied.SchemaRegistry = &registry
+	}
+	return &copied
+}
+
+// returns the SASL password file
+func (c *Config) CredentialFiles() []string {
+	if c.SASL == nil || c.SASL.PasswordFile == "" {
+		return nil
+	}
+	return []string{c.SASL.PasswordFile}
+}
+
+func (c *SASLConfig) normalize() error {
+	c.Mechanism = strings.ToLower(c.Mechanism)
+	switch c.Mechanism {
+	case "plain", "scram-sha-256", "scram-sha-512":
+	default:
+		return fmt.Errorf("sasl mechanism must be plain, scram-sha-256 or scram-sha-512, not %q", c.Mechanism)
+	}
+	if c.Username == "" {
+		return fmt.Errorf("sasl username is required")
+	}
+	if (c.Password == "") == (c.PasswordFile == "") {
+		return fmt.Errorf("sasl must set one of password and password_file")
+	}
+	return nil
+}
+
+// returns the topic a line is produced to, or fallback if Topic is empty
+func (c *Config) TopicFor(logline *attach.Log, fallback string) (string, error) {
+	if c.topic == nil {
+		if c.Topic == "" {
+			return fallback, nil
+		}
+		return c.Topic, nil
+	}
+	topic, err := router.RenderTemplate(c.topic, logline)
+	if err == nil && topic == "" {
+		err = fmt.Errorf("kafka topic rendered empty")
+	}
+	return topic, err
+}
+
+// reports whether records are produced idempotently, so retries after a
+// broker failover don't duplicate them
+func (c *Config) Idempotent() bool {
+	return (c.Acks == "" || c.Acks == "all") && !c.DisableIdempotence
+}
+
+// returns the key a line's record has
+func (c *Config) KeyFor(logline *attach.Log) ([]byte, error) {
+	switch {
+	case c.key != nil:
+		key, err := router.RenderTemplate(c.key, logline)
+		return []byte(key), err
+	case c.Key == "pod":
+		// containers not started by Kubernetes are their own pod
+		if k8s := attach.CachedK8sContainer(logline.Name); k8s != nil {
+			return []byte(k8s.Namespace + "/" + k8s.Pod), nil
+		}
+	}
+	return []byte(logline.ID), nil
+}
+
+// returns the password, reading it from PasswordFile if that's set
+func (c *SASLConfig) CurrentPassword() (string, error) {
+	if c.PasswordFile == "" {
+		return c.Password, nil
+	}
+	data, err := os.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("sasl password_file: %s", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,209 @@
+// Package kafka adds the kafka and kafka+tls target types, producing lines
+// as JSON or avro records to a Kafka topic.
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// topic of targets that don't set one
+const defaultTopic = "logspout"
+
+var compressions = map[string]kgo.CompressionCodec{
+	"gzip":   kgo.GzipCompression(),
+	"snappy": kgo.SnappyCompression(),
+	"lz4":    kgo.Lz4Compression(),
+	"zstd":   kgo.ZstdCompression(),
+}
+
+func init() {
+	for _, scheme := range []string{"kafka", "kafka+tls"} {
+		router.RegisterAdapter(scheme, router.AdapterType{
+			New:          newKafkaAdapter,
+			DefaultPort:  "9092",
+			Compressions: []string{"gzip", "snappy", "lz4", "zstd"},
+			Signs:        true,
+			Options:      "kafka",
+		})
+	}
+	router.RegisterTargetOptions("kafka", func() router.TargetOptions { return new(Config) })
+}
+
+// kafkaAdapter produces batches of lines to a topic, keyed by container or
+// pod by default so each container's lines stay in order on one partition
+type kafkaAdapter struct {
+	route  *router.Route
+	client *kgo.Client
+	config Config
+	// where avro records' schema is registered, nil for JSON records
+	registry *schemaRegistry
+}
+
+func newKafkaAdapter(route *router.Route) (router.Adapter, error) {
+	target := route.Target
+	brokers, err := target.HostPorts()
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if options, ok := target.Options("kafka").(*Config); ok {
+		config = *options
+	}
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(brokers...),
+		kgo.ProducerBatchCompression(kgo.NoCompression()),
+	}
+	if target.UsesTLS() {
+		// the server name is each broker's host, unless tls sets one
+		dialer := &tls.Dialer{NetDialer: route.Conn().Dialer(), Config: route.TLSConfig("")}
+		opts = append(opts, kgo.Dialer(dialer.DialContext))
+	} else {
+		opts = append(opts, kgo.Dialer(route.Conn().Dialer().DialContext))
+	}
+	if timeout := route.Conn().WriteTimeoutDuration(); timeout > 0 {
+		opts = append(opts, kgo.RecordDeliveryTimeout(timeout))
+	}
+	if codec, ok := compressions[target.Compression]; ok {
+		opts = append(opts, kgo.ProducerBatchCompression(codec))
+	}
+	if config.SASL != nil {
+		opts = append(opts, kgo.SASL(saslMechanism(config.SASL)))
+	}
+	switch config.Acks {
+	case "leader":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()))
+	case "none":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()))
+	default:
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	}
+	if !config.Idempotent() {
+		// the client is idempotent unless told otherwise, which only
+		// acks all allows
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+	adapter := &kafkaAdapter{route: route, config: config}
+	if config.Encoding == "avro" {
+		if adapter.registry, err = newSchemaRegistry(route, config.SchemaRegistry); err != nil {
+			return nil, err
+		}
+	}
+	if adapter.client, err = kgo.NewClient(opts...); err != nil {
+		return nil, err
+	}
+	return adapter, nil
+}
+
+// returns the mechanism for the route's SASL settings, which reads the
+// password each time it authenticates
+func saslMechanism(config *SASLConfig) sasl.Mechanism {
+	switch config.Mechanism {
+	case "plain":
+		return plain.Plain(func(context.Context) (plain.Auth, error) {
+			password, err := config.CurrentPassword()
+			return plain.Auth{User: config.Username, Pass: password}, err
+		})
+	case "scram-sha-512":
+		return scram.Sha512(func(context.Context) (scram.Auth, error) {
+			password, err := config.CurrentPassword()
+			return scram.Auth{User: config.Username, Pass: password}, err
+		})
+	}
+	return scram.Sha256(func(context.Context) (scram.Auth, error) {
+		password, err := config.CurrentPassword()
+		return scram.Auth{User: config.Username, Pass: password}, err
+	})
+}
+
+func (a *kafkaAdapter) Stream(logstream chan *attach.Log) {
+	defer a.client.Close()
+	items := make(chan *router.BatchItem)
+	encode := func(logline *attach.Log, buf *bytes.Buffer) error {
+		return json.NewEncoder(buf).Encode(logline)
+	}
+	if a.registry != nil {
+		encode = encodeAvro
+	}
+	go a.route.EncodeLines(logstream, items, encode)
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		return a.send, func() {}
+	})
+}
+
+// produces a batch, waiting until the brokers have acknowledged it
+func (a *kafkaAdapter) send(batch []*router.BatchItem) {
+	records := make([]*kgo.Record, 0, len(batch))
+	failed := 0
+	var firstErr error
+	for _, item := range batch {
+		record, err := a.record(item)
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+	for _, result := range a.client.ProduceSync(context.Background(), records...) {
+		if result.Err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = result.Err
+			}
+			continue
+		}
+		a.route.Status().Sent(len(result.Record.Value))
+	}
+	if failed > 0 {
+		err := fmt.Errorf("%d of %d records failed: %s", failed, len(batch), firstErr)
+		logging.Logger("kafka").Error("produce failed", "route", a.route.ID, "err", err)
+		a.route.Status().Failed(err)
+		a.route.Status().Dropped("error", failed)
+	}
+}
+
+// returns the record for an encoded line, in the topic and with the key the
+// target's settings give it
+func (a *kafkaAdapter) record(item *router.BatchItem) (*kgo.Record, error) {
+	topic, err := a.config.TopicFor(item.Log, defaultTopic)
+	if err != nil {
+		return nil, err
+	}
+	key, err := a.config.KeyFor(item.Log)
+	if err != nil {
+		return nil, fmt.Errorf("kafka key: %s", err)
+	}
+	record := &kgo.Record{
+		Topic: topic,
+		Key:   key,
+		Value: bytes.TrimSuffix(item.Buf.Bytes(), []byte("\n")),
+	}
+	if a.registry != nil {
+		header, err := a.registry.header(topic)
+		if err != nil {
+			return nil, err
+		}
+		// avro records are prefixed with their schema, as Confluent's
+		// deserializers expect
+		record.Value = append(header, record.Value...)
+	}
+	if header, signature := a.route.Sign(record.Value); header != "" {
+		record.Headers = []kgo.RecordHeader{{Key: header, Value: []byte(signature)}}
+	}
+	return record, nil
+}
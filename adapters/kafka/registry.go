@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/jimmidyson/logspout/router"
+)
+
+// schemaRegistry registers avroSchema with a Confluent Schema Registry,
+// caching the ID it's given for each subject
+type schemaRegistry struct {
+	config *SchemaRegistryConfig
+	client *http.Client
+
+	sync.Mutex
+	ids map[string]uint32
+}
+
+func newSchemaRegistry(route *router.Route, config *SchemaRegistryConfig) (*schemaRegistry, error) {
+	proxy, err := route.Target.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Timeout: route.Conn().WriteTimeoutDuration(),
+		Transport: &http.Transport{
+			Proxy:       proxy,
+			DialContext: route.Conn().Dialer().DialContext,
+		},
+	}
+	return &schemaRegistry{config: config, client: client, ids: make(map[string]uint32)}, nil
+}
+
+// returns the header records of a topic start with: a zero magic byte and
+// the schema's ID for the topic's value subject
+func (r *schemaRegistry) header(topic string) ([]byte, error) {
+	id, err := r.id(topic + "-value")
+	if err != nil {
+		return nil, err
+	}
+	return binary.BigEndian.AppendUint32([]byte{0}, id), nil
+}
+
+// returns the schema's ID under subject, registering it the first time.
+// Registering a schema the subject already has returns its existing ID.
+func (r *schemaRegistry) id(subject string) (uint32, error) {
+	r.Lock()
+	defer r.Unlock()
+	if id, ok := r.ids[subject]; ok {
+		return id, nil
+	}
+	body, _ := json.Marshal(map[string]string{"schema": avroSchema})
+	endpoint := strings.TrimSuffix(r.config.URL, "/") + "/subjects/" + url.PathEscape(subject) + "/versions"
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if r.config.Username != "" {
+		req.SetBasicAuth(r.config.Username, r.config.Password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("schema registry: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("schema registry: registering %s: %s %s", subject, resp.Status, bytes.TrimSpace(message))
+	}
+	var registered struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&registered); err != nil {
+		return 0, fmt.Errorf("schema registry: %s", err)
+	}
+	r.ids[subject] = registered.ID
+	return registered.ID, nil
+}
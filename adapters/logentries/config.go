@@ -0,0 +1,31 @@
+package logentries
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jimmidyson/logspout/router"
+)
+
+// Config configures logentries and logentries+tls targets, which
+// send lines to a Logentries or Rapid7 insightOps log by its token, as
+// their target's logentries options
+type Config struct {
+	// the log's token, which each line is prefixed with
+	Token string `json:"token"`
+}
+
+func (c *Config) Validate() error {
+	if c.Token == "" || strings.ContainsAny(c.Token, " \t\r\n") {
+		return fmt.Errorf("logentries token must be set, without spaces")
+	}
+	return nil
+}
+
+// returns a copy with the token replaced
+func (c *Config) Redacted() router.TargetOptions {
+	if c.Token == "" {
+		return c
+	}
+	return &Config{Token: router.RedactedSecret}
+}
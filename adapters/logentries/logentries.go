@@ -0,0 +1,69 @@
+// Package logentries adds the logentries and logentries+tls target types,
+// sending lines to a Logentries or Rapid7 insightOps log with its token.
+package logentries
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jimmidyson/logspout/adapters/tcp"
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func init() {
+	router.RegisterAdapter("logentries", router.AdapterType{New: newLogentriesAdapter, DefaultPort: "80", Options: "logentries"})
+	router.RegisterAdapter("logentries+tls", router.AdapterType{New: newLogentriesAdapter, DefaultPort: "443", Options: "logentries"})
+	router.RegisterTargetOptions("logentries", func() router.TargetOptions { return new(Config) })
+}
+
+// logentriesAdapter sends lines prefixed with the log's token, a line each,
+// over a connection per worker that's redialed when it fails
+type logentriesAdapter struct {
+	route    *router.Route
+	resolver *router.AddrResolver
+	token    string
+}
+
+func newLogentriesAdapter(route *router.Route) (router.Adapter, error) {
+	config, ok := route.Target.Options("logentries").(*Config)
+	if !ok {
+		return nil, fmt.Errorf("logentries targets need a logentries token")
+	}
+	resolver, err := router.NewAddrResolver(route.Target, router.ResolveInterval)
+	if err != nil {
+		return nil, err
+	}
+	return &logentriesAdapter{route: route, resolver: resolver, token: config.Token}, nil
+}
+
+func (a *logentriesAdapter) Stream(logstream chan *attach.Log) {
+	defer a.resolver.Stop()
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.encode)
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		conn := tcp.NewConn(a.route, a.resolver)
+		return conn.SendBatch("logentries"), conn.Close
+	})
+}
+
+// encodes a line as the token and either the line as JSON, which the log
+// indexes by field, or its rendering by the target's template, with line
+// breaks replaced by the unicode line separator that keeps a multiline
+// message one event
+func (a *logentriesAdapter) encode(logline *attach.Log, buf *bytes.Buffer) error {
+	buf.WriteString(a.token)
+	buf.WriteByte(' ')
+	if a.route.Target.Template == "" {
+		return json.NewEncoder(buf).Encode(logline)
+	}
+	text, err := a.route.Render(logline)
+	if err != nil {
+		return err
+	}
+	buf.WriteString(strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\u2028"))
+	buf.WriteByte('\n')
+	return nil
+}
@@ -0,0 +1,78 @@
+package mongodb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jimmidyson/logspout/router"
+)
+
+// Config configures mongodb and mongodb+tls targets, as their target's
+// mongodb options
+type Config struct {
+	// database and collection documents are inserted into, logspout and
+	// logs by default
+	Database   string `json:"database,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	// size in bytes of the capped collection created for documents if the
+	// collection doesn't exist, so the oldest are removed as newer ones
+	// are inserted. Zero leaves a missing collection to be created
+	// uncapped on the first insert.
+	CappedBytes int64 `json:"capped_bytes,omitempty"`
+	// most documents the capped collection holds, as well as CappedBytes
+	CappedDocuments int64 `json:"capped_documents,omitempty"`
+	// how the deployment is authenticated with, if it requires it
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// file the password is read from as the route starts, instead of
+	// Password
+	PasswordFile string `json:"password_file,omitempty"`
+	// database the user is defined in, admin by default
+	AuthSource string `json:"auth_source,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.CappedBytes < 0 || c.CappedDocuments < 0 {
+		return fmt.Errorf("mongodb capped_bytes and capped_documents must be positive")
+	}
+	if c.CappedDocuments > 0 && c.CappedBytes == 0 {
+		return fmt.Errorf("mongodb capped_documents requires capped_bytes")
+	}
+	if c.Password != "" && c.PasswordFile != "" {
+		return fmt.Errorf("mongodb must set at most one of password and password_file")
+	}
+	if c.Username == "" && (c.Password != "" || c.PasswordFile != "") {
+		return fmt.Errorf("mongodb password requires a username")
+	}
+	return nil
+}
+
+// returns a copy with the password replaced
+func (c *Config) Redacted() router.TargetOptions {
+	copied := *c
+	if c.Password != "" {
+		copied.Password = router.RedactedSecret
+	}
+	return &copied
+}
+
+// returns the password file
+func (c *Config) CredentialFiles() []string {
+	if c.PasswordFile == "" {
+		return nil
+	}
+	return []string{c.PasswordFile}
+}
+
+// returns the password, reading it from PasswordFile if that's set
+func (c *Config) CurrentPassword() (string, error) {
+	if c.PasswordFile == "" {
+		return c.Password, nil
+	}
+	data, err := os.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("mongodb password_file: %s", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,194 @@
+// Package mongodb adds the mongodb and mongodb+tls target types, inserting
+// lines as documents into a MongoDB collection.
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// the error code of creating a collection that exists
+const namespaceExists = 48
+
+func init() {
+	for _, scheme := range []string{"mongodb", "mongodb+tls"} {
+		router.RegisterAdapter(scheme, router.AdapterType{
+			New:            newMongoAdapter,
+			DefaultPort:    "27017",
+			DefaultParsers: []string{"json"},
+			Options:        "mongodb",
+		})
+	}
+	router.RegisterTargetOptions("mongodb", func() router.TargetOptions { return new(Config) })
+}
+
+// mongoAdapter inserts batches of lines as documents, creating a capped
+// collection for them first if the target asks for one
+type mongoAdapter struct {
+	route      *router.Route
+	client     *mongo.Client
+	config     Config
+	collection *mongo.Collection
+	// whether the capped collection is known to exist
+	created atomic.Bool
+}
+
+func newMongoAdapter(route *router.Route) (router.Adapter, error) {
+	target := route.Target
+	hosts, err := target.HostPorts()
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if configured, ok := target.Options("mongodb").(*Config); ok {
+		config = *configured
+	}
+	if config.Database == "" {
+		config.Database = "logspout"
+	}
+	if config.Collection == "" {
+		config.Collection = "logs"
+	}
+	opts := options.Client().
+		SetHosts(hosts).
+		SetDialer(route.Conn().Dialer()).
+		SetConnectTimeout(route.Conn().Dialer().Timeout).
+		SetTimeout(route.Conn().WriteTimeoutDuration())
+	if target.UsesTLS() {
+		opts.SetTLSConfig(route.TLSConfig(""))
+	}
+	if config.Username != "" {
+		password, err := config.CurrentPassword()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetAuth(options.Credential{Username: config.Username, Password: password, AuthSource: config.AuthSource})
+	}
+	// connecting doesn't wait for the deployment, which is dialed as
+	// documents are inserted
+	client, err := mongo.Connect(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &mongoAdapter{
+		route:      route,
+		client:     client,
+		config:     config,
+		collection: client.Database(config.Database).Collection(config.Collection),
+	}, nil
+}
+
+func (a *mongoAdapter) Stream(logstream chan *attach.Log) {
+	defer a.client.Disconnect(context.Background())
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.encode)
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		return a.send, func() {}
+	})
+}
+
+// encodes a line as a BSON document, with the line's parsed fields
+// alongside its container's
+func (a *mongoAdapter) encode(logline *attach.Log, buf *bytes.Buffer) error {
+	target := a.route.Target
+	doc := router.GetDoc()
+	defer router.PutDoc(doc)
+	for field, value := range logline.Fields {
+		doc[field] = value
+	}
+	doc[target.FieldName("timestamp")] = logline.Timestamp
+	doc[target.FieldName("message")] = logline.Data
+	doc[target.FieldName("container")] = logline.Name
+	doc[target.FieldName("container_id")] = logline.ID
+	doc[target.FieldName("image")] = logline.Image
+	doc[target.FieldName("stream")] = logline.Type
+	if logline.Severity != "" {
+		doc[target.FieldName("severity")] = logline.Severity
+	}
+	if logline.Truncated {
+		doc[target.FieldName("truncated")] = true
+	}
+	if k8s := attach.CachedK8sContainer(logline.Name); k8s != nil {
+		doc[target.FieldName("k8s_pod")] = k8s.Pod
+		doc[target.FieldName("k8s_container")] = k8s.Name
+		doc[target.FieldName("k8s_namespace")] = k8s.Namespace
+	}
+	for field, value := range target.Fields {
+		doc[field] = value
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+// inserts a batch's documents, unordered so one failing doesn't stop the
+// rest
+func (a *mongoAdapter) send(batch []*router.BatchItem) {
+	ctx := context.Background()
+	if err := a.createCapped(ctx); err != nil {
+		a.failed(fmt.Errorf("creating capped collection: %s", err), len(batch))
+		return
+	}
+	docs := make([]bson.Raw, len(batch))
+	for i, item := range batch {
+		docs[i] = bson.Raw(item.Buf.Bytes())
+	}
+	_, err := a.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	failed := make(map[int]bool)
+	var exception mongo.BulkWriteException
+	switch {
+	case err == nil:
+	case errors.As(err, &exception) && exception.WriteConcernError == nil:
+		for _, writeErr := range exception.WriteErrors {
+			failed[writeErr.Index] = true
+		}
+		a.failed(err, len(failed))
+	default:
+		a.failed(err, len(batch))
+		return
+	}
+	for i, item := range batch {
+		if !failed[i] {
+			a.route.Status().Sent(item.Buf.Len())
+		}
+	}
+}
+
+// creates the capped collection the first time documents are sent, if the
+// target asks for one and it doesn't exist
+func (a *mongoAdapter) createCapped(ctx context.Context) error {
+	if a.config.CappedBytes == 0 || a.created.Load() {
+		return nil
+	}
+	opts := options.CreateCollection().SetCapped(true).SetSizeInBytes(a.config.CappedBytes)
+	if a.config.CappedDocuments > 0 {
+		opts.SetMaxDocuments(a.config.CappedDocuments)
+	}
+	err := a.collection.Database().CreateCollection(ctx, a.config.Collection, opts)
+	var cmdErr mongo.CommandError
+	if err != nil && (!errors.As(err, &cmdErr) || cmdErr.Code != namespaceExists) {
+		return err
+	}
+	a.created.Store(true)
+	return nil
+}
+
+func (a *mongoAdapter) failed(err error, lines int) {
+	logging.Logger("mongodb").Error("insert failed", "route", a.route.ID, "err", err)
+	a.route.Status().Failed(err)
+	a.route.Status().Dropped("error", lines)
+}
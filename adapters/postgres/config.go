@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jimmidyson/logspout/router"
+)
+
+// Config configures postgres and postgres+tls targets, as their target's
+// postgres options
+type Config struct {
+	// database and table rows are copied into, logspout and logs by
+	// default. The table can be qualified by its schema, as in app.logs.
+	Database string `json:"database,omitempty"`
+	Table    string `json:"table,omitempty"`
+	// what each of the table's columns is set to, by column name: one of
+	// timestamp, message, container, container_id, image, stream,
+	// severity, k8s_namespace, k8s_pod and k8s_container, fields for all
+	// of the line's parsed fields as JSON, or fields.<name> for one of
+	// them. See defaultColumns for the default.
+	Columns map[string]string `json:"columns,omitempty"`
+	// whether to create the table if it doesn't exist, with timestamptz
+	// for timestamp columns, jsonb for fields and text for the rest
+	CreateTable bool   `json:"create_table,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"`
+	// file the password is read from each time the server is connected to,
+	// instead of Password, so it can be rotated
+	PasswordFile string `json:"password_file,omitempty"`
+}
+
+// columns of targets that don't set any
+var defaultColumns = map[string]string{
+	"time":      "timestamp",
+	"container": "container",
+	"image":     "image",
+	"stream":    "stream",
+	"severity":  "severity",
+	"message":   "message",
+	"fields":    "fields",
+}
+
+// what columns can be set to, besides fields.<name>
+var knownSources = map[string]bool{
+	"timestamp": true, "message": true, "container": true, "container_id": true,
+	"image": true, "stream": true, "severity": true, "k8s_namespace": true,
+	"k8s_pod": true, "k8s_container": true, "fields": true,
+}
+
+func (c *Config) Validate() error {
+	for column, source := range c.Columns {
+		if column == "" {
+			return fmt.Errorf("postgres column names can't be empty")
+		}
+		if name, ok := strings.CutPrefix(source, "fields."); ok && name != "" {
+			continue
+		}
+		if !knownSources[source] {
+			return fmt.Errorf("postgres column %s: unknown value %q", column, source)
+		}
+	}
+	if c.Password != "" && c.PasswordFile != "" {
+		return fmt.Errorf("postgres must set at most one of password and password_file")
+	}
+	return nil
+}
+
+// returns the table's column names, sorted, and what each is set to
+func (c *Config) ColumnSources() (columns, sources []string) {
+	mapping := c.Columns
+	if len(mapping) == 0 {
+		mapping = defaultColumns
+	}
+	for column := range mapping {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		sources = append(sources, mapping[column])
+	}
+	return columns, sources
+}
+
+// returns a copy with the password replaced
+func (c *Config) Redacted() router.TargetOptions {
+	copied := *c
+	if c.Password != "" {
+		copied.Password = router.RedactedSecret
+	}
+	return &copied
+}
+
+// returns the password file
+func (c *Config) CredentialFiles() []string {
+	if c.PasswordFile == "" {
+		return nil
+	}
+	return []string{c.PasswordFile}
+}
+
+// returns the password, reading it from PasswordFile if that's set
+func (c *Config) CurrentPassword() (string, error) {
+	if c.PasswordFile == "" {
+		return c.Password, nil
+	}
+	data, err := os.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("postgres password_file: %s", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
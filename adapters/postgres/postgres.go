@@ -0,0 +1,256 @@
+// Package postgres adds the postgres and postgres+tls target types, copying
+// lines as rows into a PostgreSQL table.
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func init() {
+	for _, scheme := range []string{"postgres", "postgres+tls"} {
+		router.RegisterAdapter(scheme, router.AdapterType{
+			New:            newPostgresAdapter,
+			DefaultPort:    "5432",
+			DefaultParsers: []string{"json"},
+			Options:        "postgres",
+		})
+	}
+	router.RegisterTargetOptions("postgres", func() router.TargetOptions { return new(Config) })
+}
+
+// postgresAdapter copies batches of lines into its table, each worker over
+// its own connection
+type postgresAdapter struct {
+	route   *router.Route
+	config  Config
+	hosts   []string
+	table   pgx.Identifier
+	columns []string
+	sources []string
+	// whether the table is known to exist, once create_table has created
+	// it
+	created atomic.Bool
+}
+
+func newPostgresAdapter(route *router.Route) (router.Adapter, error) {
+	hosts, err := route.Target.HostPorts()
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if options, ok := route.Target.Options("postgres").(*Config); ok {
+		config = *options
+	}
+	if config.Database == "" {
+		config.Database = "logspout"
+	}
+	if config.Table == "" {
+		config.Table = "logs"
+	}
+	columns, sources := config.ColumnSources()
+	return &postgresAdapter{
+		route:   route,
+		config:  config,
+		hosts:   hosts,
+		table:   pgx.Identifier(strings.Split(config.Table, ".")),
+		columns: columns,
+		sources: sources,
+	}, nil
+}
+
+func (a *postgresAdapter) Stream(logstream chan *attach.Log) {
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.route.Target.LineEncoder())
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		c := &copier{adapter: a}
+		return c.send, c.close
+	})
+}
+
+// copier is a worker's connection, made when it's first needed and again
+// after it fails
+type copier struct {
+	adapter *postgresAdapter
+	conn    *pgx.Conn
+}
+
+// copies a batch's rows into the table with COPY, counting the batch as
+// dropped if it fails
+func (c *copier) send(batch []*router.BatchItem) {
+	a := c.adapter
+	ctx := context.Background()
+	if timeout := a.route.Conn().WriteTimeoutDuration(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := c.connect(ctx); err != nil {
+		a.failed(err, len(batch))
+		return
+	}
+	rows := make([][]any, len(batch))
+	for i, item := range batch {
+		rows[i] = a.row(item.Log)
+	}
+	if _, err := c.conn.CopyFrom(ctx, a.table, a.columns, pgx.CopyFromRows(rows)); err != nil {
+		// the connection may be broken, so the next batch has a new one
+		c.close()
+		a.failed(err, len(batch))
+		return
+	}
+	for _, item := range batch {
+		a.route.Status().Sent(item.Buf.Len())
+	}
+}
+
+// connects to the first of the target's hosts that accepts writes, if the
+// worker isn't connected, and creates the table if it's to be
+func (c *copier) connect(ctx context.Context) error {
+	a := c.adapter
+	if c.conn != nil && !c.conn.IsClosed() {
+		return nil
+	}
+	config, err := a.connConfig()
+	if err != nil {
+		return err
+	}
+	if c.conn, err = pgx.ConnectConfig(ctx, config); err != nil {
+		c.conn = nil
+		return err
+	}
+	if a.config.CreateTable && !a.created.Load() {
+		if _, err := c.conn.Exec(ctx, a.createTable()); err != nil {
+			c.close()
+			return fmt.Errorf("creating table: %s", err)
+		}
+		a.created.Store(true)
+	}
+	return nil
+}
+
+func (c *copier) close() {
+	if c.conn != nil {
+		c.conn.Close(context.Background())
+		c.conn = nil
+	}
+}
+
+// returns the settings of a connection to the target's hosts, tried in
+// order, with the current password
+func (a *postgresAdapter) connConfig() (*pgx.ConnConfig, error) {
+	password, err := a.config.CurrentPassword()
+	if err != nil {
+		return nil, err
+	}
+	config, err := pgx.ParseConfig("")
+	if err != nil {
+		return nil, err
+	}
+	config.Database, config.User, config.Password = a.config.Database, a.config.Username, password
+	config.ConnectTimeout = a.route.Conn().Dialer().Timeout
+	config.DialFunc = a.route.Conn().Dialer().DialContext
+	config.ValidateConnect = pgconn.ValidateConnectTargetSessionAttrsReadWrite
+	config.Fallbacks = nil
+	for i, hostport := range a.hosts {
+		host, portText, _ := net.SplitHostPort(hostport)
+		port, err := strconv.ParseUint(portText, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("postgres port %q: %s", portText, err)
+		}
+		fallback := &pgconn.FallbackConfig{Host: host, Port: uint16(port)}
+		if a.route.Target.UsesTLS() {
+			fallback.TLSConfig = a.route.TLSConfig(host)
+		}
+		if i == 0 {
+			config.Host, config.Port, config.TLSConfig = fallback.Host, fallback.Port, fallback.TLSConfig
+			continue
+		}
+		config.Fallbacks = append(config.Fallbacks, fallback)
+	}
+	return config, nil
+}
+
+// returns the statement creating the table with its columns, if it doesn't
+// exist
+func (a *postgresAdapter) createTable() string {
+	definitions := make([]string, len(a.columns))
+	for i, column := range a.columns {
+		columnType := "text"
+		switch a.sources[i] {
+		case "timestamp":
+			columnType = "timestamptz"
+		case "fields":
+			columnType = "jsonb"
+		}
+		definitions[i] = pgx.Identifier{column}.Sanitize() + " " + columnType
+	}
+	return "CREATE TABLE IF NOT EXISTS " + a.table.Sanitize() + " (" + strings.Join(definitions, ", ") + ")"
+}
+
+// returns the values of a line's row, in the order of the columns. Text
+// that's empty is NULL.
+func (a *postgresAdapter) row(logline *attach.Log) []any {
+	k8s := attach.CachedK8sContainer(logline.Name)
+	row := make([]any, len(a.sources))
+	for i, source := range a.sources {
+		var value string
+		switch source {
+		case "timestamp":
+			row[i] = logline.Timestamp
+			continue
+		case "fields":
+			if len(logline.Fields) > 0 {
+				data, _ := json.Marshal(logline.Fields)
+				row[i] = string(data)
+			}
+			continue
+		case "message":
+			value = logline.Data
+		case "container":
+			value = logline.Name
+		case "container_id":
+			value = logline.ID
+		case "image":
+			value = logline.Image
+		case "stream":
+			value = logline.Type
+		case "severity":
+			value = logline.Severity
+		case "k8s_namespace", "k8s_pod", "k8s_container":
+			if k8s != nil {
+				value = map[string]string{"k8s_namespace": k8s.Namespace, "k8s_pod": k8s.Pod, "k8s_container": k8s.Name}[source]
+			}
+		default:
+			field := logline.Fields[strings.TrimPrefix(source, "fields.")]
+			if text, ok := field.(string); ok {
+				value = text
+			} else if field != nil {
+				data, _ := json.Marshal(field)
+				value = string(data)
+			}
+		}
+		if value != "" {
+			row[i] = value
+		}
+	}
+	return row
+}
+
+func (a *postgresAdapter) failed(err error, lines int) {
+	logging.Logger("postgres").Error("copy failed", "route", a.route.ID, "err", err)
+	a.route.Status().Failed(err)
+	a.route.Status().Dropped("error", lines)
+}
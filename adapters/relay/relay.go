@@ -0,0 +1,148 @@
+// Package relay adds the relay and relay+tls target types, forwarding lines
+// to the relay input of another logspout, see inputs.ListenRelay.
+package relay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/inputs"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func init() {
+	for _, scheme := range []string{"relay", "relay+tls"} {
+		router.RegisterAdapter(scheme, router.AdapterType{
+			New:         newRelayAdapter,
+			DefaultPort: inputs.RelayPort,
+			Encodings:   []string{"protobuf"},
+		})
+	}
+}
+
+// attempts at sending a batch, each on a fresh connection after the first,
+// before its lines are dropped
+const sendAttempts = 3
+
+// relayAdapter sends batches of lines as NDJSON or protobuf frames, each
+// resent until the receiving logspout acknowledges it has published them
+type relayAdapter struct {
+	route    *router.Route
+	resolver *router.AddrResolver
+}
+
+func newRelayAdapter(route *router.Route) (router.Adapter, error) {
+	resolver, err := router.NewAddrResolver(route.Target, router.ResolveInterval)
+	if err != nil {
+		return nil, err
+	}
+	return &relayAdapter{route: route, resolver: resolver}, nil
+}
+
+func (a *relayAdapter) Stream(logstream chan *attach.Log) {
+	defer a.resolver.Stop()
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.route.Target.LineEncoder())
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		conn := &relayConn{adapter: a}
+		return conn.send, conn.close
+	})
+}
+
+// relayConn is a worker's connection to the receiving logspout
+type relayConn struct {
+	adapter *relayAdapter
+	conn    net.Conn
+	acks    *bufio.Reader
+	addr    string
+	seq     uint64
+}
+
+func (c *relayConn) send(batch []*router.BatchItem) {
+	payload := router.GetBuffer()
+	defer router.PutBuffer(payload)
+	for _, item := range batch {
+		payload.Write(item.Buf.Bytes())
+	}
+	route := c.adapter.route
+	var err error
+	for attempt := 0; attempt < sendAttempts; attempt++ {
+		if err = c.sendFrame(payload.Bytes()); err == nil {
+			for _, item := range batch {
+				route.Status().Sent(item.Buf.Len())
+			}
+			return
+		}
+		c.close()
+		c.adapter.resolver.Failed()
+	}
+	logging.Logger("relay").Error("send failed", "route", route.ID, "err", err)
+	route.Status().Failed(err)
+	route.Status().Dropped("error", len(batch))
+}
+
+// sends a frame and waits for it to be acknowledged: frames are the
+// sequence number as 8 bytes and the payload length as 4, big endian,
+// followed by the payload, and acks are the sequence number
+func (c *relayConn) sendFrame(payload []byte) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+	c.seq++
+	var header [12]byte
+	binary.BigEndian.PutUint64(header[:8], c.seq)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(payload)))
+	c.conn.SetDeadline(c.adapter.route.Conn().WriteDeadline())
+	frame := net.Buffers{header[:], payload}
+	if _, err := frame.WriteTo(c.conn); err != nil {
+		return err
+	}
+	var ack [8]byte
+	if _, err := io.ReadFull(c.acks, ack[:]); err != nil {
+		return err
+	}
+	if seq := binary.BigEndian.Uint64(ack[:]); seq != c.seq {
+		return fmt.Errorf("relay acknowledged frame %d, not %d", seq, c.seq)
+	}
+	return nil
+}
+
+// connects to the target's current address, if not connected to it already
+func (c *relayConn) connect() error {
+	route := c.adapter.route
+	addr := c.adapter.resolver.Addr()
+	if c.conn != nil && addr == c.addr {
+		return nil
+	}
+	if c.conn != nil {
+		c.close()
+		route.Status().Reconnected()
+	}
+	conn, err := route.DialTCP(addr)
+	if err != nil {
+		return err
+	}
+	// the hello tells the receiving logspout how frames are encoded
+	hello := inputs.RelayHello
+	if route.Target.Encoding == "protobuf" {
+		hello = inputs.RelayProtobufHello
+	}
+	if _, err := io.WriteString(conn, hello); err != nil {
+		conn.Close()
+		return err
+	}
+	c.conn, c.acks, c.addr = conn, bufio.NewReader(conn), addr
+	return nil
+}
+
+func (c *relayConn) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
@@ -0,0 +1,81 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/jimmidyson/logspout/router"
+)
+
+// Config configures s3 targets, which archive lines as objects in a
+// bucket, as their target's s3 options
+type Config struct {
+	// region of the bucket, the AWS_REGION or shared config one by default
+	Region string `json:"region,omitempty"`
+	// URL of an S3 compatible service to use instead of AWS, such as MinIO
+	Endpoint string `json:"endpoint,omitempty"`
+	// whether the bucket is addressed in the URL's path rather than its
+	// host, which most S3 compatible services need
+	PathStyle bool `json:"path_style,omitempty"`
+	// most bytes of NDJSON an object holds before it's uploaded, before
+	// compression. Defaults to 64MiB.
+	MaxObjectBytes int `json:"max_object_bytes,omitempty"`
+	// longest an object's first line waits before it's uploaded, as a
+	// duration. Defaults to 5m.
+	MaxObjectAge string `json:"max_object_age,omitempty"`
+
+	maxAge time.Duration
+}
+
+// returns the target's s3 options, or the defaults if it doesn't set them
+func targetConfig(target router.Target) Config {
+	if options, ok := target.Options("s3").(*Config); ok {
+		return *options
+	}
+	return Config{}
+}
+
+// object size and age of targets that don't set them
+const (
+	defaultS3ObjectBytes = 64 << 20
+	defaultS3ObjectAge   = 5 * time.Minute
+)
+
+// returns the most bytes an object holds, MaxObjectBytes or its default
+func (c Config) ObjectBytes() int {
+	if c.MaxObjectBytes > 0 {
+		return c.MaxObjectBytes
+	}
+	return defaultS3ObjectBytes
+}
+
+// returns how long an object's first line may wait, MaxObjectAge parsed or
+// its default
+func (c Config) MaxAge() time.Duration {
+	if c.maxAge > 0 {
+		return c.maxAge
+	}
+	return defaultS3ObjectAge
+}
+
+// checks the settings and parses MaxObjectAge
+func (c *Config) Validate() error {
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("s3 endpoint must be an http or https URL, not %q", c.Endpoint)
+		}
+	}
+	if c.MaxObjectBytes < 0 {
+		return fmt.Errorf("s3 max_object_bytes must be positive")
+	}
+	c.maxAge = 0
+	if c.MaxObjectAge != "" {
+		var err error
+		if c.maxAge, err = time.ParseDuration(c.MaxObjectAge); err != nil || c.maxAge <= 0 {
+			return fmt.Errorf("s3 max_object_age: invalid duration %q", c.MaxObjectAge)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// longest archived line read back, so a corrupt object can't exhaust
+// memory
+const maxArchivedLine = 1 << 20
+
+// reads back the objects of the days from since to until under the route's
+// prefix, emitting the lines logged in that time
+func replay(ctx context.Context, route *router.Route, since, until time.Time, emit func(*attach.Log)) error {
+	client, err := newClient(route)
+	if err != nil {
+		return err
+	}
+	bucket, prefix := splitAddr(route.Target.Addr)
+	since, until = since.UTC(), until.UTC()
+	for day := since.Truncate(24 * time.Hour); day.Before(until); day = day.Add(24 * time.Hour) {
+		dayPrefix := "date=" + day.Format("2006-01-02") + "/"
+		if prefix != "" {
+			dayPrefix = prefix + "/" + dayPrefix
+		}
+		pages := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(dayPrefix),
+		})
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("listing %s: %s", dayPrefix, err)
+			}
+			for _, object := range page.Contents {
+				if err := replayObject(ctx, client, bucket, aws.ToString(object.Key), since, until, emit); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// emits the lines of an object logged from since up to until
+func replayObject(ctx context.Context, client *s3.Client, bucket, key string, since, until time.Time, emit func(*attach.Log)) error {
+	output, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", key, err)
+	}
+	defer output.Body.Close()
+	body := bufio.NewReaderSize(output.Body, 64*1024)
+	var rd io.Reader = body
+	// objects are gzipped unless their route's compression was none
+	if magic, _ := body.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("reading %s: %s", key, err)
+		}
+		defer gz.Close()
+		rd = gz
+	}
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), maxArchivedLine)
+	for scanner.Scan() {
+		logline := new(attach.Log)
+		if err := json.Unmarshal(scanner.Bytes(), logline); err != nil {
+			return fmt.Errorf("reading %s: %s", key, err)
+		}
+		if logline.Timestamp.Before(since) || !logline.Timestamp.Before(until) {
+			continue
+		}
+		emit(logline)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %s", key, err)
+	}
+	return nil
+}
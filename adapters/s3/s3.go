@@ -0,0 +1,249 @@
+// Package s3 adds the s3 target type, archiving lines as gzipped NDJSON
+// objects in an S3 bucket.
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func init() {
+	router.RegisterAdapter("s3", router.AdapterType{
+		New:          newS3Adapter,
+		ValidateAddr: validateAddr,
+		Compressions: []string{"gzip"},
+		Replay:       replay,
+		Options:      "s3",
+	})
+	router.RegisterTargetOptions("s3", func() router.TargetOptions { return new(Config) })
+}
+
+// checks an address is a bucket, optionally followed by a key prefix
+func validateAddr(addr string) error {
+	bucket, _ := splitAddr(addr)
+	if bucket == "" || strings.ContainsAny(bucket, ":,") {
+		return fmt.Errorf("s3 addr must be bucket or bucket/prefix, not %q", addr)
+	}
+	return nil
+}
+
+// object is the lines of one partition waiting to be uploaded
+type object struct {
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	lines   int
+	size    int
+	started time.Time
+}
+
+// s3Adapter collects lines into an object per partition, date, namespace
+// and container, and uploads each once it's big or old enough
+type s3Adapter struct {
+	route          *router.Route
+	client         *s3.Client
+	config         Config
+	bucket, prefix string
+	compress       bool
+	// in object names, so several logspouts can archive to one bucket
+	hostname string
+
+	sync.Mutex
+	objects map[string]*object
+}
+
+func newS3Adapter(route *router.Route) (router.Adapter, error) {
+	target := route.Target
+	client, err := newClient(route)
+	if err != nil {
+		return nil, err
+	}
+	bucket, prefix := splitAddr(target.Addr)
+	return &s3Adapter{
+		route:    route,
+		client:   client,
+		config:   targetConfig(target),
+		bucket:   bucket,
+		prefix:   prefix,
+		compress: target.Compression != "none",
+		hostname: router.Hostname,
+		objects:  make(map[string]*object),
+	}, nil
+}
+
+// returns a client for the route's bucket
+func newClient(route *router.Route) (*s3.Client, error) {
+	s3Config := targetConfig(route.Target)
+	awsConfig, err := AWSConfig(route, s3Config.Region)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		if s3Config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(s3Config.Endpoint)
+		}
+		o.UsePathStyle = s3Config.PathStyle
+	}), nil
+}
+
+// returns the AWS config for a route's requests, through its proxy and
+// dialer, in region unless that's empty. Credentials come from the
+// environment, shared config, web identity or the instance's role, as for
+// the AWS CLI.
+func AWSConfig(route *router.Route, region string) (aws.Config, error) {
+	proxy, err := route.Target.ProxyFunc()
+	if err != nil {
+		return aws.Config{}, err
+	}
+	opts := []func(*config.LoadOptions) error{
+		config.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+			t.Proxy, t.DialContext = proxy, route.Conn().Dialer().DialContext
+		})),
+	}
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	return config.LoadDefaultConfig(context.Background(), opts...)
+}
+
+// returns the bucket and key prefix of an address, without slashes around
+// the prefix
+func splitAddr(addr string) (bucket, prefix string) {
+	bucket, prefix, _ = strings.Cut(addr, "/")
+	return bucket, strings.Trim(prefix, "/")
+}
+
+func (a *s3Adapter) Stream(logstream chan *attach.Log) {
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.route.Target.LineEncoder())
+	stop := make(chan struct{})
+	go a.uploadAged(stop)
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		return a.add, func() {}
+	})
+	close(stop)
+	a.Lock()
+	defer a.Unlock()
+	for partition := range a.objects {
+		a.upload(partition)
+	}
+}
+
+// adds a batch's lines to their partitions' objects, uploading those that
+// reach the most bytes an object holds
+func (a *s3Adapter) add(batch []*router.BatchItem) {
+	a.Lock()
+	defer a.Unlock()
+	for _, item := range batch {
+		partition := a.partition(item.Log)
+		obj, ok := a.objects[partition]
+		if !ok {
+			obj = &object{started: time.Now()}
+			if a.compress {
+				obj.gz = gzip.NewWriter(&obj.buf)
+			}
+			a.objects[partition] = obj
+		}
+		if obj.gz != nil {
+			obj.gz.Write(item.Buf.Bytes())
+		} else {
+			obj.buf.Write(item.Buf.Bytes())
+		}
+		obj.lines++
+		obj.size += item.Buf.Len()
+		if obj.size >= a.config.ObjectBytes() {
+			a.upload(partition)
+		}
+	}
+}
+
+// uploads the objects whose first line has waited the longest an object may
+// wait, until stop is closed
+func (a *s3Adapter) uploadAged(stop chan struct{}) {
+	ticker := time.NewTicker(min(a.config.MaxAge()/4, 10*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		a.Lock()
+		for partition, obj := range a.objects {
+			if time.Since(obj.started) >= a.config.MaxAge() {
+				a.upload(partition)
+			}
+		}
+		a.Unlock()
+	}
+}
+
+// returns the key prefix of a line's partition: the day it was logged,
+// its Kubernetes namespace and its container
+func (a *s3Adapter) partition(logline *attach.Log) string {
+	namespace := "none"
+	if k8s := attach.CachedK8sContainer(logline.Name); k8s != nil {
+		namespace = k8s.Namespace
+	}
+	container := strings.TrimPrefix(logline.Name, "/")
+	if container == "" {
+		container = logline.ID
+	}
+	partition := fmt.Sprintf("date=%s/namespace=%s/container=%s",
+		logline.Timestamp.UTC().Format("2006-01-02"), url.PathEscape(namespace), url.PathEscape(container))
+	if a.prefix != "" {
+		partition = a.prefix + "/" + partition
+	}
+	return partition
+}
+
+// uploads a partition's object, counting its lines as dropped if it can't
+// be. Called with the adapter locked.
+func (a *s3Adapter) upload(partition string) {
+	obj := a.objects[partition]
+	delete(a.objects, partition)
+	key := fmt.Sprintf("%s/%s-%s.ndjson", partition, a.hostname, obj.started.UTC().Format("20060102T150405.000000000Z"))
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(a.bucket),
+		ContentType: aws.String("application/x-ndjson"),
+	}
+	if obj.gz != nil {
+		obj.gz.Close()
+		key += ".gz"
+		input.ContentEncoding = aws.String("gzip")
+	}
+	input.Key, input.Body = aws.String(key), bytes.NewReader(obj.buf.Bytes())
+	ctx := context.Background()
+	if timeout := a.route.Conn().WriteTimeoutDuration(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if _, err := a.client.PutObject(ctx, input); err != nil {
+		err := fmt.Errorf("uploading %s: %s", key, err)
+		logging.Logger("s3").Error("upload failed", "route", a.route.ID, "err", err)
+		a.route.Status().Failed(err)
+		a.route.Status().Dropped("error", obj.lines)
+		return
+	}
+	// the lines were batched as they arrived, so they're counted by their
+	// share of the object
+	for i := 0; i < obj.lines; i++ {
+		a.route.Status().Sent(obj.buf.Len() / obj.lines)
+	}
+}
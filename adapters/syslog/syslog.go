@@ -0,0 +1,115 @@
+// Package syslog adds the syslog, syslog+tcp and syslog+tls target types.
+package syslog
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jimmidyson/logspout/adapters/tcp"
+	"github.com/jimmidyson/logspout/adapters/udp"
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func init() {
+	router.RegisterAdapter("syslog", router.AdapterType{New: newSyslogAdapter, DefaultPort: "514"})
+	router.RegisterAdapter("syslog+tcp", router.AdapterType{New: newSyslogAdapter, DefaultPort: "514"})
+	router.RegisterAdapter("syslog+tls", router.AdapterType{New: newSyslogAdapter, DefaultPort: "6514"})
+}
+
+// the user-level messages facility, shifted into place in a priority. It's
+// spelled out rather than taken from log/syslog, which doesn't build on
+// Windows.
+const userFacility = 1 << 3
+
+// syslogAdapter sends lines as RFC 3339 timestamped syslog messages, as
+// datagrams or, for syslog+tcp and syslog+tls, octet counted over a stream
+type syslogAdapter struct {
+	route    *router.Route
+	resolver *router.AddrResolver
+	stream   bool
+	// the parts of the header that are the same for every line
+	host, pid string
+}
+
+func newSyslogAdapter(route *router.Route) (router.Adapter, error) {
+	resolver, err := router.NewAddrResolver(route.Target, router.ResolveInterval)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogAdapter{
+		route:    route,
+		resolver: resolver,
+		stream:   route.Target.Type != "syslog",
+		host:     " " + router.Hostname + " ",
+		pid:      "[" + strconv.Itoa(os.Getpid()) + "]: ",
+	}, nil
+}
+
+func (a *syslogAdapter) Stream(logstream chan *attach.Log) {
+	defer a.resolver.Stop()
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.encode)
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		if a.stream {
+			conn := tcp.NewConn(a.route, a.resolver)
+			return conn.SendBatch("syslog"), conn.Close
+		}
+		conn := udp.NewConn(a.route, a.resolver)
+		return conn.SendBatch("syslog", false), conn.Close
+	})
+}
+
+func (a *syslogAdapter) encode(logline *attach.Log, buf *bytes.Buffer) error {
+	if !a.stream {
+		return a.encodeDatagram(logline, buf)
+	}
+	// octet counting, as RFC 6587 frames messages
+	msg := router.GetBuffer()
+	defer router.PutBuffer(msg)
+	if err := a.encodeMessage(logline, msg); err != nil {
+		return err
+	}
+	buf.Write(strconv.AppendInt(buf.AvailableBuffer(), int64(msg.Len()), 10))
+	buf.WriteByte(' ')
+	buf.Write(msg.Bytes())
+	return nil
+}
+
+func (a *syslogAdapter) encodeDatagram(logline *attach.Log, buf *bytes.Buffer) error {
+	if err := a.encodeMessage(logline, buf); err != nil {
+		return err
+	}
+	// datagrams that are too large are cut short on a character boundary
+	if limit := a.route.Target.DatagramSize(); buf.Len()+1 > limit {
+		n := limit - 1
+		for n > 0 && !utf8.RuneStart(buf.Bytes()[n]) {
+			n--
+		}
+		buf.Truncate(n)
+	}
+	buf.WriteByte('\n')
+	return nil
+}
+
+func (a *syslogAdapter) encodeMessage(logline *attach.Log, buf *bytes.Buffer) error {
+	target := a.route.Target
+	text, err := a.route.Render(logline)
+	if err != nil {
+		return err
+	}
+	priority := userFacility | attach.SyslogPriority(logline.Severity)
+	buf.WriteByte('<')
+	buf.Write(strconv.AppendInt(buf.AvailableBuffer(), int64(priority), 10))
+	buf.WriteByte('>')
+	buf.Write(logline.Timestamp.AppendFormat(buf.AvailableBuffer(), time.RFC3339))
+	buf.WriteString(a.host)
+	buf.WriteString(logline.Name)
+	buf.WriteString(target.AppendTag)
+	buf.WriteString(a.pid)
+	buf.WriteString(text)
+	return nil
+}
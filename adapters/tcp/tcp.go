@@ -0,0 +1,111 @@
+// Package tcp adds the tcp+json and tcp+json+tls target types, sending
+// lines as newline delimited JSON, or length-prefixed protobuf, over a TCP
+// connection.
+package tcp
+
+import (
+	"net"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func init() {
+	router.RegisterAdapter("tcp+json", router.AdapterType{New: newTCPAdapter, Encodings: []string{"protobuf"}, ShapesJSON: true})
+	router.RegisterAdapter("tcp+json+tls", router.AdapterType{New: newTCPAdapter, Encodings: []string{"protobuf"}, ShapesJSON: true})
+}
+
+// tcpAdapter sends lines as JSON lines, or protobuf messages
+type tcpAdapter struct {
+	route    *router.Route
+	resolver *router.AddrResolver
+}
+
+func newTCPAdapter(route *router.Route) (router.Adapter, error) {
+	resolver, err := router.NewAddrResolver(route.Target, router.ResolveInterval)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpAdapter{route: route, resolver: resolver}, nil
+}
+
+func (a *tcpAdapter) Stream(logstream chan *attach.Log) {
+	defer a.resolver.Stop()
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.route.Target.LineEncoder())
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		conn := NewConn(a.route, a.resolver)
+		return conn.SendBatch("tcp"), conn.Close
+	})
+}
+
+// Conn is a TCP connection to a target that follows the target's resolved
+// address, secured with TLS for *+tls targets, for adapters sending a
+// stream of encoded lines
+type Conn struct {
+	route    *router.Route
+	resolver *router.AddrResolver
+	conn     net.Conn
+	addr     string
+}
+
+// returns a connection to the route's target, dialed on first write
+func NewConn(route *router.Route, resolver *router.AddrResolver) *Conn {
+	return &Conn{route: route, resolver: resolver}
+}
+
+// writes the buffers in order, redialing once if the connection has gone
+func (c *Conn) Write(data net.Buffers) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		// redial when re-resolution moved the target elsewhere
+		if addr := c.resolver.Addr(); c.conn == nil || addr != c.addr {
+			if c.conn != nil {
+				c.Close()
+				c.route.Status().Reconnected()
+			}
+			conn, err := c.route.DialTCP(addr)
+			if err != nil {
+				c.resolver.Failed()
+				return err
+			}
+			c.conn, c.addr = conn, addr
+		}
+		c.conn.SetWriteDeadline(c.route.Conn().WriteDeadline())
+		buffers := data
+		if _, err = buffers.WriteTo(c.conn); err == nil {
+			return nil
+		}
+		c.Close()
+	}
+	c.resolver.Failed()
+	return err
+}
+
+func (c *Conn) Close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// sends the items of a batch in one write. The batch is counted as dropped
+// if it fails.
+func (c *Conn) SendBatch(prefix string) func([]*router.BatchItem) {
+	return func(batch []*router.BatchItem) {
+		data := make(net.Buffers, 0, len(batch))
+		for _, item := range batch {
+			data = append(data, item.Buf.Bytes())
+		}
+		if err := c.Write(data); err != nil {
+			logging.Logger(prefix).Error("send failed", "route", c.route.ID, "err", err)
+			c.route.Status().Failed(err)
+			c.route.Status().Dropped("error", len(batch))
+			return
+		}
+		for _, item := range batch {
+			c.route.Status().Sent(item.Buf.Len())
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package udp adds the udp+json target type, sending lines as JSON, or
+// length-prefixed protobuf, datagrams.
+package udp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func init() {
+	router.RegisterAdapter("udp+json", router.AdapterType{New: newUDPAdapter, Encodings: []string{"protobuf"}, ShapesJSON: true})
+}
+
+// udpAdapter sends lines as JSON datagrams
+type udpAdapter struct {
+	route    *router.Route
+	resolver *router.AddrResolver
+}
+
+func newUDPAdapter(route *router.Route) (router.Adapter, error) {
+	resolver, err := router.NewAddrResolver(route.Target, router.ResolveInterval)
+	if err != nil {
+		return nil, err
+	}
+	return &udpAdapter{route: route, resolver: resolver}, nil
+}
+
+func (a *udpAdapter) Stream(logstream chan *attach.Log) {
+	defer a.resolver.Stop()
+	items := make(chan *router.BatchItem)
+	limit := a.route.Target.DatagramSize()
+	encode := a.route.Target.LineEncoder()
+	go a.route.EncodeLines(logstream, items, func(logline *attach.Log, buf *bytes.Buffer) error {
+		return encodeDatagram(logline, buf, limit, encode)
+	})
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		conn := NewConn(a.route, a.resolver)
+		return conn.SendBatch("udp", a.route.Target.PackDatagrams), conn.Close
+	})
+}
+
+// Conn is a UDP connection to a target that follows the target's resolved
+// address, for adapters sending datagrams
+type Conn struct {
+	route    *router.Route
+	resolver *router.AddrResolver
+	conn     net.Conn
+	addr     string
+}
+
+// returns a connection to the route's target, dialed on first write
+func NewConn(route *router.Route, resolver *router.AddrResolver) *Conn {
+	return &Conn{route: route, resolver: resolver}
+}
+
+func (c *Conn) Write(data []byte) error {
+	// redial when re-resolution moved the target elsewhere
+	if addr := c.resolver.Addr(); c.conn == nil || addr != c.addr {
+		if c.conn != nil {
+			c.conn.Close()
+			c.conn = nil
+			c.route.Status().Reconnected()
+		}
+		conn, err := c.route.Conn().Dialer().Dial("udp", addr)
+		if err != nil {
+			c.resolver.Failed()
+			return err
+		}
+		c.conn, c.addr = conn, addr
+	}
+	c.conn.SetWriteDeadline(c.route.Conn().WriteDeadline())
+	if _, err := c.conn.Write(data); err != nil {
+		c.resolver.Failed()
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) Close() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// sends the items of a batch as datagrams, each on its own or, with pack,
+// as many as fit in the target's datagram size. The rest of the batch is
+// counted as dropped if a write fails.
+func (c *Conn) SendBatch(prefix string, pack bool) func([]*router.BatchItem) {
+	limit := c.route.Target.DatagramSize()
+	return func(batch []*router.BatchItem) {
+		packet := router.GetBuffer()
+		defer router.PutBuffer(packet)
+		sent := 0
+		flush := func(upto int) bool {
+			if packet.Len() > 0 {
+				if err := c.Write(packet.Bytes()); err != nil {
+					logging.Logger(prefix).Error("send failed", "route", c.route.ID, "err", err)
+					c.route.Status().Failed(err)
+					c.route.Status().Dropped("error", len(batch)-sent)
+					return false
+				}
+			}
+			for ; sent < upto; sent++ {
+				c.route.Status().Sent(batch[sent].Buf.Len())
+			}
+			packet.Reset()
+			return true
+		}
+		for i, item := range batch {
+			if !pack || packet.Len()+item.Buf.Len() > limit {
+				if !flush(i) {
+					return
+				}
+			}
+			packet.Write(item.Buf.Bytes())
+		}
+		flush(len(batch))
+	}
+}
+
+// returns the encoding of logline with encode in no more than limit bytes,
+// cutting down its data to fit if need be
+func encodeDatagram(logline *attach.Log, buf *bytes.Buffer, limit int, encode func(*attach.Log, *bytes.Buffer) error) error {
+	if err := encode(logline, buf); err != nil || buf.Len() <= limit {
+		return err
+	}
+	cut := *logline
+	cut.Truncated = true
+	cut.Data = ""
+	buf.Reset()
+	if err := encode(&cut, buf); err != nil {
+		return err
+	}
+	overhead := buf.Len()
+	for n := min(limit-overhead, len(logline.Data)); n > 0; {
+		cut.Data = logline.Data
+		if n < len(cut.Data) {
+			cut.Data = cut.Data[:attach.CutPoint(cut.Data, n)]
+		}
+		buf.Reset()
+		if err := encode(&cut, buf); err != nil {
+			return err
+		}
+		if buf.Len() <= limit {
+			return nil
+		}
+		// escaping can make the data's encoding longer than the data, so
+		// it's scaled down by how much longer it was
+		n = min(n-1, n*(limit-overhead)/(buf.Len()-overhead))
+	}
+	return fmt.Errorf("line too large for a %d byte datagram", limit)
+}
@@ -0,0 +1,76 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func TestEncodeDatagram(t *testing.T) {
+	encoders := map[string]func(*attach.Log, *bytes.Buffer) error{
+		"json":     router.Target{Type: "udp+json"}.LineEncoder(),
+		"protobuf": attach.EncodeProto,
+	}
+	decoders := map[string]func([]byte) (*attach.Log, error){
+		"json": func(data []byte) (*attach.Log, error) {
+			logline := new(attach.Log)
+			return logline, json.Unmarshal(data, logline)
+		},
+		"protobuf": func(data []byte) (*attach.Log, error) {
+			logline, _, err := attach.DecodeProto(data)
+			return logline, err
+		},
+	}
+	tests := []struct {
+		name  string
+		data  string
+		limit int
+	}{
+		{"fits", "hello", 1000},
+		{"ascii", strings.Repeat("x", 5000), 1000},
+		{"multibyte", strings.Repeat("日本語", 2000), 1000},
+		// quotes and control characters grow when escaped in JSON
+		{"escaped", strings.Repeat("\"\x01", 3000), 1000},
+	}
+	for encoding, encode := range encoders {
+		for _, test := range tests {
+			in := &attach.Log{ID: "0123456789ab", Name: "web", Data: test.data}
+			var buf bytes.Buffer
+			if err := encodeDatagram(in, &buf, test.limit, encode); err != nil {
+				t.Errorf("%s %s: %s", encoding, test.name, err)
+				continue
+			}
+			if buf.Len() > test.limit {
+				t.Errorf("%s %s: %d bytes, over %d", encoding, test.name, buf.Len(), test.limit)
+			}
+			out, err := decoders[encoding](buf.Bytes())
+			if err != nil {
+				t.Errorf("%s %s: decoding: %s", encoding, test.name, err)
+				continue
+			}
+			cut := len(test.data) > len(out.Data)
+			if !strings.HasPrefix(test.data, out.Data) || !utf8.ValidString(out.Data) || out.Truncated != cut || out.ID != in.ID {
+				t.Errorf("%s %s: got %d bytes of data, truncated %v", encoding, test.name, len(out.Data), out.Truncated)
+			}
+			if cut && buf.Len() < test.limit/2 {
+				t.Errorf("%s %s: cut down to %d bytes, far under %d", encoding, test.name, buf.Len(), test.limit)
+			}
+			if in.Data != test.data || in.Truncated {
+				t.Errorf("%s %s: the line was changed", encoding, test.name)
+			}
+		}
+	}
+}
+
+func TestEncodeDatagramTooSmall(t *testing.T) {
+	var buf bytes.Buffer
+	logline := &attach.Log{Name: strings.Repeat("n", 100), Data: "data"}
+	if err := encodeDatagram(logline, &buf, 50, attach.EncodeProto); err == nil {
+		t.Error("encoded a line whose other fields don't fit")
+	}
+}
@@ -0,0 +1,40 @@
+package ws
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/jimmidyson/logspout/router"
+)
+
+// Config configures ws and wss targets, which stream lines to a
+// websocket server logspout connects to, as their target's websocket
+// options
+type Config struct {
+	// headers of the handshake request, such as Authorization
+	Headers map[string]string `json:"headers,omitempty"`
+	// origin the handshake has, http:// or https:// and the target's host
+	// by default
+	Origin string `json:"origin,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.Origin != "" {
+		if u, err := url.ParseRequestURI(c.Origin); err != nil || u.Host == "" {
+			return fmt.Errorf("websocket origin must be a URL, not %q", c.Origin)
+		}
+	}
+	return nil
+}
+
+// returns a copy with the headers' values replaced
+func (c *Config) Redacted() router.TargetOptions {
+	copied := *c
+	if len(c.Headers) > 0 {
+		copied.Headers = make(map[string]string, len(c.Headers))
+		for name := range c.Headers {
+			copied.Headers[name] = router.RedactedSecret
+		}
+	}
+	return &copied
+}
@@ -0,0 +1,165 @@
+// Package ws adds the ws and wss target types, streaming lines as NDJSON to
+// a websocket server.
+package ws
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// ports of addresses that don't have one
+var defaultPorts = map[string]string{"ws": "80", "wss": "443"}
+
+func init() {
+	for scheme, port := range defaultPorts {
+		router.RegisterAdapter(scheme, router.AdapterType{
+			New:          newWSAdapter,
+			DefaultPort:  port,
+			ValidateAddr: validateAddr,
+			Options:      "websocket",
+		})
+	}
+	router.RegisterTargetOptions("websocket", func() router.TargetOptions { return new(Config) })
+}
+
+// checks an address is a host, optionally with a port, and a path
+func validateAddr(addr string) error {
+	host, _, _ := strings.Cut(addr, "/")
+	if host == "" || strings.Contains(host, ",") {
+		return fmt.Errorf("websocket addr must be host[:port][/path], not %q", addr)
+	}
+	return nil
+}
+
+// wsAdapter streams lines as text messages of a JSON line each, over a
+// connection per worker
+type wsAdapter struct {
+	route    *router.Route
+	hostport string
+	host     string
+	config   *websocket.Config
+}
+
+func newWSAdapter(route *router.Route) (router.Adapter, error) {
+	target := route.Target
+	authority, path, _ := strings.Cut(target.Addr, "/")
+	hostport := authority
+	if _, _, err := net.SplitHostPort(authority); err != nil {
+		hostport = net.JoinHostPort(strings.Trim(authority, "[]"), defaultPorts[target.Type])
+	}
+	host, _, _ := net.SplitHostPort(hostport)
+	var wsConfig Config
+	if options, ok := target.Options("websocket").(*Config); ok {
+		wsConfig = *options
+	}
+	origin := wsConfig.Origin
+	if origin == "" {
+		origin = "http://" + authority
+		if target.Type == "wss" {
+			origin = "https://" + authority
+		}
+	}
+	config, err := websocket.NewConfig(target.Type+"://"+authority+"/"+path, origin)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range wsConfig.Headers {
+		config.Header.Set(name, value)
+	}
+	return &wsAdapter{route: route, hostport: hostport, host: host, config: config}, nil
+}
+
+func (a *wsAdapter) Stream(logstream chan *attach.Log) {
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.route.Target.LineEncoder())
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		c := &conn{adapter: a}
+		return c.send, c.close
+	})
+}
+
+// conn is a worker's websocket, connected when it's first needed and again
+// after it fails
+type conn struct {
+	adapter *wsAdapter
+	ws      *websocket.Conn
+	// whether the conn has been connected before, so connecting again
+	// counts as reconnecting
+	connected bool
+}
+
+// sends a batch's lines, a message each, dropping the rest of the batch if
+// the connection fails
+func (c *conn) send(batch []*router.BatchItem) {
+	a := c.adapter
+	for i, item := range batch {
+		err := c.connect()
+		if err == nil {
+			c.ws.SetWriteDeadline(a.route.Conn().WriteDeadline())
+			_, err = c.ws.Write(item.Buf.Bytes())
+		}
+		if err != nil {
+			c.close()
+			logging.Logger("ws").Error("send failed", "route", a.route.ID, "err", err)
+			a.route.Status().Failed(err)
+			a.route.Status().Dropped("error", len(batch)-i)
+			return
+		}
+		a.route.Status().Sent(item.Buf.Len())
+	}
+}
+
+// connects to the server if the conn isn't, over TLS for wss
+func (c *conn) connect() error {
+	a := c.adapter
+	if c.ws != nil {
+		return nil
+	}
+	netConn, err := a.route.Conn().Dialer().Dial("tcp", a.hostport)
+	if err != nil {
+		return err
+	}
+	netConn.SetDeadline(a.route.Conn().WriteDeadline())
+	if a.route.Target.Type == "wss" {
+		tlsConn := tls.Client(netConn, a.route.TLSConfig(a.host))
+		if err := tlsConn.Handshake(); err != nil {
+			netConn.Close()
+			return err
+		}
+		netConn = tlsConn
+	}
+	ws, err := websocket.NewClient(a.config, netConn)
+	if err != nil {
+		netConn.Close()
+		return err
+	}
+	netConn.SetDeadline(time.Time{})
+	if c.connected {
+		a.route.Status().Reconnected()
+	}
+	c.ws, c.connected = ws, true
+	// reading handles the server's pings and notices it closing, which
+	// closes the websocket so the next write fails
+	go func() {
+		io.Copy(io.Discard, ws)
+		ws.Close()
+	}()
+	return nil
+}
+
+func (c *conn) close() {
+	if c.ws != nil {
+		c.ws.Close()
+		c.ws = nil
+	}
+}
@@ -0,0 +1,64 @@
+package zmq
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// Config configures zmq+pub targets, as their target's zmq options
+type Config struct {
+	// topic frame each line is published with, a template like
+	// Target.Template if it has actions. By default it's
+	// <namespace>/<pod>/<container> for Kubernetes containers and the
+	// container's name for others, so subscribers can filter by prefix.
+	Topic string `json:"topic,omitempty"`
+	// most lines queued for subscribers before newer ones are dropped,
+	// 1000 by default
+	HWM int `json:"hwm,omitempty"`
+	// whether to connect to addr, such as an XSUB proxy, instead of
+	// binding it for subscribers to connect to
+	Connect bool `json:"connect,omitempty"`
+
+	topic *template.Template
+}
+
+func (c *Config) Validate() error {
+	c.topic = nil
+	if strings.Contains(c.Topic, "{{") {
+		tmpl, err := router.CompileTemplate(c.Topic)
+		if err != nil {
+			return fmt.Errorf("zmq topic: %s", err)
+		}
+		c.topic = tmpl
+	}
+	if c.HWM < 0 {
+		return fmt.Errorf("zmq hwm can't be negative")
+	}
+	return nil
+}
+
+// returns the topic a line is published with
+func (c *Config) TopicFor(logline *attach.Log) (string, error) {
+	switch {
+	case c.topic != nil:
+		return router.RenderTemplate(c.topic, logline)
+	case c.Topic != "":
+		return c.Topic, nil
+	}
+	if k8s := attach.CachedK8sContainer(logline.Name); k8s != nil {
+		return k8s.Namespace + "/" + k8s.Pod + "/" + k8s.Name, nil
+	}
+	return strings.TrimPrefix(logline.Name, "/"), nil
+}
+
+// returns the most lines queued for subscribers
+func (c *Config) QueueSize() int {
+	if c.HWM > 0 {
+		return c.HWM
+	}
+	return 1000
+}
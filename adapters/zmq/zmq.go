@@ -0,0 +1,123 @@
+// Package zmq adds the zmq+pub target type, publishing lines on a ZeroMQ PUB
+// socket.
+package zmq
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/go-zeromq/zmq4"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func init() {
+	router.RegisterAdapter("zmq+pub", router.AdapterType{
+		New:         newZMQAdapter,
+		DefaultPort: "5556",
+		Options:     "zmq",
+	})
+	router.RegisterTargetOptions("zmq", func() router.TargetOptions { return new(Config) })
+}
+
+// zmqAdapter publishes each line as a message of two frames, its topic and
+// its JSON, to whichever subscribers are subscribed to the topic
+type zmqAdapter struct {
+	route  *router.Route
+	config Config
+	hosts  []string
+
+	// the socket, opened when it's first needed and again after it
+	// couldn't be, since a restarted route's old socket may still be
+	// bound to the address
+	sync.Mutex
+	socket zmq4.Socket
+}
+
+func newZMQAdapter(route *router.Route) (router.Adapter, error) {
+	hosts, err := route.Target.HostPorts()
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if options, ok := route.Target.Options("zmq").(*Config); ok {
+		config = *options
+	}
+	return &zmqAdapter{route: route, config: config, hosts: hosts}, nil
+}
+
+func (a *zmqAdapter) Stream(logstream chan *attach.Log) {
+	items := make(chan *router.BatchItem)
+	go a.route.EncodeLines(logstream, items, a.route.Target.LineEncoder())
+	a.route.RunWorkers(items, func() (func([]*router.BatchItem), func()) {
+		return a.send, func() {}
+	})
+	a.Lock()
+	defer a.Unlock()
+	if a.socket != nil {
+		a.socket.Close()
+	}
+}
+
+// publishes a batch's lines. The socket queues them for its subscribers,
+// dropping those over the high-water mark as ZeroMQ does, so lines are
+// sent once they're queued.
+func (a *zmqAdapter) send(batch []*router.BatchItem) {
+	a.Lock()
+	defer a.Unlock()
+	if err := a.open(); err != nil {
+		a.failed(err, len(batch))
+		return
+	}
+	for _, item := range batch {
+		topic, err := a.config.TopicFor(item.Log)
+		if err != nil {
+			a.failed(err, 1)
+			continue
+		}
+		msg := zmq4.NewMsgFrom([]byte(topic), bytes.TrimSuffix(item.Buf.Bytes(), []byte("\n")))
+		if err := a.socket.SendMulti(msg); err != nil {
+			a.failed(err, 1)
+			continue
+		}
+		a.route.Status().Sent(item.Buf.Len())
+	}
+}
+
+// opens the socket if it isn't, binding the target's addresses or
+// connecting to them. Called with the adapter locked.
+func (a *zmqAdapter) open() error {
+	if a.socket != nil {
+		return nil
+	}
+	socket := zmq4.NewPub(context.Background(),
+		zmq4.WithDialerTimeout(a.route.Conn().Dialer().Timeout),
+		zmq4.WithAutomaticReconnect(a.config.Connect))
+	if err := socket.SetOption(zmq4.OptionHWM, a.config.QueueSize()); err != nil {
+		socket.Close()
+		return err
+	}
+	for _, hostport := range a.hosts {
+		var err error
+		if a.config.Connect {
+			err = socket.Dial("tcp://" + hostport)
+		} else {
+			err = socket.Listen("tcp://" + hostport)
+		}
+		if err != nil {
+			socket.Close()
+			return err
+		}
+	}
+	a.socket = socket
+	return nil
+}
+
+func (a *zmqAdapter) failed(err error, lines int) {
+	logging.Logger("zmq").Error("publish failed", "route", a.route.ID, "err", err)
+	a.route.Status().Failed(err)
+	a.route.Status().Dropped("error", lines)
+}
@@ -0,0 +1,442 @@
+// Package api serves logspout's HTTP API for streaming logs and managing
+// routes.
+package api
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	rdebug "runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/inputs"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// API serves the streaming endpoints and routes resource
+type API struct {
+	attacher *attach.AttachManager
+	router   *router.RouteManager
+	auth     *Authenticator
+	limiter  *StreamLimiter
+
+	ingestPumps *inputs.ContainerPumps
+
+	// serve pprof and runtime debugging endpoints under /debug
+	DebugEndpoints bool
+
+	// cross-origin access for browser clients, if set
+	CORS *CORSPolicy
+
+	// records changes to routes, if set
+	Audit *AuditLog
+
+	// re-reads the configuration, for POST /reload
+	Reload func() error
+
+	// what GET /version reports
+	Build BuildInfo
+}
+
+func NewAPI(attacher *attach.AttachManager, router *router.RouteManager, auth *Authenticator, limiter *StreamLimiter) *API {
+	return &API{attacher: attacher, router: router, auth: auth, limiter: limiter,
+		ingestPumps: inputs.NewContainerPumps(attacher, maxIngestPumps)}
+}
+
+// OpenAPI description of the /v1 API, served at /v1/spec
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+// current API version prefix. The unversioned paths remain as aliases for
+// existing clients.
+const apiVersion = "/v1"
+
+// query params accepted by the /logs endpoints; anything else is rejected
+var logsParams = map[string]bool{
+	"name_regex": true, "image_regex": true, "label": true, "namespace": true,
+	"data_regex": true, "types": true, "type": true, "tail": true, "since": true, "until": true, "severity": true,
+	"format": true, "colors": true, "timestamps": true, "token": true,
+}
+
+func (api *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, prefix := range []string{apiVersion, ""} {
+		mux.Handle("GET "+prefix+"/logs", api.auth.Require(ScopeRead, http.HandlerFunc(api.streamLogs)))
+		mux.Handle("GET "+prefix+"/logs/{selector}", api.auth.Require(ScopeRead, http.HandlerFunc(api.streamLogs)))
+		mux.Handle("GET "+prefix+"/routes", api.auth.Require(ScopeRead, http.HandlerFunc(api.listRoutes)))
+		mux.Handle("POST "+prefix+"/routes", api.auth.Require(ScopeAdmin, http.HandlerFunc(api.createRoute)))
+		mux.Handle("GET "+prefix+"/routes/{id}", api.auth.Require(ScopeRead, http.HandlerFunc(api.getRoute)))
+		mux.Handle("DELETE "+prefix+"/routes/{id}", api.auth.Require(ScopeAdmin, http.HandlerFunc(api.deleteRoute)))
+		mux.Handle("GET "+prefix+"/status", api.auth.Require(ScopeRead, http.HandlerFunc(api.status)))
+		mux.HandleFunc("GET "+prefix+"/version", api.version)
+		mux.Handle("POST "+prefix+"/ingest", api.auth.Require(ScopeIngest, http.HandlerFunc(api.ingest)))
+		mux.Handle("POST "+prefix+"/reload", api.auth.Require(ScopeAdmin, http.HandlerFunc(api.reload)))
+		mux.Handle("POST "+prefix+"/replay", api.auth.Require(ScopeAdmin, http.HandlerFunc(api.replay)))
+	}
+	mux.HandleFunc("GET "+apiVersion+"/spec", api.spec)
+	mux.HandleFunc("GET /healthz", api.healthz)
+	mux.HandleFunc("GET /readyz", api.readyz)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	if api.DebugEndpoints {
+		api.registerDebugEndpoints(mux)
+	}
+	if api.CORS != nil {
+		return logRequests(api.CORS.Wrap(mux))
+	}
+	return logRequests(mux)
+}
+
+func (api *API) streamLogs(w http.ResponseWriter, req *http.Request) {
+	source := new(attach.Source)
+	if selector := req.PathValue("selector"); selector != "" {
+		predicate, value, _ := strings.Cut(selector, ":")
+		switch {
+		case predicate == "id" && value != "":
+			if len(value) > 12 {
+				value = value[:12]
+			}
+			source.ID = value
+		case predicate == "name" && value != "":
+			source.Name = value
+		case predicate == "filter" && value != "":
+			source.Filter = value
+		default:
+			http.NotFound(w, req)
+			return
+		}
+	}
+
+	query := req.URL.Query()
+	for param := range query {
+		if !logsParams[param] {
+			http.Error(w, "Bad request: unknown query param "+strconv.Quote(param), http.StatusBadRequest)
+			return
+		}
+	}
+	source.NameRegex = query.Get("name_regex")
+	source.ImageRegex = query.Get("image_regex")
+	source.Namespace = query.Get("namespace")
+	source.DataRegex = query.Get("data_regex")
+	source.Types = attach.SplitList(query.Get("types") + "," + query.Get("type"))
+	source.Severities = attach.SplitList(query.Get("severity"))
+	for _, label := range query["label"] {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 {
+			http.Error(w, "Bad request: label must be key=value", http.StatusBadRequest)
+			return
+		}
+		if source.Labels == nil {
+			source.Labels = make(map[string]string)
+		}
+		source.Labels[parts[0]] = parts[1]
+	}
+	if tail := query.Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			http.Error(w, "Bad request: tail must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		source.Tail = n
+	}
+	now := time.Now()
+	var err error
+	if source.Since, err = parseTime(query.Get("since"), now); err != nil {
+		http.Error(w, "Bad request: since "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if source.Until, err = parseTime(query.Get("until"), now); err != nil {
+		http.Error(w, "Bad request: until "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !source.Since.IsZero() && !source.Until.IsZero() && !source.Until.After(source.Since) {
+		http.Error(w, "Bad request: until must be after since", http.StatusBadRequest)
+		return
+	}
+	if err := source.Validate(); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	websocketUpgrade := req.Header.Get("Upgrade") == "websocket"
+	eventStream := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	format := query.Get("format")
+	switch {
+	case format != "":
+	case websocketUpgrade || req.Header.Get("Accept") == "application/json":
+		format = "json"
+	case eventStream:
+		format = "ndjson"
+	default:
+		format = "text"
+	}
+	multi := source.ID == "" && source.Name == ""
+	formatter, err := NewLogFormatter(format, multi, query.Get("colors") != "off", query.Get("timestamps") == "on")
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if source.ID != "" && api.attacher.Get(source.ID) == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	client := clientAddr(req)
+	if err := api.limiter.Acquire(client); err != nil {
+		http.Error(w, "Too many streams", http.StatusServiceUnavailable)
+		return
+	}
+	defer api.limiter.Release(client)
+
+	logstream := make(chan *attach.Log)
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	limited, ctx := api.limiter.Govern(ctx, logstream)
+	// streams can end on their own, at until or when their container
+	// stops, so the streamer must write the last lines before returning
+	streamed := make(chan struct{})
+	var subs *wsSubscriptions
+	if websocketUpgrade {
+		subs = newWSSubscriptions(source)
+		go func() {
+			defer close(streamed)
+			websocketStreamer(w, req, subs, formatter, limited, cancel)
+		}()
+	} else if eventStream {
+		cw, done := compressResponse(w, req)
+		defer done()
+		go func() {
+			defer close(streamed)
+			sseStreamer(cw, req, source, formatter, limited)
+		}()
+	} else {
+		cw, done := compressResponse(w, req)
+		defer done()
+		go func() {
+			defer close(streamed)
+			httpStreamer(cw, req, source, formatter, limited)
+		}()
+	}
+
+	if websocketUpgrade {
+		// websocket clients can change their subscriptions, which select
+		// what they're sent as they change
+		window := &attach.Source{Tail: source.Tail, Since: source.Since, Until: source.Until}
+		api.attacher.ListenSelector(ctx, window, subs, logstream)
+	} else {
+		api.attacher.Listen(ctx, source, logstream)
+	}
+	close(logstream)
+	<-streamed
+}
+
+// parses a since or until param, a time in RFC 3339 format or a duration
+// before now, such as 5m
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be a time in RFC 3339 format or a duration, not %q", value)
+	}
+	return t, nil
+}
+
+func (api *API) spec(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(openapiSpec)
+}
+
+// listedRoute is a route as GET /routes lists it, with the lines it has
+// dropped and skipped by reason
+type listedRoute struct {
+	*router.Route
+	Dropped map[string]int64 `json:"dropped_by_reason,omitempty"`
+	Skipped map[string]int64 `json:"skipped,omitempty"`
+}
+
+// lists the routes, or those with every label=value of the label query
+// params
+func (api *API) listRoutes(w http.ResponseWriter, req *http.Request) {
+	labels := make(map[string]string)
+	for _, label := range req.URL.Query()["label"] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok {
+			http.Error(w, "Bad request: label must be key=value", http.StatusBadRequest)
+			return
+		}
+		labels[key] = value
+	}
+	w.Header().Add("Content-Type", "application/json")
+	routes, _ := api.router.GetAll()
+	listed := make([]listedRoute, 0, len(routes))
+	for _, route := range routes {
+		if !hasLabels(route, labels) {
+			continue
+		}
+		var item listedRoute
+		if route.Status() != nil {
+			item.Dropped, item.Skipped = route.Status().Counts()
+		}
+		if !api.auth.SeesSecrets(req) {
+			route = route.Redacted()
+		}
+		item.Route = route
+		listed = append(listed, item)
+	}
+	w.Write(append(attach.Marshal(listed), '\n'))
+}
+
+// reports whether a route has every label with its value
+func hasLabels(route *router.Route, labels map[string]string) bool {
+	for key, value := range labels {
+		if actual, ok := route.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (api *API) createRoute(w http.ResponseWriter, req *http.Request) {
+	route := new(router.Route)
+	if err := attach.Unmarshal(req.Body, route); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// only logspout says who created a route and when
+	route.CreatedAt, route.CreatedBy = nil, api.auth.Identity(req)
+
+	if err := api.router.Add(route); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	api.Audit.Record(req, api.auth, "create", nil, route)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(append(attach.Marshal(route), '\n'))
+}
+
+func (api *API) getRoute(w http.ResponseWriter, req *http.Request) {
+	route, _ := api.router.Get(req.PathValue("id"))
+	if route == nil {
+		http.NotFound(w, req)
+		return
+	}
+	if !api.auth.SeesSecrets(req) {
+		route = route.Redacted()
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(append(attach.Marshal(route), '\n'))
+}
+
+func (api *API) reload(w http.ResponseWriter, req *http.Request) {
+	if api.Reload == nil {
+		http.NotFound(w, req)
+		return
+	}
+	if err := api.Reload(); err != nil {
+		http.Error(w, "Reload failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	api.Audit.Record(req, api.auth, "reload", nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) deleteRoute(w http.ResponseWriter, req *http.Request) {
+	route, _ := api.router.Get(req.PathValue("id"))
+	if ok := api.router.Remove(req.PathValue("id")); !ok {
+		http.NotFound(w, req)
+		return
+	}
+	api.Audit.Record(req, api.auth, "delete", route, nil)
+}
+
+// statusRecorder captures the response status for request logging while
+// still letting streamers flush and websockets hijack the connection
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// logs each request once it completes, and turns handler panics into 500s
+// returns a request's URI for its log record, without the token query
+// parameter that websocket and EventSource clients authenticate with, see
+// Authenticator. Malformed queries are rebuilt from what parses of them, so
+// they can't carry it through either.
+func loggedURI(u *url.URL) string {
+	query, err := url.ParseQuery(u.RawQuery)
+	if err == nil && !query.Has("token") {
+		return u.RequestURI()
+	}
+	query.Del("token")
+	logged := *u
+	logged.RawQuery = query.Encode()
+	return logged.RequestURI()
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				logging.Logger("api").Error("panic serving request", "path", req.URL.Path, "err", err, "stack", string(rdebug.Stack()))
+				if recorder.status == 0 {
+					http.Error(recorder, "Internal server error", http.StatusInternalServerError)
+				}
+			}
+			logging.Logger("api").Info("request", "method", req.Method, "uri", loggedURI(req.URL), "status", recorder.status,
+				"duration", time.Since(start), "client", clientAddr(req))
+		}()
+		next.ServeHTTP(recorder, req)
+	})
+}
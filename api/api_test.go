@@ -0,0 +1,63 @@
+package api
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// the handler's patterns rely on the method and wildcard matching of Go
+// 1.22's ServeMux
+func TestHandlerPatterns(t *testing.T) {
+	auth, err := NewAuthenticator("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewAPI(nil, nil, auth, nil).Handler()
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/version", http.StatusOK},
+		{"GET", "/v1/version", http.StatusOK},
+		{"GET", "/v1/spec", http.StatusOK},
+		{"POST", "/version", http.StatusMethodNotAllowed},
+		{"DELETE", "/v1/logs", http.StatusMethodNotAllowed},
+		// an id selector is parsed before query params are checked
+		{"GET", "/logs/id:0123456789ab?bogus=1", http.StatusBadRequest},
+		{"GET", "/v1/logs/name:web?bogus=1", http.StatusBadRequest},
+		{"GET", "/logs/bogus:web", http.StatusNotFound},
+		{"GET", "/logs/id:0123456789ab/extra", http.StatusNotFound},
+		{"GET", "/nowhere", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.path, w.Code, test.status)
+		}
+	}
+}
+
+// tokens in the query, for clients that can't set headers, aren't logged
+func TestLogRequestsHidesToken(t *testing.T) {
+	var logged bytes.Buffer
+	logging.AddHandler(slog.NewTextHandler(&logged, nil))
+	handler := logRequests(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	for _, uri := range []string{"/logs?token=secret&colors=off", "/logs?token=secret;x=1", "/logs?%74oken=secret"} {
+		logged.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", uri, nil))
+		if strings.Contains(logged.String(), "secret") {
+			t.Errorf("%s: logged %s", uri, logged.String())
+		}
+		if !strings.Contains(logged.String(), "/logs") {
+			t.Errorf("%s: logged %s, want the path", uri, logged.String())
+		}
+	}
+}
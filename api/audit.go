@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// name of the pump audit events are published on, so routes and streams can
+// select them with name:logspout-audit
+const auditName = "logspout-audit"
+
+// AuditEvent records one change to the routes resource
+type AuditEvent struct {
+	Time    time.Time     `json:"time"`
+	Action  string        `json:"action"`
+	RouteID string        `json:"route_id"`
+	User    string        `json:"user,omitempty"`
+	Client  string        `json:"client"`
+	Before  *router.Route `json:"before,omitempty"`
+	After   *router.Route `json:"after,omitempty"`
+	// events dropped just before this one, for the "dropped" action
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// most audit events waiting to be written, past which they're dropped
+// rather than holding up the requests they record. Once there's room again,
+// a "dropped" event saying how many were goes in their place.
+const auditQueue = 1024
+
+var auditDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "logspout",
+	Name:      "audit_events_dropped_total",
+	Help:      "Audit events dropped because too many were waiting to be written.",
+})
+
+func init() {
+	prometheus.MustRegister(auditDropped)
+}
+
+// AuditLog appends route changes as JSON lines to a file, if configured, and
+// publishes them as the logs of a logspout-audit pump. Events are written in
+// the background, in the order they're recorded.
+type AuditLog struct {
+	// held to record events, and to close events
+	sync.RWMutex
+	closed bool
+
+	// held to queue events, so none is queued ahead of a dropped event
+	// that was recorded before it
+	queue sync.Mutex
+	// events dropped since the last one queued
+	dropped int
+
+	file   *os.File
+	pump   *attach.LogPump
+	events chan *AuditEvent
+	// closed once the events have all been written
+	done chan struct{}
+}
+
+// path is the file to append to; an empty path only publishes the stream
+func NewAuditLog(path string, attacher *attach.AttachManager) (*AuditLog, error) {
+	a := new(AuditLog)
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		a.file = file
+	}
+	a.pump = attach.NewInputPump("audit", auditName, auditName, map[string]string{"logspout.audit": "true"})
+	attacher.AddPump(a.pump)
+	a.events, a.done = make(chan *AuditEvent, auditQueue), make(chan struct{})
+	go a.write()
+	return a, nil
+}
+
+// records an action by the client of req. before and after are the route
+// as it was and as it is, either of which may be nil.
+func (a *AuditLog) Record(req *http.Request, auth *Authenticator, action string, before, after *router.Route) {
+	if a == nil {
+		return
+	}
+	event := &AuditEvent{
+		Time:   time.Now().UTC(),
+		Action: action,
+		User:   auth.Identity(req),
+		Client: clientAddr(req),
+	}
+	// the audit log is shipped like any other logs, so it never has secrets
+	if before != nil {
+		event.Before = before.Redacted()
+	}
+	if after != nil {
+		event.After = after.Redacted()
+	}
+	if after != nil {
+		event.RouteID = after.ID
+	} else if before != nil {
+		event.RouteID = before.ID
+	}
+	a.RLock()
+	defer a.RUnlock()
+	if a.closed {
+		return
+	}
+	a.queue.Lock()
+	defer a.queue.Unlock()
+	if a.dropped > 0 {
+		select {
+		case a.events <- &AuditEvent{Time: event.Time, Action: "dropped", Dropped: a.dropped}:
+			a.dropped = 0
+		default:
+		}
+	}
+	if a.dropped == 0 {
+		select {
+		case a.events <- event:
+			return
+		default:
+		}
+	}
+	a.dropped++
+	auditDropped.Inc()
+	logging.Logger("audit").Warn("audit event dropped, too many waiting", "action", action, "route", event.RouteID)
+}
+
+// writes the events still waiting and closes the file. Events recorded
+// after are dropped, so it's for once the API has stopped serving.
+func (a *AuditLog) Close() {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	a.closed = true
+	if a.dropped > 0 {
+		// the writer makes room, as nothing else is being recorded
+		a.events <- &AuditEvent{Time: time.Now().UTC(), Action: "dropped", Dropped: a.dropped}
+	}
+	close(a.events)
+	a.Unlock()
+	<-a.done
+	if a.file != nil {
+		a.file.Close()
+	}
+}
+
+// appends recorded events to the file and publishes them
+func (a *AuditLog) write() {
+	defer close(a.done)
+	for event := range a.events {
+		line, _ := json.Marshal(event)
+		line = append(line, '\n')
+		if a.file != nil {
+			if _, err := a.file.Write(line); err != nil {
+				logging.Logger("audit").Error("writing audit log failed", "err", err)
+			}
+		}
+		a.pump.Inject(&attach.Log{
+			ID:        a.pump.ID,
+			Name:      auditName,
+			Image:     auditName,
+			Type:      attach.StreamInternal,
+			Data:      string(line[:len(line)-1]),
+			Time:      event.Time,
+			Timestamp: event.Time,
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := NewAuditLog(path, attach.NewInputManager())
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := NewAuthenticator("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/routes", nil)
+	route := &router.Route{ID: "abc", Target: router.Target{Type: "syslog", Addr: "host:514", Sign: &router.SignConfig{Key: "secret"}}}
+	audit.Record(req, auth, "create", nil, route)
+	audit.Record(req, auth, "delete", route, nil)
+	audit.Close()
+	// recorded once closed, so dropped
+	audit.Record(req, auth, "reload", nil, nil)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var actions []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.RouteID != "abc" {
+			t.Errorf("got route ID %q, want abc", event.RouteID)
+		}
+		if shown := event.After; shown != nil && shown.Target.Sign.Key != "[redacted]" {
+			t.Errorf("audit log shows sign key %q", shown.Target.Sign.Key)
+		}
+		actions = append(actions, event.Action)
+	}
+	if len(actions) != 2 || actions[0] != "create" || actions[1] != "delete" {
+		t.Errorf("got actions %v, want create then delete", actions)
+	}
+}
+
+func TestAuditLogDrops(t *testing.T) {
+	auth, err := NewAuthenticator("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a writer, so the queue fills
+	audit := &AuditLog{events: make(chan *AuditEvent, 2)}
+	dropped := testutil.ToFloat64(auditDropped)
+	req := httptest.NewRequest("POST", "/reload", nil)
+	for range 4 {
+		audit.Record(req, auth, "reload", nil, nil)
+	}
+	if got := testutil.ToFloat64(auditDropped) - dropped; got != 2 {
+		t.Errorf("got %v events dropped, want 2", got)
+	}
+	// once there's room, how many were dropped is recorded before the next
+	<-audit.events
+	<-audit.events
+	audit.Record(req, auth, "create", nil, nil)
+	var actions []string
+	for range 2 {
+		event := <-audit.events
+		actions = append(actions, event.Action)
+		if event.Action == "dropped" && event.Dropped != 2 {
+			t.Errorf("got %d events dropped recorded, want 2", event.Dropped)
+		}
+	}
+	if len(actions) != 2 || actions[0] != "dropped" || actions[1] != "create" {
+		t.Errorf("got actions %v, want dropped then create", actions)
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// Scope is the role credentials are granted. Requests without credentials
+// have ScopeNone, which reaches only the endpoints that don't require
+// authentication: /metrics, /healthz, /readyz, /version and /v1/spec.
+type Scope int
+
+const (
+	ScopeNone Scope = iota
+	// the viewer role, which streams logs and views routes and status
+	ScopeRead
+	// the admin role, which also changes routes and everything else
+	ScopeAdmin
+	// allows POST /ingest only, for clients that ship logs in
+	ScopeIngest
+)
+
+// reports whether the scope allows what required does. Admin allows
+// everything, other scopes just their own.
+func (s Scope) allows(required Scope) bool {
+	return s == required || s == ScopeAdmin
+}
+
+func parseScope(name string) (Scope, error) {
+	switch name {
+	case "viewer", "read":
+		return ScopeRead, nil
+	case "admin":
+		return ScopeAdmin, nil
+	case "ingest":
+		return ScopeIngest, nil
+	}
+	return ScopeNone, fmt.Errorf("unknown role %q, expected viewer, admin or ingest", name)
+}
+
+type credential struct {
+	password string
+	scope    Scope
+}
+
+// Authenticator checks API requests against configured bearer tokens and
+// basic auth users. With nothing configured, every request is allowed.
+type Authenticator struct {
+	sync.RWMutex
+	tokens map[string]Scope
+	users  map[string]credential
+}
+
+// tokens is a comma-separated list of role:token, users a comma-separated
+// list of role:user:password
+func NewAuthenticator(tokens, users string) (*Authenticator, error) {
+	a := &Authenticator{}
+	if err := a.Update(tokens, users); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// replaces the tokens and users, as NewAuthenticator takes them, keeping
+// the current ones if they're invalid
+func (a *Authenticator) Update(tokens, users string) error {
+	tokenScopes := make(map[string]Scope)
+	for _, entry := range attach.SplitList(tokens) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return fmt.Errorf("invalid token entry %q, expected role:token", entry)
+		}
+		scope, err := parseScope(parts[0])
+		if err != nil {
+			return err
+		}
+		tokenScopes[parts[1]] = scope
+	}
+	userCredentials := make(map[string]credential)
+	for _, entry := range attach.SplitList(users) {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[1] == "" {
+			return fmt.Errorf("invalid user entry %q, expected role:user:password", entry)
+		}
+		scope, err := parseScope(parts[0])
+		if err != nil {
+			return err
+		}
+		userCredentials[parts[1]] = credential{password: parts[2], scope: scope}
+	}
+	a.Lock()
+	a.tokens, a.users = tokenScopes, userCredentials
+	a.Unlock()
+	return nil
+}
+
+// reports whether the request may see secrets in routes, which only admins
+// may when authentication is enabled
+func (a *Authenticator) SeesSecrets(req *http.Request) bool {
+	return !a.Enabled() || a.Scope(req) == ScopeAdmin
+}
+
+func (a *Authenticator) Enabled() bool {
+	a.RLock()
+	defer a.RUnlock()
+	return len(a.tokens) > 0 || len(a.users) > 0
+}
+
+// returns the scope granted to a request's credentials. Tokens can be sent
+// as a bearer token or, for websocket clients that can't set headers, as the
+// token query param.
+func (a *Authenticator) Scope(req *http.Request) Scope {
+	a.RLock()
+	defer a.RUnlock()
+	if user, password, ok := req.BasicAuth(); ok {
+		cred, exists := a.users[user]
+		if exists && subtle.ConstantTimeCompare([]byte(password), []byte(cred.password)) == 1 {
+			return cred.scope
+		}
+		return ScopeNone
+	}
+	token := req.URL.Query().Get("token")
+	if header := req.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	if token == "" {
+		return ScopeNone
+	}
+	granted := ScopeNone
+	for candidate, scope := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			granted = scope
+		}
+	}
+	return granted
+}
+
+// returns who made a request, for auditing: the basic auth user, or a short
+// fingerprint of the token so it can be told apart without being revealed
+func (a *Authenticator) Identity(req *http.Request) string {
+	if user, _, ok := req.BasicAuth(); ok {
+		return user
+	}
+	token := req.URL.Query().Get("token")
+	if header := req.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:4])
+}
+
+// wraps next to reject requests without the given scope
+func (a *Authenticator) Require(scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if a.Enabled() {
+			granted := a.Scope(req)
+			if granted == ScopeNone {
+				w.Header().Set("WWW-Authenticate", `Basic realm="logspout"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !granted.allows(scope) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter compresses a streaming response, flushing the compressor
+// along with the response so lines aren't held back in its window.
+type compressWriter struct {
+	http.ResponseWriter
+	sync.Mutex
+	encoder flushWriteCloser
+	closed  bool
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return c.encoder.Write(p)
+}
+
+func (c *compressWriter) Flush() {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return
+	}
+	c.encoder.Flush()
+	c.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (c *compressWriter) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.closed = true
+	return c.encoder.Close()
+}
+
+// wraps w to gzip or deflate the response if the client accepts it. The
+// returned func must be called once the response is complete.
+func compressResponse(w http.ResponseWriter, req *http.Request) (http.ResponseWriter, func()) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	var encoder flushWriteCloser
+	switch acceptedEncoding(req) {
+	case "gzip":
+		encoder = gzip.NewWriter(w)
+	case "deflate":
+		encoder, _ = flate.NewWriter(w, flate.DefaultCompression)
+	default:
+		return w, func() {}
+	}
+	w.Header().Set("Content-Encoding", acceptedEncoding(req))
+	w.Header().Del("Content-Length")
+	cw := &compressWriter{ResponseWriter: w, encoder: encoder}
+	return cw, func() { cw.Close() }
+}
+
+// returns gzip or deflate if listed in Accept-Encoding, preferring gzip
+func acceptedEncoding(req *http.Request) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		disabled := len(fields) > 1 && strings.Replace(strings.TrimSpace(fields[1]), " ", "", -1) == "q=0"
+		accepted[coding] = !disabled
+	}
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// CORSPolicy lets browsers on the allowed origins call the /logs and /routes
+// endpoints directly
+type CORSPolicy struct {
+	origins map[string]bool
+	headers string
+}
+
+// origins is a comma-separated list of allowed origins, or * for any. headers
+// lists request headers allowed on top of Authorization and Content-Type.
+func NewCORSPolicy(origins, headers string) *CORSPolicy {
+	c := &CORSPolicy{
+		origins: make(map[string]bool),
+		headers: strings.Join(append([]string{"Authorization", "Content-Type"}, attach.SplitList(headers)...), ", "),
+	}
+	for _, origin := range attach.SplitList(origins) {
+		c.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	return c
+}
+
+func (c *CORSPolicy) Enabled() bool {
+	return len(c.origins) > 0
+}
+
+func (c *CORSPolicy) allowed(origin string) bool {
+	return c.origins["*"] || c.origins[origin]
+}
+
+// wraps next to add CORS headers for allowed origins and answer preflight
+// requests, which browsers send without credentials, before auth runs
+func (c *CORSPolicy) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if !c.Enabled() || origin == "" || !corsPath(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !c.allowed(origin) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if c.origins[origin] {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			// any site can call the API, but not with the browser's
+			// credentials, which only origins listed by name get
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		if req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", c.headers)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func corsPath(path string) bool {
+	path = strings.TrimPrefix(path, apiVersion)
+	for _, prefix := range []string{"/logs", "/routes"} {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	tests := []struct {
+		origins, origin    string
+		allow, credentials string
+	}{
+		{"https://dash.example.com", "https://dash.example.com", "https://dash.example.com", "true"},
+		{"https://dash.example.com", "https://evil.example.com", "", ""},
+		// any origin, but without the browser's credentials
+		{"*", "https://evil.example.com", "*", ""},
+		{"*,https://dash.example.com", "https://dash.example.com", "https://dash.example.com", "true"},
+		{"*,https://dash.example.com", "https://evil.example.com", "*", ""},
+	}
+	for _, test := range tests {
+		for _, method := range []string{"GET", "OPTIONS"} {
+			req := httptest.NewRequest(method, "/routes", nil)
+			req.Header.Set("Origin", test.origin)
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			w := httptest.NewRecorder()
+			NewCORSPolicy(test.origins, "").Wrap(next).ServeHTTP(w, req)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.allow {
+				t.Errorf("%s from %s with %s: got allowed origin %q, want %q", method, test.origin, test.origins, got, test.allow)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != test.credentials {
+				t.Errorf("%s from %s with %s: got allowed credentials %q, want %q", method, test.origin, test.origins, got, test.credentials)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// LogFormatter renders log lines for the streaming endpoints. It keeps state
+// (colors, column width) so each stream gets its own.
+type LogFormatter struct {
+	Name        string
+	ContentType string
+	format      func(*attach.Log) []byte
+}
+
+// returns a formatter for one of raw, json, ndjson, logfmt or text. multi
+// prefixes text lines with the container name, colored unless color is off.
+func NewLogFormatter(format string, multi, color, timestamps bool) (*LogFormatter, error) {
+	f := &LogFormatter{Name: format, ContentType: "text/plain"}
+	stamp := func(logline *attach.Log) string {
+		if !timestamps {
+			return ""
+		}
+		return logline.Time.UTC().Format(time.RFC3339Nano) + " "
+	}
+	switch format {
+	case "raw":
+		f.format = func(logline *attach.Log) []byte {
+			return []byte(stamp(logline) + logline.Data)
+		}
+	case "json":
+		f.ContentType = "application/json"
+		f.format = func(logline *attach.Log) []byte {
+			return attach.Marshal(logline)
+		}
+	case "ndjson":
+		f.ContentType = "application/x-ndjson"
+		f.format = func(logline *attach.Log) []byte {
+			data, _ := json.Marshal(logline)
+			return data
+		}
+	case "logfmt":
+		f.format = func(logline *attach.Log) []byte {
+			var buf bytes.Buffer
+			for _, pair := range [][2]string{
+				{"time", logline.Time.UTC().Format(time.RFC3339Nano)},
+				{"id", logline.ID},
+				{"name", logline.Name},
+				{"image", logline.Image},
+				{"type", logline.Type},
+				{"data", logline.Data},
+			} {
+				if buf.Len() > 0 {
+					buf.WriteByte(' ')
+				}
+				buf.WriteString(pair[0] + "=" + router.LogfmtValue(pair[1]))
+			}
+			return buf.Bytes()
+		}
+	case "text":
+		if !multi {
+			return NewLogFormatter("raw", multi, color, timestamps)
+		}
+		colors := make(Colorizer)
+		nameWidth := 16
+		f.format = func(logline *attach.Log) []byte {
+			if len(logline.Name) > nameWidth {
+				nameWidth = len(logline.Name)
+			}
+			line := make([]byte, 0, nameWidth+len(logline.Data)+48)
+			if color {
+				line = append(line, colors.Get(logline.Name)...)
+			}
+			line = append(line, stamp(logline)...)
+			for i := len(logline.Name); i < nameWidth; i++ {
+				line = append(line, ' ')
+			}
+			line = append(line, logline.Name...)
+			line = append(line, '|')
+			line = append(line, logline.Data...)
+			if color {
+				line = append(line, "\x1b[0m"...)
+			}
+			return line
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return f, nil
+}
+
+func (f *LogFormatter) Format(logline *attach.Log) []byte {
+	return f.format(logline)
+}
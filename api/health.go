@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// liveness: Docker is reachable and, if there are routes, at least one of
+// them is delivering
+func (api *API) healthz(w http.ResponseWriter, req *http.Request) {
+	api.writeHealth(w, true)
+}
+
+// readiness: Docker is reachable so containers can be attached
+func (api *API) readyz(w http.ResponseWriter, req *http.Request) {
+	api.writeHealth(w, false)
+}
+
+func (api *API) writeHealth(w http.ResponseWriter, checkRoutes bool) {
+	report := healthReport{Status: "ok", Checks: make(map[string]string)}
+	if err := api.attacher.Ping(); err != nil {
+		report.Status = "unhealthy"
+		report.Checks["docker"] = err.Error()
+	} else {
+		report.Checks["docker"] = "ok"
+	}
+	if checkRoutes {
+		routes, _ := api.router.GetAll()
+		for _, route := range routes {
+			if route.Status().Healthy() {
+				report.Checks["route:"+route.ID] = "ok"
+				continue
+			}
+			route.Status().Lock()
+			report.Checks["route:"+route.ID] = route.Status().LastError
+			route.Status().Unlock()
+		}
+		if api.router.AllFailing() {
+			report.Status = "unhealthy"
+		}
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(append(attach.Marshal(report), '\n'))
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// largest body and line POST /ingest accepts
+const (
+	maxIngestBody = 16 << 20
+	maxIngestLine = 1 << 20
+)
+
+// most distinct names lines can be ingested under at once, as each gets a
+// pump, which is removed once no lines have been ingested under its name for
+// a while
+const maxIngestPumps = 1024
+
+// publishes the lines of the body as the logs of the virtual container
+// named by the name param, with the image given by image and labels by
+// label params. NDJSON bodies have a line per object, with its data or
+// message as the line, optional timestamp, severity and type, and any other
+// keys as fields. Other bodies have a line per line of text. Nothing is
+// published unless the whole body is valid.
+func (api *API) ingest(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		http.Error(w, "Bad request: name is required", http.StatusBadRequest)
+		return
+	}
+	image := query.Get("image")
+	if image == "" {
+		image = name
+	}
+	typ := query.Get("type")
+	if typ == "" {
+		typ = "stdout"
+	}
+	labels, err := keyValueParams(query["label"])
+	if err != nil {
+		http.Error(w, "Bad request: label "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	fields, err := keyValueParams(query["field"])
+	if err != nil {
+		http.Error(w, "Bad request: field "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ndjson := strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-ndjson") ||
+		strings.HasPrefix(req.Header.Get("Content-Type"), "application/json")
+	id := "ingest-" + name
+	var loglines []*attach.Log
+	rd := bufio.NewReaderSize(http.MaxBytesReader(w, req.Body, maxIngestBody), 64*1024)
+	for n := 1; ; n++ {
+		line, err := readIngestLine(rd)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad request: line %d: %s", n, err), http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		now := time.Now().UTC()
+		logline := &attach.Log{
+			ID:        id,
+			Name:      name,
+			Image:     image,
+			Type:      typ,
+			Data:      line,
+			Time:      now,
+			Timestamp: now,
+		}
+		if ndjson {
+			if err := decodeIngestLine(logline, line); err != nil {
+				http.Error(w, fmt.Sprintf("Bad request: line %d: %s", n, err), http.StatusBadRequest)
+				return
+			}
+		}
+		for key, value := range fields {
+			if _, present := logline.Fields[key]; !present {
+				if logline.Fields == nil {
+					logline.Fields = make(map[string]interface{}, len(fields))
+				}
+				logline.Fields[key] = value
+			}
+		}
+		loglines = append(loglines, logline)
+	}
+	pump := api.ingestPumps.Get(id, name, image, labels)
+	if pump == nil {
+		http.Error(w, "Too many ingest names", http.StatusServiceUnavailable)
+		return
+	}
+	for _, logline := range loglines {
+		pump.Inject(logline)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reads a line of up to maxIngestLine bytes, without its newline
+func readIngestLine(rd *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := rd.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, part...)
+		if len(line) > maxIngestLine {
+			return "", fmt.Errorf("longer than %d bytes", maxIngestLine)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// sets the line from a JSON object
+func decodeIngestLine(logline *attach.Log, line string) error {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &doc); err != nil || doc == nil {
+		return fmt.Errorf("not a JSON object")
+	}
+	data, ok := doc["data"].(string)
+	if !ok {
+		if data, ok = doc["message"].(string); !ok {
+			return fmt.Errorf("data or message is required")
+		}
+	}
+	logline.Data = data
+	delete(doc, "data")
+	if value, ok := doc["timestamp"].(string); ok {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return fmt.Errorf("timestamp: %s", err)
+		}
+		logline.Timestamp = t.UTC()
+		delete(doc, "timestamp")
+	}
+	if value, ok := doc["severity"].(string); ok {
+		logline.Severity = attach.NormalizeSeverity(value)
+		delete(doc, "severity")
+	}
+	if value, ok := doc["type"].(string); ok {
+		logline.Type = value
+		delete(doc, "type")
+	}
+	if len(doc) > 0 {
+		logline.Fields = doc
+	}
+	return nil
+}
+
+// parses key=value params
+func keyValueParams(params []string) (map[string]string, error) {
+	var values map[string]string
+	for _, param := range params {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("must be key=value")
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
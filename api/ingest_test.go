@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+func TestIngest(t *testing.T) {
+	attacher := attach.NewInputManager()
+	auth, err := NewAuthenticator("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewAPI(attacher, nil, auth, nil).Handler()
+	post := func(query, body string) int {
+		req := httptest.NewRequest("POST", "/ingest?"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// nothing is published from a body with an invalid line
+	if status := post("name=job", `{"data": "one"}`+"\n"+`not json`+"\n"); status != http.StatusBadRequest {
+		t.Fatalf("got status %d for an invalid body, want %d", status, http.StatusBadRequest)
+	}
+	if pump := attacher.Get("ingest-job"); pump != nil {
+		t.Fatalf("invalid body published %d lines", pump.Buffered())
+	}
+
+	if status := post("name=job&label=team=a", `{"data": "one"}`+"\n"+`{"message": "two"}`+"\n"); status != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", status, http.StatusNoContent)
+	}
+	pump := attacher.Get("ingest-job")
+	if pump == nil || pump.Buffered() != 2 || pump.Labels["team"] != "a" || pump.Image != "job" {
+		t.Fatalf("got pump %+v, want one with both lines, its label and image", pump)
+	}
+
+	// the pump is replaced as its labels and image change
+	if status := post("name=job&image=jobs:2&label=team=b", `{"data": "three"}`); status != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", status, http.StatusNoContent)
+	}
+	pump = attacher.Get("ingest-job")
+	if pump == nil || pump.Labels["team"] != "b" || pump.Image != "jobs:2" {
+		t.Fatalf("got pump %+v, want one with the new label and image", pump)
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+var errTooManyStreams = errors.New("too many streams")
+
+// StreamLimiter caps the number of /logs streams, overall and per client
+// address, and governs each stream's line rate and lifetime.
+type StreamLimiter struct {
+	sync.Mutex
+	MaxStreams       int
+	MaxClientStreams int
+	RateLimit        float64 // lines per second per stream
+	IdleTimeout      time.Duration
+	MaxDuration      time.Duration
+	streams          int
+	clients          map[string]int
+}
+
+// configures a limiter from MAX_STREAMS, MAX_CLIENT_STREAMS,
+// STREAM_RATE_LIMIT, STREAM_IDLE_TIMEOUT and STREAM_MAX_DURATION, as read
+// by getopt. Zero or unset values mean no limit.
+func NewStreamLimiterFromEnv(getopt func(name, dfault string) string) (*StreamLimiter, error) {
+	l := &StreamLimiter{clients: make(map[string]int)}
+	var err error
+	if l.MaxStreams, err = strconv.Atoi(getopt("MAX_STREAMS", "0")); err != nil {
+		return nil, errors.New("MAX_STREAMS: " + err.Error())
+	}
+	if l.MaxClientStreams, err = strconv.Atoi(getopt("MAX_CLIENT_STREAMS", "0")); err != nil {
+		return nil, errors.New("MAX_CLIENT_STREAMS: " + err.Error())
+	}
+	if l.RateLimit, err = strconv.ParseFloat(getopt("STREAM_RATE_LIMIT", "0"), 64); err != nil {
+		return nil, errors.New("STREAM_RATE_LIMIT: " + err.Error())
+	}
+	if l.IdleTimeout, err = time.ParseDuration(getopt("STREAM_IDLE_TIMEOUT", "0")); err != nil {
+		return nil, errors.New("STREAM_IDLE_TIMEOUT: " + err.Error())
+	}
+	if l.MaxDuration, err = time.ParseDuration(getopt("STREAM_MAX_DURATION", "0")); err != nil {
+		return nil, errors.New("STREAM_MAX_DURATION: " + err.Error())
+	}
+	return l, nil
+}
+
+func clientAddr(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// reserves a stream slot for the client, to be returned with Release
+func (l *StreamLimiter) Acquire(client string) error {
+	l.Lock()
+	defer l.Unlock()
+	if l.MaxStreams > 0 && l.streams >= l.MaxStreams {
+		return errTooManyStreams
+	}
+	if l.MaxClientStreams > 0 && l.clients[client] >= l.MaxClientStreams {
+		return errTooManyStreams
+	}
+	l.streams++
+	l.clients[client]++
+	return nil
+}
+
+func (l *StreamLimiter) Release(client string) {
+	l.Lock()
+	defer l.Unlock()
+	l.streams--
+	if l.clients[client]--; l.clients[client] <= 0 {
+		delete(l.clients, client)
+	}
+}
+
+// relays lines from logstream to the returned channel, dropping lines over
+// the rate limit. The returned context is done when ctx is, or when the
+// stream has been idle or open for too long. The returned channel is closed
+// once logstream is.
+func (l *StreamLimiter) Govern(ctx context.Context, logstream chan *attach.Log) (chan *attach.Log, context.Context) {
+	limited := make(chan *attach.Log)
+	var cancel context.CancelFunc
+	if l.MaxDuration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, l.MaxDuration)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	go func() {
+		defer close(limited)
+		defer cancel()
+		var idle *time.Timer
+		var idleC <-chan time.Time
+		if l.IdleTimeout > 0 {
+			idle = time.NewTimer(l.IdleTimeout)
+			defer idle.Stop()
+			idleC = idle.C
+		}
+		tokens, last := l.RateLimit, time.Now()
+		for {
+			select {
+			case logline, ok := <-logstream:
+				if !ok {
+					return
+				}
+				if ctx.Err() != nil {
+					continue
+				}
+				if idle != nil {
+					idle.Reset(l.IdleTimeout)
+				}
+				if l.RateLimit > 0 {
+					now := time.Now()
+					tokens += now.Sub(last).Seconds() * l.RateLimit
+					if tokens > l.RateLimit {
+						tokens = l.RateLimit
+					}
+					last = now
+					if tokens < 1 {
+						continue
+					}
+					tokens--
+				}
+				select {
+				case limited <- logline:
+				case <-ctx.Done():
+				}
+			case <-idleC:
+				logging.Logger("stream").Debug("idle timeout")
+				cancel()
+			}
+		}
+	}()
+	return limited, ctx
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jimmidyson/logspout/adapters/azure"
+	_ "github.com/jimmidyson/logspout/adapters/elasticsearch"
+	"github.com/jimmidyson/logspout/adapters/firehose"
+	"github.com/jimmidyson/logspout/adapters/kafka"
+	"github.com/jimmidyson/logspout/adapters/logentries"
+	"github.com/jimmidyson/logspout/adapters/mongodb"
+	"github.com/jimmidyson/logspout/adapters/postgres"
+	_ "github.com/jimmidyson/logspout/adapters/relay"
+	"github.com/jimmidyson/logspout/adapters/s3"
+	_ "github.com/jimmidyson/logspout/adapters/syslog"
+	_ "github.com/jimmidyson/logspout/adapters/tcp"
+	_ "github.com/jimmidyson/logspout/adapters/udp"
+	"github.com/jimmidyson/logspout/adapters/ws"
+	"github.com/jimmidyson/logspout/adapters/zmq"
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// schema is the part of JSON Schema api/openapi.json uses
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Required             []string           `json:"required"`
+	Enum                 []interface{}      `json:"enum"`
+	Items                *schema            `json:"items"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinProperties        *int               `json:"minProperties"`
+	MaxProperties        *int               `json:"maxProperties"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+}
+
+// schemaChecker checks values against the spec's schemas, noting the
+// properties they set so those the values never set can be found
+type schemaChecker struct {
+	schemas map[string]*schema
+	errs    []string
+	// properties of the checked object schemas, by schema name, and
+	// whether a value set them
+	set map[string]map[string]bool
+}
+
+func (c *schemaChecker) errorf(path, format string, args ...interface{}) {
+	c.errs = append(c.errs, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (c *schemaChecker) check(path, name string, s *schema, value interface{}) {
+	if s.Ref != "" {
+		name = strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		if s = c.schemas[name]; s == nil {
+			c.errorf(path, "unknown schema %s", name)
+			return
+		}
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, value) {
+		c.errorf(path, "%v isn't one of %v", value, s.Enum)
+	}
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			c.errorf(path, "%v isn't an object", value)
+			return
+		}
+		c.checkObject(path, name, s, object)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			c.errorf(path, "%v isn't an array", value)
+			return
+		}
+		for i, item := range items {
+			if s.Items != nil {
+				c.check(fmt.Sprintf("%s[%d]", path, i), name+"[]", s.Items, item)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			c.errorf(path, "%v isn't a string", value)
+			return
+		}
+		if s.MaxLength != nil && len(str) > *s.MaxLength {
+			c.errorf(path, "%q is longer than %d", str, *s.MaxLength)
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(str) {
+			c.errorf(path, "%q doesn't match %s", str, s.Pattern)
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok || (s.Type == "integer" && number != math.Trunc(number)) {
+			c.errorf(path, "%v isn't an %s", value, s.Type)
+			return
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			c.errorf(path, "%v is less than %v", number, *s.Minimum)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			c.errorf(path, "%v is more than %v", number, *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			c.errorf(path, "%v isn't a boolean", value)
+		}
+	case "":
+	default:
+		c.errorf(path, "unknown schema type %s", s.Type)
+	}
+}
+
+func (c *schemaChecker) checkObject(path, name string, s *schema, object map[string]interface{}) {
+	for _, key := range s.Required {
+		if _, ok := object[key]; !ok {
+			c.errorf(path, "%s is required", key)
+		}
+	}
+	if s.MinProperties != nil && len(object) < *s.MinProperties {
+		c.errorf(path, "has fewer than %d properties", *s.MinProperties)
+	}
+	if s.MaxProperties != nil && len(object) > *s.MaxProperties {
+		c.errorf(path, "has more than %d properties", *s.MaxProperties)
+	}
+	if len(s.Properties) > 0 && c.set[name] == nil {
+		c.set[name] = make(map[string]bool)
+		for key := range s.Properties {
+			c.set[name][key] = false
+		}
+	}
+	var additional *schema
+	if len(s.AdditionalProperties) > 0 && string(s.AdditionalProperties) != "true" && string(s.AdditionalProperties) != "false" {
+		additional = new(schema)
+		if err := json.Unmarshal(s.AdditionalProperties, additional); err != nil {
+			c.errorf(path, "additionalProperties: %v", err)
+			return
+		}
+	}
+	for key, value := range object {
+		property := s.Properties[key]
+		switch {
+		case property != nil:
+			c.set[name][key] = true
+			c.check(path+"."+key, name+"."+key, property, value)
+		case additional != nil:
+			c.check(path+"."+key, name+".*", additional, value)
+		case string(s.AdditionalProperties) == "false":
+			c.errorf(path, "%s isn't in the schema", key)
+		}
+	}
+}
+
+// notes in set whether each field of the structs in v, as Type.Field, is
+// set anywhere
+func zeroFields(v reflect.Value, set map[string]bool) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			zeroFields(v.Elem(), set)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			key := v.Type().Name() + "." + field.Name
+			set[key] = set[key] || !v.Field(i).IsZero()
+			zeroFields(v.Field(i), set)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			zeroFields(v.Index(i), set)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			zeroFields(v.MapIndex(key), set)
+		}
+	}
+}
+
+// returns a target with every field and adapter's options set, within
+// what the schema allows
+func fullTarget() (router.Target, []router.TargetOptions) {
+	target := router.Target{
+		Type:       "kafka+tls",
+		Addr:       "broker:9093",
+		AppendTag:  ".prod",
+		FieldNames: map[string]string{"message": "msg"},
+		Fields:     map[string]string{"env": "prod"},
+		FieldCase:  "lower",
+		Schema:     "v2",
+		Parsers:    []string{"logfmt"},
+		Extract:    []attach.ExtractRule{{Pattern: "%{IP:client}", Image: "^nginx"}},
+		Enrich:     []string{"geoip"},
+		Where:      `severity == "error"`,
+		Compute:    map[string]string{"short": "upper(container)"},
+		Plugins:    []string{"/plugins/scrub.wasm"},
+		Pipeline: []router.StageConfig{
+			{Parse: "json"},
+			{Extract: &attach.ExtractRule{Pattern: "%{IP:client}"}},
+			{Enrich: "geoip"},
+			{Compute: map[string]string{"short": "upper(container)"}},
+			{Plugin: "/plugins/scrub.wasm"},
+			{Where: `severity == "error"`},
+			{Sample: map[string]float64{"debug": 0.1}},
+		},
+		Template:      "{{.Message}}",
+		Sample:        map[string]float64{"debug": 0.1, "default": 1},
+		Batch:         &router.BatchConfig{MaxEvents: 100, MaxBytes: 1 << 20, MaxLatency: "1s"},
+		Workers:       4,
+		Conn:          &router.ConnConfig{DialTimeout: "10s", WriteTimeout: "30s", KeepAlive: "15s"},
+		TLS:           &router.TLSConfig{CA: "/tls/ca.pem", Cert: "/tls/cert.pem", Key: "/tls/key.pem", ServerName: "broker", MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+		Processor:     &router.ProcessorConfig{URL: "https://processor/batch", Timeout: "5s", OnError: "drop"},
+		Proxy:         "http://proxy:3128",
+		Compression:   "zstd",
+		Encoding:      "protobuf",
+		Sign:          &router.SignConfig{Key: "key", KeyFile: "/secrets/key", Header: "X-Signature"},
+		MaxDatagram:   1400,
+		PackDatagrams: true,
+	}
+	options := map[string]router.TargetOptions{
+		"kafka": &kafka.Config{
+			Topic:              "logs",
+			Key:                "pod",
+			Acks:               "leader",
+			DisableIdempotence: true,
+			SASL:               &kafka.SASLConfig{Mechanism: "scram-sha-256", Username: "user", Password: "password", PasswordFile: "/secrets/sasl"},
+			Encoding:           "avro",
+			SchemaRegistry:     &kafka.SchemaRegistryConfig{URL: "http://registry:8081", Username: "user", Password: "password"},
+		},
+		"s3":         &s3.Config{Region: "eu-west-1", Endpoint: "http://minio:9000", PathStyle: true, MaxObjectBytes: 1 << 20, MaxObjectAge: "1m"},
+		"azure":      &azure.Config{SharedKey: "a2V5", SharedKeyFile: "/secrets/azure", LogType: "Containers", Domain: "ods.opinsights.azure.us"},
+		"firehose":   &firehose.Config{Region: "eu-west-1", Endpoint: "https://firehose.example.com"},
+		"mongodb":    &mongodb.Config{Database: "logs", Collection: "lines", CappedBytes: 1 << 30, CappedDocuments: 1000, Username: "user", Password: "password", PasswordFile: "/secrets/mongo", AuthSource: "admin"},
+		"postgres":   &postgres.Config{Database: "logs", Table: "app.lines", Columns: map[string]string{"msg": "message"}, CreateTable: true, Username: "user", Password: "password", PasswordFile: "/secrets/postgres"},
+		"zmq":        &zmq.Config{Topic: "logs", HWM: 100, Connect: true},
+		"websocket":  &ws.Config{Headers: map[string]string{"Authorization": "Bearer token"}, Origin: "https://logs.example.com"},
+		"logentries": &logentries.Config{Token: "token"},
+	}
+	var set []router.TargetOptions
+	for _, key := range slices.Sorted(maps.Keys(options)) {
+		target.SetOptions(key, options[key])
+		set = append(set, options[key])
+	}
+	return target, set
+}
+
+// api/openapi.json is written by hand, so this checks a target setting
+// everything matches its Target schema, and that the schema describes
+// nothing targets don't have
+func TestOpenAPITarget(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas map[string]*schema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openapiSpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+	target, options := fullTarget()
+
+	// so fields added to Target or its options are added here too
+	set := make(map[string]bool)
+	zeroFields(reflect.ValueOf(target), set)
+	for _, o := range options {
+		zeroFields(reflect.ValueOf(o), set)
+	}
+	for _, field := range slices.Sorted(maps.Keys(set)) {
+		if !set[field] {
+			t.Errorf("%s isn't set by the test's target", field)
+		}
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(attach.Marshal(target), &value); err != nil {
+		t.Fatal(err)
+	}
+	checker := &schemaChecker{schemas: spec.Components.Schemas, set: make(map[string]map[string]bool)}
+	checker.check("target", "Target", &schema{Ref: "#/components/schemas/Target"}, value)
+	for _, err := range checker.errs {
+		t.Error(err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(checker.set)) {
+		for _, property := range slices.Sorted(maps.Keys(checker.set[name])) {
+			if !checker.set[name][property] {
+				t.Errorf("schema %s has %s, which the target doesn't", name, property)
+			}
+		}
+	}
+
+	// every target type is listed
+	var types []string
+	for _, value := range spec.Components.Schemas["Target"].Properties["type"].Enum {
+		types = append(types, value.(string))
+	}
+	sort.Strings(types)
+	if want := router.AdapterTypes(); !reflect.DeepEqual(types, want) {
+		t.Errorf("got target types %v in the schema, want %v", types, want)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rdebug "runtime/debug"
+	runtimepprof "runtime/pprof"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// registers pprof and runtime debugging endpoints, see DEBUG_ENDPOINTS. They
+// can expose sensitive details and stall the process, so they need the admin
+// scope.
+func (api *API) registerDebugEndpoints(mux *http.ServeMux) {
+	admin := func(handler http.HandlerFunc) http.Handler {
+		return api.auth.Require(ScopeAdmin, handler)
+	}
+	mux.Handle("GET /debug/pprof/", admin(pprof.Index))
+	mux.Handle("GET /debug/pprof/cmdline", admin(pprof.Cmdline))
+	mux.Handle("GET /debug/pprof/profile", admin(pprof.Profile))
+	mux.Handle("/debug/pprof/symbol", admin(pprof.Symbol))
+	mux.Handle("GET /debug/pprof/trace", admin(pprof.Trace))
+	mux.Handle("GET /debug/goroutines", admin(goroutineDump))
+	mux.Handle("GET /debug/heapdump", admin(heapDump))
+	mux.Handle("GET /debug/memstats", admin(memStats))
+}
+
+// writes the stacks of all goroutines as text
+func goroutineDump(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "text/plain")
+	runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// writes a full heap dump, for analysis with tools that read the runtime's
+// heap dump format. Stops the world while the dump is taken.
+func heapDump(w http.ResponseWriter, req *http.Request) {
+	file, err := ioutil.TempFile("", "logspout-heapdump")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	rdebug.WriteHeapDump(file.Fd())
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/octet-stream")
+	w.Header().Add("Content-Disposition", `attachment; filename="logspout.heapdump"`)
+	io.Copy(w, file)
+}
+
+func memStats(w http.ResponseWriter, req *http.Request) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(append(attach.Marshal(struct {
+		Goroutines int               `json:"goroutines"`
+		MemStats   *runtime.MemStats `json:"memstats"`
+	}{runtime.NumGoroutine(), &stats}), '\n'))
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// replayRequest is the body of POST /replay
+type replayRequest struct {
+	// ID of the route whose archive is read
+	Route string `json:"route"`
+	// lines logged from Since up to Until are replayed. Until defaults to
+	// now.
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+}
+
+// replays numbers replays, so the pumps of concurrent ones don't clash
+var replays atomic.Int64
+
+// reads the lines a route archived in a time range and publishes them
+// again, each as the logs of its container, tagged with the replayed
+// fields, so routes whose targets missed them can be backfilled. Answers
+// once every line has been published, with how many were.
+func (api *API) replay(w http.ResponseWriter, req *http.Request) {
+	var body replayRequest
+	if err := attach.Unmarshal(http.MaxBytesReader(w, req.Body, 1<<20), &body); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Until.IsZero() {
+		body.Until = time.Now()
+	}
+	if body.Since.IsZero() || !body.Since.Before(body.Until) {
+		http.Error(w, "Bad request: since is required and must be before until", http.StatusBadRequest)
+		return
+	}
+	route, _ := api.router.Get(body.Route)
+	if route == nil {
+		http.Error(w, "Bad request: no route "+body.Route, http.StatusBadRequest)
+		return
+	}
+	api.Audit.Record(req, api.auth, "replay", nil, route)
+
+	// lines are published by a pump per container, removed once the
+	// replay is done, so routes select them by container as they did
+	// when they were logged
+	replay := replays.Add(1)
+	pumps := make(map[string]*attach.LogPump)
+	defer func() {
+		for id := range pumps {
+			api.attacher.RemovePump(id)
+		}
+	}()
+	lines := 0
+	err := route.Replay(req.Context(), body.Since, body.Until, func(logline *attach.Log) {
+		id := fmt.Sprintf("replay-%d/%s", replay, logline.ID)
+		pump, ok := pumps[id]
+		if !ok {
+			pump = attach.NewInputPump(id, logline.Name, logline.Image, nil)
+			api.attacher.AddPump(pump)
+			pumps[id] = pump
+		}
+		if logline.Fields == nil {
+			logline.Fields = make(map[string]interface{}, 2)
+		}
+		logline.Fields[router.ReplayedField] = true
+		logline.Fields[router.ReplayedFromField] = route.ID
+		logline.Time = time.Now().UTC()
+		pump.Inject(logline)
+		lines++
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Replay failed after %d lines: %s", lines, err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	fmt.Fprintf(w, "{\"lines\":%d}\n", lines)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+type containerReport struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Image     string `json:"image"`
+	Listeners int    `json:"listeners"`
+	Buffered  int    `json:"buffered"`
+}
+
+type statusReport struct {
+	Containers []containerReport    `json:"containers"`
+	Routes     []router.RouteReport `json:"routes"`
+}
+
+func (api *API) status(w http.ResponseWriter, req *http.Request) {
+	report := statusReport{
+		Containers: make([]containerReport, 0),
+		Routes:     make([]router.RouteReport, 0),
+	}
+	for _, pump := range api.attacher.Pumps() {
+		report.Containers = append(report.Containers, containerReport{
+			ID:        pump.ID,
+			Name:      pump.Name,
+			Image:     pump.Image,
+			Listeners: pump.Listeners(),
+			Buffered:  pump.Buffered(),
+		})
+	}
+	routes, _ := api.router.GetAll()
+	for _, route := range routes {
+		shown := route
+		if !api.auth.SeesSecrets(req) {
+			shown = route.Redacted()
+		}
+		report.Routes = append(report.Routes, route.Status().Report(shown))
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(append(attach.Marshal(report), '\n'))
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+type Colorizer map[string]int
+
+// returns up to 14 color escape codes (then repeats) for each unique key
+func (c Colorizer) Get(key string) string {
+	i, exists := c[key]
+	if !exists {
+		c[key] = len(c)
+		i = c[key]
+	}
+	bright := "1;"
+	if i%14 > 6 {
+		bright = ""
+	}
+	return "\x1b[" + bright + "3" + strconv.Itoa(7-(i%7)) + "m"
+}
+
+func httpStreamer(w http.ResponseWriter, req *http.Request, source *attach.Source, formatter *LogFormatter, logstream chan *attach.Log) {
+	w.Header().Add("Content-Type", formatter.ContentType)
+	for logline := range logstream {
+		if !source.MatchLine(logline) {
+			continue
+		}
+		w.Write(append(formatter.Format(logline), '\n'))
+		w.(http.Flusher).Flush()
+	}
+}
+
+func sseStreamer(w http.ResponseWriter, req *http.Request, source *attach.Source, formatter *LogFormatter, logstream chan *attach.Log) {
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	// comment lines keep proxies from timing out quiet streams
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case logline, ok := <-logstream:
+			if !ok {
+				return
+			}
+			if !source.MatchLine(logline) {
+				continue
+			}
+			// every line of a multi-line payload needs its own data field
+			data := strings.Replace(string(formatter.Format(logline)), "\n", "\ndata: ", -1)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", logline.Type, data)
+		case <-heartbeat.C:
+			io.WriteString(w, ":\n\n")
+		}
+		w.(http.Flusher).Flush()
+	}
+}
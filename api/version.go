@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// BuildInfo identifies the running build, for the version endpoint
+type BuildInfo struct {
+	Version   string
+	GitSHA    string
+	BuildDate string
+}
+
+type versionReport struct {
+	Version   string `json:"version"`
+	GitSHA    string `json:"git_sha"`
+	BuildDate string `json:"build_date"`
+	Go        string `json:"go"`
+}
+
+func (api *API) version(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	report := versionReport{api.Build.Version, api.Build.GitSHA, api.Build.BuildDate, runtime.Version()}
+	w.Write(append(attach.Marshal(report), '\n'))
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// control message websocket clients send to change what they're streamed
+type wsControl struct {
+	Action string         `json:"action"`
+	Source *attach.Source `json:"source,omitempty"`
+	Types  []string       `json:"types,omitempty"`
+}
+
+// reply sent for each control message
+type wsReply struct {
+	Action string `json:"action"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// the sources a websocket client is subscribed to, selecting the lines the
+// attach manager sends it
+type wsSubscriptions struct {
+	sync.Mutex
+	sources []*attach.Source
+	types   []string
+	changed chan struct{}
+}
+
+func newWSSubscriptions(source *attach.Source) *wsSubscriptions {
+	return &wsSubscriptions{sources: []*attach.Source{source}, types: source.Types, changed: make(chan struct{}, 1)}
+}
+
+func (s *wsSubscriptions) apply(msg *wsControl) error {
+	s.Lock()
+	defer s.Unlock()
+	switch msg.Action {
+	case "add":
+		if msg.Source == nil {
+			return errors.New("missing source")
+		}
+		if msg.Source.Types == nil {
+			msg.Source.Types = s.types
+		}
+		if err := msg.Source.Validate(); err != nil {
+			return err
+		}
+		s.sources = append(s.sources, msg.Source)
+	case "remove":
+		if msg.Source == nil {
+			return errors.New("missing source")
+		}
+		for i, source := range s.sources {
+			if sameSource(source, msg.Source) {
+				s.sources = append(s.sources[:i], s.sources[i+1:]...)
+				return nil
+			}
+		}
+		return errors.New("no such subscription")
+	case "types":
+		if _, err := attach.ParseStreamTypes(msg.Types); err != nil {
+			return err
+		}
+		s.types = msg.Types
+		for _, source := range s.sources {
+			if err := source.SetTypes(msg.Types); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("unknown action " + msg.Action)
+	}
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *wsSubscriptions) MatchContainer(id, name, image string, labels map[string]string) bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, source := range s.sources {
+		if source.MatchContainer(id, name, image, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *wsSubscriptions) Match(logline *attach.Log, pump *attach.LogPump) bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, source := range s.sources {
+		if source.MatchContainer(logline.ID, logline.Name, logline.Image, pump.Labels) && source.MatchLine(logline) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *wsSubscriptions) Changed() <-chan struct{} {
+	return s.changed
+}
+
+// compares sources by their criteria, ignoring types
+func sameSource(a, b *attach.Source) bool {
+	criteria := func(source *attach.Source) string {
+		var fields map[string]interface{}
+		data, _ := json.Marshal(source)
+		json.Unmarshal(data, &fields)
+		delete(fields, "types")
+		data, _ = json.Marshal(fields)
+		return string(data)
+	}
+	return criteria(a) == criteria(b)
+}
+
+// streams to a websocket client the lines its subscriptions select, which
+// the attach manager filters. Clients may send control messages to add and
+// remove sources or change the log types:
+//
+//	{"action": "add", "source": {"name": "web"}}
+//	{"action": "remove", "source": {"name": "web"}}
+//	{"action": "types", "types": ["stderr"]}
+func websocketStreamer(w http.ResponseWriter, req *http.Request, subs *wsSubscriptions, formatter *LogFormatter, logstream chan *attach.Log, cancel context.CancelFunc) {
+	websocket.Handler(func(conn *websocket.Conn) {
+		var writeLock sync.Mutex
+		go func() {
+			for {
+				var data []byte
+				if err := websocket.Message.Receive(conn, &data); err != nil {
+					if err != io.EOF {
+						logging.Logger("stream").Debug("websocket receive failed", "err", err)
+					}
+					cancel()
+					return
+				}
+				msg := new(wsControl)
+				err := json.Unmarshal(data, msg)
+				if err == nil {
+					err = subs.apply(msg)
+				}
+				reply := wsReply{Action: msg.Action, OK: err == nil}
+				if err != nil {
+					reply.Error = err.Error()
+				}
+				writeLock.Lock()
+				err = websocket.JSON.Send(conn, reply)
+				writeLock.Unlock()
+				if err != nil {
+					cancel()
+					return
+				}
+			}
+		}()
+		for logline := range logstream {
+			writeLock.Lock()
+			_, err := conn.Write(append(formatter.Format(logline), '\n'))
+			writeLock.Unlock()
+			if err != nil {
+				cancel()
+				return
+			}
+		}
+	}).ServeHTTP(w, req)
+}
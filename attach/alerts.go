@@ -0,0 +1,155 @@
+package attach
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// AlertRule posts lines matching a pattern to a webhook, at most once per
+// interval
+type AlertRule struct {
+	ExtractRule
+	Name    string `json:"name"`
+	Webhook string `json:"webhook"`
+	// json, or slack for a Slack incoming webhook
+	Format string `json:"format,omitempty"`
+	// shortest time between alerts, as a duration. Defaults to 1m.
+	Interval string `json:"interval,omitempty"`
+
+	sync.Mutex
+	interval   time.Duration
+	last       time.Time
+	suppressed int
+}
+
+// rules applied to every line, from the file named by ALERT_RULES
+var AlertRules []*AlertRule
+
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// reads a JSON list of rules from path
+func LoadAlertRules(path string) ([]*AlertRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rules []*AlertRule
+	if err := Unmarshal(file, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, rule := range rules {
+		if err := rule.init(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", path, rule.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *AlertRule) init() error {
+	if err := r.Compile(); err != nil {
+		return err
+	}
+	if r.Webhook == "" {
+		return fmt.Errorf("webhook is required")
+	}
+	if r.Format != "" && r.Format != "json" && r.Format != "slack" {
+		return fmt.Errorf("unknown format %q", r.Format)
+	}
+	r.interval = time.Minute
+	if r.Interval != "" {
+		interval, err := time.ParseDuration(r.Interval)
+		if err != nil {
+			return fmt.Errorf("interval: %s", err)
+		}
+		r.interval = interval
+	}
+	return nil
+}
+
+// alertPayload is what json format webhooks are sent
+type alertPayload struct {
+	Alert      string            `json:"alert"`
+	Time       time.Time         `json:"time"`
+	Container  alertContainer    `json:"container"`
+	Line       string            `json:"line"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	Suppressed int               `json:"suppressed"`
+}
+
+type alertContainer struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	Type  string `json:"type"`
+}
+
+// sends an alert if the line matches and the rule hasn't alerted within its
+// interval. Matches within the interval are counted and reported with the
+// next alert.
+func (r *AlertRule) check(logline *Log) {
+	fields := r.match(logline)
+	if fields == nil {
+		return
+	}
+	r.Lock()
+	if time.Since(r.last) < r.interval {
+		r.suppressed++
+		r.Unlock()
+		return
+	}
+	r.last = time.Now()
+	suppressed := r.suppressed
+	r.suppressed = 0
+	r.Unlock()
+
+	payload := alertPayload{
+		Alert:      r.Name,
+		Time:       logline.Timestamp,
+		Container:  alertContainer{ID: logline.ID, Name: logline.Name, Image: logline.Image, Type: logline.Type},
+		Line:       logline.Data,
+		Suppressed: suppressed,
+	}
+	for name, value := range fields {
+		if payload.Fields == nil {
+			payload.Fields = make(map[string]string)
+		}
+		payload.Fields[name] = fmt.Sprint(value)
+	}
+	go r.send(payload)
+}
+
+func (r *AlertRule) send(payload alertPayload) {
+	var body []byte
+	if r.Format == "slack" {
+		text := fmt.Sprintf("*%s* in `%s` (%s):\n```%s```", payload.Alert, payload.Container.Name, payload.Container.Image, payload.Line)
+		if payload.Suppressed > 0 {
+			text += fmt.Sprintf("\n%d more since the last alert", payload.Suppressed)
+		}
+		body, _ = json.Marshal(map[string]string{"text": text})
+	} else {
+		body, _ = json.Marshal(payload)
+	}
+	resp, err := alertClient.Post(r.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logging.Logger("alert").Error("sending alert failed", "rule", r.Name, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logging.Logger("alert").Warn("webhook rejected alert", "rule", r.Name, "status", resp.Status)
+	}
+}
+
+func checkAlertRules(logline *Log) {
+	for _, rule := range AlertRules {
+		rule.check(logline)
+	}
+}
@@ -0,0 +1,476 @@
+// Package attach attaches to Docker containers and pumps their log lines
+// to listeners.
+package attach
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+type AttachManager struct {
+	sync.Mutex
+	attached map[string]*LogPump
+	channels map[chan *AttachEvent]struct{}
+	client   *docker.Client
+	// the pump Docker's events are published on, if they are
+	events atomic.Pointer[LogPump]
+}
+
+// attaches to the running containers and to those started from now on
+func NewAttachManager(client *docker.Client) (*AttachManager, error) {
+	m := &AttachManager{
+		attached: make(map[string]*LogPump),
+		channels: make(map[chan *AttachEvent]struct{}),
+		client:   client,
+	}
+	events, err := m.listen()
+	if err != nil {
+		return nil, err
+	}
+	if StallTimeout > 0 {
+		go m.watchStalls()
+	}
+	go func() {
+		for {
+			for msg := range events {
+				m.publishEvent(msg)
+				if len(msg.ID) < 12 {
+					// Podman sends events for pods and images too
+					continue
+				}
+				logging.Logger("attacher").Debug("docker event", "container", msg.ID[:12], "status", msg.Status)
+				if msg.Status == "start" || msg.Status == "restart" {
+					go m.attach(msg.ID[:12])
+				}
+			}
+			// Podman's API service exits when it's been idle a while, and is
+			// started again by its socket when next connected to
+			logging.Logger("attacher").Warn("docker event stream ended, reconnecting")
+			for {
+				time.Sleep(eventsRetryDelay)
+				if events, err = m.listen(); err == nil {
+					break
+				}
+				logging.Logger("attacher").Error("reconnecting to docker failed", "err", err)
+			}
+		}
+	}()
+	return m, nil
+}
+
+// how long to wait between attempts to reconnect to the event stream
+const eventsRetryDelay = time.Second
+
+// starts listening for events and attaches to the running containers it
+// isn't already, which includes any started while it wasn't listening
+func (m *AttachManager) listen() (chan *docker.APIEvents, error) {
+	events := make(chan *docker.APIEvents)
+	if err := m.client.AddEventListener(events); err != nil {
+		return nil, err
+	}
+	containers, err := m.client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		m.client.RemoveEventListener(events)
+		return nil, err
+	}
+	for _, listing := range containers {
+		if m.Get(listing.ID[:12]) == nil {
+			m.attach(listing.ID[:12])
+		}
+	}
+	return events, nil
+}
+
+// returns a manager for the pumps of inputs only, for running without
+// Docker. Ping fails, as there's no daemon to reach.
+func NewInputManager() *AttachManager {
+	return &AttachManager{
+		attached: make(map[string]*LogPump),
+		channels: make(map[chan *AttachEvent]struct{}),
+	}
+}
+
+func (m *AttachManager) attach(id string) {
+	container, err := m.client.InspectContainer(id)
+	if err != nil {
+		logging.Logger("attacher").Error("inspect failed", "container", id, "err", err)
+		return
+	}
+	name := strings.TrimPrefix(container.Name, "/")
+	image := m.imageName(container)
+	success := make(chan struct{})
+	failure := make(chan error)
+	outrd, outwr := io.Pipe()
+	errrd, errwr := io.Pipe()
+	// set before the attach completes, for its cleanup
+	var pump *LogPump
+	go func() {
+		err := m.client.AttachToContainer(docker.AttachToContainerOptions{
+			Container:    id,
+			OutputStream: outwr,
+			ErrorStream:  errwr,
+			Stdin:        false,
+			Stdout:       true,
+			Stderr:       true,
+			Stream:       true,
+			RawTerminal:  container.Config.Tty,
+			Success:      success,
+		})
+		outwr.Close()
+		errwr.Close()
+		logging.Logger("attacher").Debug("attach finished", "container", id)
+		if err != nil {
+			close(success)
+			failure <- err
+		}
+		// a stalled attachment ends after it's been replaced, see reattach
+		m.Lock()
+		current := m.attached[id] == pump
+		if current {
+			delete(m.attached, id)
+		}
+		m.Unlock()
+		if current {
+			m.send(&AttachEvent{Type: "detach", ID: id, Name: name})
+		}
+	}()
+	_, ok := <-success
+	if ok {
+		pump = NewLogPump(outrd, errrd, id, name, image, container.Config.Labels)
+		pump.stop = func() {
+			outrd.CloseWithError(errStalled)
+			errrd.CloseWithError(errStalled)
+		}
+		m.Lock()
+		m.attached[id] = pump
+		m.Unlock()
+		success <- struct{}{}
+		containerAttaches.Inc()
+		m.send(&AttachEvent{ID: id, Name: name, Type: "attach"})
+		logging.Logger("attacher").Debug("attached", "container", id)
+		return
+	}
+	logging.Logger("attacher").Debug("attach failed", "container", id, "err", <-failure)
+}
+
+// returns the tag of the container's image, or the image it was created
+// from if that isn't tagged. Podman lists image IDs with a sha256: prefix
+// its containers' image IDs may lack.
+func (m *AttachManager) imageName(container *docker.Container) string {
+	allImages, _ := m.client.ListImages(docker.ListImagesOptions{})
+	for _, img := range allImages {
+		if strings.TrimPrefix(img.ID, "sha256:") == strings.TrimPrefix(container.Image, "sha256:") && len(img.RepoTags) > 0 {
+			return img.RepoTags[0]
+		}
+	}
+	return container.Config.Image
+}
+
+func (m *AttachManager) send(event *AttachEvent) {
+	m.Lock()
+	defer m.Unlock()
+	for ch, _ := range m.channels {
+		if event.handled != nil {
+			event.handled.Add(1)
+		}
+		// TODO: log err after timeout and continue
+		ch <- event
+	}
+}
+
+// marks the event handled by a listener
+func (e *AttachEvent) done() {
+	if e.handled != nil {
+		e.handled.Done()
+	}
+}
+
+func (m *AttachManager) addListener(ch chan *AttachEvent) {
+	m.Lock()
+	defer m.Unlock()
+	m.channels[ch] = struct{}{}
+	go func() {
+		for id, pump := range m.attached {
+			ch <- &AttachEvent{ID: id, Name: pump.Name, Type: "attach"}
+		}
+	}()
+}
+
+func (m *AttachManager) removeListener(ch chan *AttachEvent) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.channels, ch)
+}
+
+// registers a pump that isn't backed by a container, like the audit stream,
+// so it can be listened to like any other. Returns once every listener
+// that selects it has been added to it.
+func (m *AttachManager) AddPump(pump *LogPump) {
+	m.Lock()
+	m.attached[pump.ID] = pump
+	m.Unlock()
+	// inputs can publish lines as soon as this returns, so listeners that
+	// want them must have been added by then
+	event := &AttachEvent{ID: pump.ID, Name: pump.Name, Type: "attach", handled: new(sync.WaitGroup)}
+	m.send(event)
+	event.handled.Wait()
+}
+
+// unregisters a pump added with AddPump, once its input has no more lines
+// for it
+func (m *AttachManager) RemovePump(id string) {
+	m.Lock()
+	pump, ok := m.attached[id]
+	delete(m.attached, id)
+	m.Unlock()
+	if ok {
+		m.send(&AttachEvent{ID: id, Name: pump.Name, Type: "detach"})
+	}
+}
+
+// checks that the Docker daemon is reachable
+func (m *AttachManager) Ping() error {
+	if m.client == nil {
+		return errors.New("not attached to Docker")
+	}
+	return m.client.Ping()
+}
+
+// returns the currently attached pumps
+func (m *AttachManager) Pumps() []*LogPump {
+	m.Lock()
+	defer m.Unlock()
+	pumps := make([]*LogPump, 0, len(m.attached))
+	for _, pump := range m.attached {
+		pumps = append(pumps, pump)
+	}
+	return pumps
+}
+
+func (m *AttachManager) Get(id string) *LogPump {
+	m.Lock()
+	defer m.Unlock()
+	return m.attached[id]
+}
+
+// sends the logs of containers matching source to logstream until ctx is
+// done, or source.Until is
+func (m *AttachManager) Listen(ctx context.Context, source *Source, logstream chan *Log) {
+	if source == nil {
+		source = new(Source)
+	}
+	m.ListenSelector(ctx, source, sourceSelector{source}, logstream)
+}
+
+type LogPump struct {
+	sync.Mutex
+	ID       string
+	Name     string
+	Image    string
+	Labels   map[string]string
+	channels []pumpListener
+	buffer   []*Log
+	next     int
+	// runs a read line through the pump's processing and sends it on
+	prepare func(*Log)
+	// runs a line through the pump's processing, returning its parts
+	clean func(*Log) []*Log
+	// when a line was last read from the container, as Unix nanoseconds
+	read atomic.Int64
+	// closes the container's streams, nil for input pumps
+	stop func()
+}
+
+// number of recent lines kept per container for tailing, see BUFFER_LINES
+var BufferLines = 100
+
+func NewLogPump(stdout, stderr io.Reader, id, name string, image string, labels map[string]string) *LogPump {
+	obj := &LogPump{
+		ID:     id,
+		Name:   name,
+		Image:  image,
+		Labels: labels,
+		buffer: make([]*Log, 0, BufferLines),
+	}
+	obj.read.Store(time.Now().UnixNano())
+	profile := profileFor(name, image, labels)
+	config := multilineFor(name, labels, profile)
+	layout := timestampLayoutFor(labels, profile)
+	// redacted before lines are cut, so no part of a secret survives
+	clean := func(logline *Log) []*Log {
+		logline.Data = sanitizeUTF8(logline.Data)
+		if StripANSI {
+			logline.Data = stripControl(logline.Data)
+		}
+		logline.Data = redact(logline.Data)
+		parts := limitLength(logline)
+		for _, part := range parts {
+			// inputs can know a line's time and severity already
+			if part.Timestamp.Equal(part.Time) {
+				if t, ok := ParseTimestamp(part.Data, layout); ok {
+					part.Timestamp = t.UTC()
+				}
+			}
+			if part.Severity == "" {
+				part.Severity = severityFor(profile, part.Data)
+			}
+			part.Profile = profile
+		}
+		return parts
+	}
+	prepare := func(logline *Log) {
+		for _, part := range clean(logline) {
+			observeMetricRules(part)
+			checkAlertRules(part)
+			obj.send(part)
+		}
+	}
+	obj.prepare, obj.clean = prepare, clean
+	pump := func(typ string, source io.Reader) {
+		emit := prepare
+		if RepeatWindow > 0 {
+			collapser := newRepeatCollapser(RepeatWindow, prepare)
+			defer collapser.Flush()
+			emit = collapser.Add
+		}
+		if config != nil {
+			assembler := newMultilineAssembler(config, emit)
+			defer assembler.Flush()
+			emit = assembler.Add
+		}
+		buf := bufio.NewReader(source)
+		for {
+			data, err := buf.ReadBytes('\n')
+			if err != nil {
+				if err != io.EOF {
+					logging.Logger("pump").Debug("read failed", "container", id, "stream", typ, "err", err)
+				}
+				return
+			}
+			now := time.Now().UTC()
+			obj.read.Store(now.UnixNano())
+			emit(&Log{
+				Data:      strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"),
+				ID:        id,
+				Name:      name,
+				Image:     image,
+				Type:      typ,
+				Time:      now,
+				Timestamp: now,
+			})
+		}
+	}
+	go pump(StreamStdout, stdout)
+	go pump(StreamStderr, stderr)
+	return obj
+}
+
+// returns a pump for the lines of an input rather than a container, see
+// Inject. Profiles are chosen by its image and labels, as for containers.
+func NewInputPump(id, name, image string, labels map[string]string) *LogPump {
+	return NewLogPump(strings.NewReader(""), strings.NewReader(""), id, name, image, labels)
+}
+
+// runs a line from an input through the pump's processing and sends it to
+// the pump's listeners. Lines with a Timestamp other than their Time or a
+// Severity keep them.
+func (o *LogPump) Inject(logline *Log) {
+	o.prepare(logline)
+}
+
+func (o *LogPump) send(log *Log) {
+	o.Lock()
+	defer o.Unlock()
+	if cap(o.buffer) > 0 {
+		if len(o.buffer) < cap(o.buffer) {
+			o.buffer = append(o.buffer, log)
+		} else {
+			o.buffer[o.next] = log
+			o.next = (o.next + 1) % len(o.buffer)
+		}
+	}
+	// the same line goes to every listener, which must copy it to change it
+	for _, listener := range o.channels {
+		if listener.match(log) {
+			// TODO: log err after timeout and continue
+			listener.ch <- log
+		}
+	}
+}
+
+// pumpListener is a channel lines are sent to, with the source it was added
+// for so lines it would skip aren't sent
+type pumpListener struct {
+	ch    chan *Log
+	match func(*Log) bool
+}
+
+// adds a listener, first sending it up to source.Tail of the most recently
+// buffered lines, or those since source.Since, so it picks up exactly where
+// the history leaves off
+func (o *LogPump) AddListener(ch chan *Log, source *Source) {
+	o.addListener(ch, source, time.Time{}, source.matchPumped)
+}
+
+// adds a listener sent the lines match selects, replaying only buffered
+// lines read at or after from
+func (o *LogPump) addListener(ch chan *Log, source *Source, from time.Time, match func(*Log) bool) {
+	o.Lock()
+	defer o.Unlock()
+	for _, log := range o.replay(source, from) {
+		if match(log) {
+			ch <- log
+		}
+	}
+	o.channels = append(o.channels, pumpListener{ch: ch, match: match})
+}
+
+// returns when a line was last read from the pump's container
+func (o *LogPump) lastRead() time.Time {
+	return time.Unix(0, o.read.Load())
+}
+
+// returns the number of listeners lines are sent to
+func (o *LogPump) Listeners() int {
+	o.Lock()
+	defer o.Unlock()
+	return len(o.channels)
+}
+
+// returns the number of lines in the tail buffer
+func (o *LogPump) Buffered() int {
+	o.Lock()
+	defer o.Unlock()
+	return len(o.buffer)
+}
+
+// returns up to n buffered lines, oldest first. Must be called with the lock held.
+func (o *LogPump) recent(n int) []*Log {
+	if n <= 0 {
+		return nil
+	}
+	lines := append(append([]*Log{}, o.buffer[o.next:]...), o.buffer[:o.next]...)
+	if n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+func (o *LogPump) RemoveListener(ch chan *Log) {
+	o.Lock()
+	defer o.Unlock()
+	o.channels = slices.DeleteFunc(o.channels, func(listener pumpListener) bool {
+		return listener.ch == ch
+	})
+}
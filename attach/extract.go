@@ -0,0 +1,128 @@
+package attach
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ExtractRule pulls fields out of plain-text lines with a regex of named
+// groups, or grok-style %{PATTERN:field} references
+type ExtractRule struct {
+	Pattern string `json:"pattern"`
+	// only apply to images matching this regex
+	Image string `json:"image,omitempty"`
+
+	re      *regexp.Regexp
+	imageRE *regexp.Regexp
+	types   map[string]string
+}
+
+// patterns usable as %{NAME} or %{NAME:field} in extraction rules
+var grokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"INT":               `[+-]?\d+`,
+	"NUMBER":            `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"BASE16NUM":         `(?:0[xX])?[0-9A-Fa-f]+`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"IPV4":              `(?:\d{1,3}\.){3}\d{1,3}`,
+	"IPV6":              `[0-9A-Fa-f:]*:[0-9A-Fa-f:.]+`,
+	"IP":                `(?:[0-9A-Fa-f:]*:[0-9A-Fa-f:.]+|(?:\d{1,3}\.){3}\d{1,3})`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"URIPATH":           `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":          `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+(?:\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*)?`,
+	"HTTPMETHOD":        `\b(?:GET|HEAD|POST|PUT|DELETE|CONNECT|OPTIONS|TRACE|PATCH)\b`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|emerg(?:ency)?|alert)`,
+	"HTTPDATE":          `\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`,
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?`,
+	"DURATION":          `[+-]?(?:\d+(?:\.\d*)?(?:ns|us|µs|ms|s|m|h))+`,
+}
+
+var grokRef = regexp.MustCompile(`%\{(\w+)(?::(\w+))?(?::(int|float))?\}`)
+
+// turns grok references into regex groups, returning the regex and the
+// type conversions requested for fields
+func expandGrok(pattern string) (string, map[string]string, error) {
+	types := make(map[string]string)
+	var err error
+	expanded := grokRef.ReplaceAllStringFunc(pattern, func(ref string) string {
+		parts := grokRef.FindStringSubmatch(ref)
+		sub, ok := grokPatterns[parts[1]]
+		if !ok {
+			err = fmt.Errorf("unknown grok pattern %q", parts[1])
+			return ref
+		}
+		if parts[2] == "" {
+			return "(?:" + sub + ")"
+		}
+		if parts[3] != "" {
+			types[parts[2]] = parts[3]
+		}
+		return "(?P<" + parts[2] + ">" + sub + ")"
+	})
+	return expanded, types, err
+}
+
+func (r *ExtractRule) Compile() error {
+	expanded, types, err := expandGrok(r.Pattern)
+	if err != nil {
+		return err
+	}
+	if r.re, err = regexp.Compile(expanded); err != nil {
+		return fmt.Errorf("extract pattern: %s", err)
+	}
+	if r.Image != "" {
+		if r.imageRE, err = regexp.Compile(r.Image); err != nil {
+			return fmt.Errorf("extract image: %s", err)
+		}
+	}
+	r.types = types
+	return nil
+}
+
+// merges the named groups of the rule's pattern if the line matches
+func (r *ExtractRule) Apply(logline *Log) {
+	if fields := r.match(logline); fields != nil {
+		MergeFields(logline, fields)
+	}
+}
+
+// returns the named groups of the rule's pattern, or nil if the line
+// doesn't match
+func (r *ExtractRule) match(logline *Log) map[string]interface{} {
+	if r.re == nil || (r.imageRE != nil && !r.imageRE.MatchString(logline.Image)) {
+		return nil
+	}
+	match := r.re.FindStringSubmatch(logline.Message())
+	if match == nil {
+		return nil
+	}
+	fields := make(map[string]interface{})
+	for i, name := range r.re.SubexpNames() {
+		if name == "" || i >= len(match) {
+			continue
+		}
+		value := strings.TrimSpace(match[i])
+		switch r.types[name] {
+		case "int":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				fields[name] = n
+				continue
+			}
+		case "float":
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				fields[name] = f
+				continue
+			}
+		}
+		fields[name] = value
+	}
+	return fields
+}
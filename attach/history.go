@@ -0,0 +1,131 @@
+package attach
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// reports whether a line's time is in the source's window
+func (s *Source) inWindow(logline *Log) bool {
+	return (s.Since.IsZero() || !logline.Timestamp.Before(s.Since)) &&
+		(s.Until.IsZero() || logline.Timestamp.Before(s.Until))
+}
+
+// returns the buffered lines a listener with source is sent first, read
+// at or after from: those since source.Since, or the last source.Tail. Must
+// be called with the lock held.
+func (o *LogPump) replay(source *Source, from time.Time) []*Log {
+	n := source.Tail
+	if n == 0 && !source.Since.IsZero() {
+		n = len(o.buffer)
+	}
+	var lines []*Log
+	for _, logline := range o.recent(n) {
+		if !logline.Time.Before(from) && source.matchPumped(logline) {
+			lines = append(lines, logline)
+		}
+	}
+	return lines
+}
+
+// returns the lines of a container from source.Since that the pump's
+// buffer doesn't reach back to, read back from Docker, and the time lines
+// must be read at or after to be replayed from the buffer after them.
+// Lines are processed as the pump's are, but not counted by metric or
+// alert rules, since they were when they were first read.
+func (m *AttachManager) history(pump *LogPump, source *Source) ([]*Log, time.Time) {
+	pump.Lock()
+	covered := pump.stop == nil || m.client == nil || source.Since.IsZero() || source.Tail > 0 ||
+		(len(pump.buffer) > 0 && len(pump.buffer) == cap(pump.buffer) && !pump.buffer[pump.next].Time.After(source.Since))
+	pump.Unlock()
+	if covered {
+		return nil, time.Time{}
+	}
+	container, err := m.client.InspectContainer(pump.ID)
+	if err != nil {
+		logging.Logger("attacher").Debug("reading history failed", "container", pump.ID, "err", err)
+		return nil, time.Time{}
+	}
+	now := time.Now().UTC()
+	var stdout, stderr bytes.Buffer
+	err = m.client.Logs(docker.LogsOptions{
+		Container:    pump.ID,
+		OutputStream: &stdout,
+		ErrorStream:  &stderr,
+		Stdout:       true,
+		Stderr:       true,
+		Timestamps:   true,
+		Since:        source.Since.Unix(),
+		RawTerminal:  container.Config.Tty,
+	})
+	if err != nil {
+		// log drivers that can't be read back only have the buffer
+		logging.Logger("attacher").Debug("reading history failed", "container", pump.ID, "err", err)
+		return nil, time.Time{}
+	}
+	var lines []*Log
+	for typ, output := range map[string]*bytes.Buffer{StreamStdout: &stdout, StreamStderr: &stderr} {
+		scanner := bufio.NewScanner(output)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			// each line starts with the time Docker read it
+			stamp, data, _ := strings.Cut(scanner.Text(), " ")
+			t, err := time.Parse(time.RFC3339Nano, stamp)
+			if err != nil || !t.Before(now) {
+				continue
+			}
+			logline := &Log{
+				Data:      strings.TrimSuffix(data, "\r"),
+				ID:        pump.ID,
+				Name:      pump.Name,
+				Image:     pump.Image,
+				Type:      typ,
+				Time:      t.UTC(),
+				Timestamp: t.UTC(),
+			}
+			for _, part := range pump.clean(logline) {
+				if source.matchPumped(part) {
+					lines = append(lines, part)
+				}
+			}
+		}
+	}
+	sortByTime(lines)
+	return lines, now
+}
+
+// sends the lines the selector selects from the window, oldest first, for
+// a window that's already over
+func (m *AttachManager) sendHistory(window *Source, selector Selector, logstream chan *Log) {
+	var lines []*Log
+	for _, pump := range m.Pumps() {
+		if !selector.MatchContainer(pump.ID, pump.Name, pump.Image, pump.Labels) {
+			continue
+		}
+		history, from := m.history(pump, window)
+		pump.Lock()
+		for _, logline := range append(history, pump.replay(window, from)...) {
+			if selector.Match(logline, pump) {
+				lines = append(lines, logline)
+			}
+		}
+		pump.Unlock()
+	}
+	sortByTime(lines)
+	for _, logline := range lines {
+		logstream <- logline
+	}
+}
+
+func sortByTime(lines []*Log) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Timestamp.Before(lines[j].Timestamp)
+	})
+}
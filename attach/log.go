@@ -0,0 +1,103 @@
+package attach
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+type AttachEvent struct {
+	Type string
+	ID   string
+	Name string
+	// done by each listener once it's handled the event, if set
+	handled *sync.WaitGroup
+}
+
+type Log struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	Type  string `json:"type"`
+	Data  string `json:"data"`
+	// when logspout read the line
+	Time time.Time `json:"time"`
+	// when the line was logged, if it says, otherwise the same as Time
+	Timestamp time.Time `json:"timestamp"`
+	// normalized syslog severity name, if the line states one
+	Severity string `json:"severity,omitempty"`
+	// Data was cut to MAX_LINE_BYTES
+	Truncated bool `json:"truncated,omitempty"`
+
+	// structured fields parsed from Data by a route's parsers
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// Data is a JSON object a parser merged into Fields whole, so it's no
+	// message of its own
+	Document bool `json:"-"`
+	// profile of the line's container, if it has one
+	Profile *Profile `json:"-"`
+}
+
+// returns the parsed message field if there is one, otherwise the raw data
+func (l *Log) Message() string {
+	if message, ok := l.Fields["message"].(string); ok {
+		return message
+	}
+	return l.Data
+}
+
+type K8sContainer struct {
+	Name      string `json:"name"`
+	Pod       string `json:"pod"`
+	Namespace string `json:"namespace"`
+}
+
+var k8sContainerRE = regexp.MustCompile(`^(?:[^_]+)_([^\.]+)\.(?:[^_]+)_([^\.]+)\.([^\.]+)`)
+
+// parses the kubelet's container naming scheme, returning nil for containers
+// not started by kubernetes
+func NewK8sContainer(name string) *K8sContainer {
+	match := k8sContainerRE.FindStringSubmatch(name)
+	if len(match) == 0 {
+		return nil
+	}
+	return &K8sContainer{Name: match[1], Pod: match[2], Namespace: match[3]}
+}
+
+// parsed Kubernetes names by container name, so they're parsed once per
+// container rather than once per line. It's emptied once it fills up, as
+// names come and go with containers.
+var k8sContainers = struct {
+	sync.RWMutex
+	names map[string]*K8sContainer
+}{names: make(map[string]*K8sContainer)}
+
+const maxK8sContainers = 4096
+
+func CachedK8sContainer(name string) *K8sContainer {
+	k8sContainers.RLock()
+	container, ok := k8sContainers.names[name]
+	k8sContainers.RUnlock()
+	if ok {
+		return container
+	}
+	container = NewK8sContainer(name)
+	k8sContainers.Lock()
+	if len(k8sContainers.names) >= maxK8sContainers {
+		clear(k8sContainers.names)
+	}
+	k8sContainers.names[name] = container
+	k8sContainers.Unlock()
+	return container
+}
+
+// adds fields to the line's parsed fields, replacing any with the same
+// names
+func MergeFields(logline *Log, fields map[string]interface{}) {
+	if logline.Fields == nil {
+		logline.Fields = make(map[string]interface{}, len(fields))
+	}
+	for key, value := range fields {
+		logline.Fields[key] = value
+	}
+}
@@ -0,0 +1,123 @@
+package attach
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricRule counts or measures lines matching a pattern, as a Prometheus
+// counter or histogram served on /metrics
+type MetricRule struct {
+	ExtractRule
+	Name string `json:"name"`
+	Help string `json:"help,omitempty"`
+	// counter or histogram
+	Type string `json:"type"`
+	// pattern groups used as metric labels
+	Labels []string `json:"labels,omitempty"`
+	// pattern group histograms observe, scaled by Scale if it's set
+	Value   string    `json:"value,omitempty"`
+	Scale   float64   `json:"scale,omitempty"`
+	Buckets []float64 `json:"buckets,omitempty"`
+
+	counter   *prometheus.CounterVec
+	histogram *prometheus.HistogramVec
+}
+
+// rules applied to every line, from the file named by METRIC_RULES
+var MetricRules []*MetricRule
+
+// reads a JSON list of rules from path and registers their metrics
+func LoadMetricRules(path string) ([]*MetricRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rules []*MetricRule
+	if err := Unmarshal(file, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, rule := range rules {
+		if err := rule.register(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", path, rule.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *MetricRule) register() error {
+	if err := r.Compile(); err != nil {
+		return err
+	}
+	help := r.Help
+	if help == "" {
+		help = "Log lines matching " + strconv.Quote(r.Pattern) + "."
+	}
+	var collector prometheus.Collector
+	switch r.Type {
+	case "counter":
+		r.counter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: r.Name, Help: help}, r.Labels)
+		collector = r.counter
+	case "histogram":
+		if r.Value == "" {
+			return fmt.Errorf("histograms need a value")
+		}
+		buckets := r.Buckets
+		if buckets == nil {
+			buckets = prometheus.DefBuckets
+		}
+		r.histogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: r.Name, Help: help, Buckets: buckets}, r.Labels)
+		collector = r.histogram
+	default:
+		return fmt.Errorf("unknown metric type %q", r.Type)
+	}
+	return prometheus.Register(collector)
+}
+
+// updates the rule's metric if the line matches
+func (r *MetricRule) observe(logline *Log) {
+	fields := r.match(logline)
+	if fields == nil {
+		return
+	}
+	labels := make([]string, len(r.Labels))
+	for i, label := range r.Labels {
+		if label == "container" && fields[label] == nil {
+			labels[i] = logline.Name
+			continue
+		}
+		labels[i] = fmt.Sprint(fields[label])
+	}
+	if r.counter != nil {
+		r.counter.WithLabelValues(labels...).Inc()
+		return
+	}
+	var value float64
+	switch v := fields[r.Value].(type) {
+	case float64:
+		value = v
+	case int64:
+		value = float64(v)
+	case string:
+		var err error
+		if value, err = strconv.ParseFloat(v, 64); err != nil {
+			return
+		}
+	default:
+		return
+	}
+	if r.Scale != 0 {
+		value *= r.Scale
+	}
+	r.histogram.WithLabelValues(labels...).Observe(value)
+}
+
+func observeMetricRules(logline *Log) {
+	for _, rule := range MetricRules {
+		rule.observe(logline)
+	}
+}
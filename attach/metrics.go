@@ -0,0 +1,49 @@
+package attach
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	containerAttaches = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "container_attaches_total",
+		Help:      "Times logspout attached to a container's output.",
+	})
+	stalledStreams = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "stalled_streams_total",
+		Help:      "Times a container's stream stopped delivering lines the container was writing, and was reattached.",
+	})
+	redactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "redactions_total",
+		Help:      "Matches masked by a redaction rule.",
+	}, []string{"rule"})
+)
+
+func init() {
+	prometheus.MustRegister(containerAttaches, stalledStreams, redactions)
+}
+
+// registers gauges reading the attacher's current state
+func RegisterMetrics(m *AttachManager) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "logspout",
+		Name:      "attached_containers",
+		Help:      "Containers currently attached.",
+	}, func() float64 {
+		return float64(len(m.Pumps()))
+	}))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "logspout",
+		Name:      "buffered_lines",
+		Help:      "Lines held in the per-container tail buffers.",
+	}, func() float64 {
+		lines := 0
+		for _, pump := range m.Pumps() {
+			lines += pump.Buffered()
+		}
+		return float64(lines)
+	}))
+}
@@ -0,0 +1,139 @@
+package attach
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// MultilineConfig says how lines are merged into events, e.g. so a stack
+// trace is shipped as one event rather than a line per frame
+type MultilineConfig struct {
+	// a line matching FirstLine starts a new event
+	FirstLine *regexp.Regexp
+	// a line matching Continuation is appended to the current event
+	Continuation *regexp.Regexp
+	// pending events are sent once no line has arrived for Timeout
+	Timeout time.Duration
+	// events are sent once they'd grow past MaxBytes
+	MaxBytes int
+}
+
+// default multiline config from MULTILINE_FIRSTLINE, MULTILINE_CONTINUATION,
+// MULTILINE_TIMEOUT and MULTILINE_MAX_BYTES, nil if merging is off
+var Multiline *MultilineConfig
+
+// returns nil if neither pattern is set
+func NewMultilineConfig(firstline, continuation, timeout, maxBytes string) (*MultilineConfig, error) {
+	if firstline == "" && continuation == "" {
+		return nil, nil
+	}
+	c := new(MultilineConfig)
+	var err error
+	if firstline != "" {
+		if c.FirstLine, err = regexp.Compile(firstline); err != nil {
+			return nil, errors.New("firstline: " + err.Error())
+		}
+	}
+	if continuation != "" {
+		if c.Continuation, err = regexp.Compile(continuation); err != nil {
+			return nil, errors.New("continuation: " + err.Error())
+		}
+	}
+	if c.Timeout, err = time.ParseDuration(timeout); err != nil {
+		return nil, errors.New("timeout: " + err.Error())
+	}
+	if c.MaxBytes, err = strconv.Atoi(maxBytes); err != nil {
+		return nil, errors.New("max bytes: " + err.Error())
+	}
+	return c, nil
+}
+
+// returns the multiline config for a container, its profile's if it has
+// one, which its logspout.multiline.* labels can override
+func multilineFor(name string, labels map[string]string, profile *Profile) *MultilineConfig {
+	base := Multiline
+	if profile != nil && profile.multiline != nil {
+		base = profile.multiline
+	}
+	firstline, continuation := labels["logspout.multiline.firstline"], labels["logspout.multiline.continuation"]
+	if firstline == "" && continuation == "" {
+		return base
+	}
+	timeout, maxBytes := "1s", "65536"
+	if base != nil {
+		timeout, maxBytes = base.Timeout.String(), strconv.Itoa(base.MaxBytes)
+	}
+	if label := labels["logspout.multiline.timeout"]; label != "" {
+		timeout = label
+	}
+	if label := labels["logspout.multiline.max_bytes"]; label != "" {
+		maxBytes = label
+	}
+	config, err := NewMultilineConfig(firstline, continuation, timeout, maxBytes)
+	if err != nil {
+		logging.Logger("multiline").Warn("ignoring multiline labels", "container", name, "err", err)
+		return base
+	}
+	return config
+}
+
+// multilineAssembler merges the lines of one container stream into events
+type multilineAssembler struct {
+	sync.Mutex
+	config  *MultilineConfig
+	emit    func(*Log)
+	pending *Log
+	timer   *time.Timer
+}
+
+func newMultilineAssembler(config *MultilineConfig, emit func(*Log)) *multilineAssembler {
+	return &multilineAssembler{config: config, emit: emit}
+}
+
+func (a *multilineAssembler) continues(line string) bool {
+	if a.config.Continuation != nil && a.config.Continuation.MatchString(line) {
+		return true
+	}
+	if a.config.FirstLine != nil {
+		return !a.config.FirstLine.MatchString(line)
+	}
+	return false
+}
+
+func (a *multilineAssembler) Add(logline *Log) {
+	a.Lock()
+	defer a.Unlock()
+	if a.pending != nil && a.continues(logline.Data) &&
+		(a.config.MaxBytes <= 0 || len(a.pending.Data)+1+len(logline.Data) <= a.config.MaxBytes) {
+		a.pending.Data += "\n" + logline.Data
+	} else {
+		a.flush()
+		a.pending = logline
+	}
+	if a.config.Timeout > 0 {
+		if a.timer == nil {
+			a.timer = time.AfterFunc(a.config.Timeout, a.Flush)
+		} else {
+			a.timer.Reset(a.config.Timeout)
+		}
+	}
+}
+
+// sends the pending event, if any
+func (a *multilineAssembler) Flush() {
+	a.Lock()
+	defer a.Unlock()
+	a.flush()
+}
+
+func (a *multilineAssembler) flush() {
+	if a.pending != nil {
+		a.emit(a.pending)
+		a.pending = nil
+	}
+}
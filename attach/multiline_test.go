@@ -0,0 +1,91 @@
+package attach
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMultilineAssembler(t *testing.T) {
+	tests := []struct {
+		name                    string
+		firstline, continuation string
+		maxBytes                string
+		lines, want             []string
+	}{
+		{
+			name:      "firstline",
+			firstline: `^\d{4}-`,
+			maxBytes:  "0",
+			lines:     []string{"2024-01-01 panic", "  at main.go:1", "  at lib.go:2", "2024-01-01 ok"},
+			want:      []string{"2024-01-01 panic\n  at main.go:1\n  at lib.go:2", "2024-01-01 ok"},
+		},
+		{
+			name:         "continuation",
+			continuation: `^\s`,
+			maxBytes:     "0",
+			lines:        []string{"Exception", "\tat a", "\tat b", "next", "other"},
+			want:         []string{"Exception\n\tat a\n\tat b", "next", "other"},
+		},
+		{
+			name:      "leading continuation lines",
+			firstline: `^START`,
+			maxBytes:  "0",
+			lines:     []string{"orphan", "more", "START", "body"},
+			want:      []string{"orphan\nmore", "START\nbody"},
+		},
+		{
+			name:         "max bytes",
+			continuation: `^ `,
+			maxBytes:     "8",
+			lines:        []string{"abc", " de", " fg", " h"},
+			want:         []string{"abc\n de", " fg\n h"},
+		},
+	}
+	for _, test := range tests {
+		config, err := NewMultilineConfig(test.firstline, test.continuation, "0", test.maxBytes)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		var got []string
+		a := newMultilineAssembler(config, func(logline *Log) { got = append(got, logline.Data) })
+		for _, line := range test.lines {
+			a.Add(&Log{Data: line})
+		}
+		a.Flush()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNewMultilineConfig(t *testing.T) {
+	if config, err := NewMultilineConfig("", "", "1s", "10"); config != nil || err != nil {
+		t.Errorf("no patterns gave %v, %v", config, err)
+	}
+	for _, args := range [][4]string{
+		{"(", "", "1s", "10"},
+		{"", "(", "1s", "10"},
+		{"x", "", "soon", "10"},
+		{"x", "", "1s", "many"},
+	} {
+		if _, err := NewMultilineConfig(args[0], args[1], args[2], args[3]); err == nil {
+			t.Errorf("NewMultilineConfig%q succeeded", args)
+		}
+	}
+}
+
+func TestMultilineFor(t *testing.T) {
+	saved := Multiline
+	defer func() { Multiline = saved }()
+	Multiline, _ = NewMultilineConfig("^A", "", "2s", "100")
+	if got := multilineFor("web", nil, nil); got != Multiline {
+		t.Error("container without labels didn't get the default config")
+	}
+	got := multilineFor("web", map[string]string{"logspout.multiline.continuation": "^ ", "logspout.multiline.max_bytes": "50"}, nil)
+	if got == Multiline || got.Continuation.String() != "^ " || got.MaxBytes != 50 || got.Timeout.String() != "2s" {
+		t.Errorf("labels gave %+v", got)
+	}
+	if got := multilineFor("web", map[string]string{"logspout.multiline.firstline": "("}, nil); got != Multiline {
+		t.Error("invalid labels didn't fall back to the default config")
+	}
+}
@@ -0,0 +1,165 @@
+package attach
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// Profile is how the lines of the containers it matches are handled, so
+// nginx, JVM and Go containers can each be parsed correctly without labels
+// on every one of them
+type Profile struct {
+	Name string `json:"name"`
+	// containers the profile applies to: those whose image matches the
+	// Image regex and that have every label in Labels, with its value
+	// unless that's empty. Containers can also name their profile with
+	// the logspout.profile label.
+	Image  string            `json:"image,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// how lines are merged into events, in place of MULTILINE_*
+	Multiline *ProfileMultiline `json:"multiline,omitempty"`
+	// layout timestamps are parsed with, in place of TIMESTAMP_LAYOUT
+	TimestampLayout string `json:"timestamp_layout,omitempty"`
+	// rules giving lines their severity, tried in order before the
+	// severity is detected from the line's text
+	Severity []SeverityRule `json:"severity,omitempty"`
+	// parsers routes run on the lines in place of their own, such as
+	// ["json"], or ["none"] for none
+	Parsers []string `json:"parsers,omitempty"`
+
+	imageRE   *regexp.Regexp
+	multiline *MultilineConfig
+}
+
+// ProfileMultiline is a profile's multiline merging, with the settings of
+// MULTILINE_* as defaults
+type ProfileMultiline struct {
+	FirstLine    string `json:"firstline,omitempty"`
+	Continuation string `json:"continuation,omitempty"`
+	Timeout      string `json:"timeout,omitempty"`
+	MaxBytes     int    `json:"max_bytes,omitempty"`
+}
+
+// SeverityRule gives lines matching a regex a severity
+type SeverityRule struct {
+	Pattern  string `json:"pattern"`
+	Severity string `json:"severity"`
+
+	re *regexp.Regexp
+}
+
+// profiles containers are matched against in order, from the file named
+// by PROFILES
+var Profiles []*Profile
+
+// reads a JSON list of profiles from path
+func LoadProfiles(path string) ([]*Profile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var profiles []*Profile
+	if err := Unmarshal(file, &profiles); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, profile := range profiles {
+		if err := profile.init(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", path, profile.Name, err)
+		}
+	}
+	return profiles, nil
+}
+
+func (p *Profile) init() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	var err error
+	if p.Image != "" {
+		if p.imageRE, err = regexp.Compile(p.Image); err != nil {
+			return fmt.Errorf("image: %s", err)
+		}
+	}
+	if m := p.Multiline; m != nil {
+		if m.FirstLine == "" && m.Continuation == "" {
+			return fmt.Errorf("multiline needs a firstline or continuation")
+		}
+		timeout, maxBytes := "1s", 65536
+		if Multiline != nil {
+			timeout, maxBytes = Multiline.Timeout.String(), Multiline.MaxBytes
+		}
+		if m.Timeout != "" {
+			timeout = m.Timeout
+		}
+		if m.MaxBytes > 0 {
+			maxBytes = m.MaxBytes
+		}
+		if p.multiline, err = NewMultilineConfig(m.FirstLine, m.Continuation, timeout, strconv.Itoa(maxBytes)); err != nil {
+			return fmt.Errorf("multiline %s", err)
+		}
+	}
+	for i := range p.Severity {
+		rule := &p.Severity[i]
+		if rule.re, err = regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("severity pattern: %s", err)
+		}
+		if NormalizeSeverity(rule.Severity) == "" {
+			return fmt.Errorf("unknown severity %q", rule.Severity)
+		}
+		rule.Severity = NormalizeSeverity(rule.Severity)
+	}
+	return nil
+}
+
+// reports whether the profile applies to a container running image with
+// labels
+func (p *Profile) matches(image string, labels map[string]string) bool {
+	if p.imageRE == nil && len(p.Labels) == 0 {
+		return false
+	}
+	if p.imageRE != nil && !p.imageRE.MatchString(image) {
+		return false
+	}
+	for label, value := range p.Labels {
+		if actual, ok := labels[label]; !ok || (value != "" && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the profile of a container: the one its logspout.profile label
+// names, or the first that matches it, or nil
+func profileFor(name, image string, labels map[string]string) *Profile {
+	if named := labels["logspout.profile"]; named != "" {
+		for _, profile := range Profiles {
+			if profile.Name == named {
+				return profile
+			}
+		}
+		logging.Logger("profile").Warn("unknown profile", "container", name, "profile", named)
+	}
+	for _, profile := range Profiles {
+		if profile.matches(image, labels) {
+			return profile
+		}
+	}
+	return nil
+}
+
+// returns the severity a line's profile gives it, or what its text states
+func severityFor(profile *Profile, data string) string {
+	if profile != nil {
+		for _, rule := range profile.Severity {
+			if rule.re.MatchString(data) {
+				return rule.Severity
+			}
+		}
+	}
+	return detectSeverity(data)
+}
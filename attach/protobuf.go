@@ -0,0 +1,199 @@
+package attach
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// protobuf wire types, see https://protobuf.dev/programming-guides/encoding
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// writes a line as a LogEntry message, see log.proto, prefixed with its
+// length as a varint
+func EncodeProto(logline *Log, buf *bytes.Buffer) error {
+	var msg []byte
+	for i, s := range []string{logline.ID, logline.Name, logline.Image, logline.Type, logline.Data} {
+		msg = appendProtoString(msg, i+1, s)
+	}
+	msg = appendProtoVarint(msg, 6, protoTime(logline.Time))
+	msg = appendProtoVarint(msg, 7, protoTime(logline.Timestamp))
+	msg = appendProtoString(msg, 8, logline.Severity)
+	if logline.Truncated {
+		msg = appendProtoVarint(msg, 9, 1)
+	}
+	keys := make([]string, 0, len(logline.Fields))
+	for key := range logline.Fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		field, value := 10, ""
+		if s, ok := logline.Fields[key].(string); ok {
+			value = s
+		} else {
+			encoded, err := json.Marshal(logline.Fields[key])
+			if err != nil {
+				return err
+			}
+			field, value = 11, string(encoded)
+		}
+		// map entries are messages of the key and value
+		entry := appendProtoString(appendProtoString(nil, 1, key), 2, value)
+		msg = appendProtoBytes(msg, field, entry)
+	}
+	b := binary.AppendUvarint(buf.AvailableBuffer(), uint64(len(msg)))
+	buf.Write(append(b, msg...))
+	return nil
+}
+
+// reads the first of a stream of length-prefixed LogEntry messages,
+// returning the line and what follows it
+func DecodeProto(data []byte) (*Log, []byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)-n) {
+		return nil, nil, errors.New("protobuf: truncated message")
+	}
+	msg, rest := data[n:n+int(length)], data[n+int(length):]
+	logline := new(Log)
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, nil, errors.New("protobuf: invalid tag")
+		}
+		msg = msg[n:]
+		field, wire := tag>>3, tag&7
+		var number uint64
+		var value []byte
+		switch wire {
+		case wireVarint:
+			if number, n = binary.Uvarint(msg); n <= 0 {
+				return nil, nil, errors.New("protobuf: invalid varint")
+			}
+			msg = msg[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return nil, nil, errors.New("protobuf: truncated field")
+			}
+			value, msg = msg[n:n+int(size)], msg[n+int(size):]
+		case wireFixed64, wireFixed32:
+			// fields added since, skipped
+			size := 8
+			if wire == wireFixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return nil, nil, errors.New("protobuf: truncated field")
+			}
+			msg = msg[size:]
+			continue
+		default:
+			return nil, nil, fmt.Errorf("protobuf: unsupported wire type %d", wire)
+		}
+		switch field {
+		case 1:
+			logline.ID = string(value)
+		case 2:
+			logline.Name = string(value)
+		case 3:
+			logline.Image = string(value)
+		case 4:
+			logline.Type = string(value)
+		case 5:
+			logline.Data = string(value)
+		case 6:
+			logline.Time = time.Unix(0, int64(number)).UTC()
+		case 7:
+			logline.Timestamp = time.Unix(0, int64(number)).UTC()
+		case 8:
+			logline.Severity = string(value)
+		case 9:
+			logline.Truncated = number != 0
+		case 10, 11:
+			key, s, err := decodeProtoEntry(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			if logline.Fields == nil {
+				logline.Fields = make(map[string]interface{})
+			}
+			if field == 10 {
+				logline.Fields[key] = s
+				continue
+			}
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+				return nil, nil, fmt.Errorf("protobuf: field %s: %s", key, err)
+			}
+			logline.Fields[key] = decoded
+		}
+	}
+	return logline, rest, nil
+}
+
+// returns the key and value of a map entry
+func decodeProtoEntry(entry []byte) (key, value string, err error) {
+	for len(entry) > 0 {
+		tag, n := binary.Uvarint(entry)
+		if n <= 0 || tag&7 != wireBytes {
+			return "", "", errors.New("protobuf: invalid map entry")
+		}
+		size, m := binary.Uvarint(entry[n:])
+		if m <= 0 || size > uint64(len(entry)-n-m) {
+			return "", "", errors.New("protobuf: truncated map entry")
+		}
+		s := string(entry[n+m : n+m+int(size)])
+		entry = entry[n+m+int(size):]
+		switch tag >> 3 {
+		case 1:
+			key = s
+		case 2:
+			value = s
+		}
+	}
+	return key, value, nil
+}
+
+// returns a time as nanoseconds since the epoch, or zero, which isn't
+// written, for the zero time, whose UnixNano is out of range
+func protoTime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// fields with default values aren't written, as proto3 does
+func appendProtoVarint(b []byte, field int, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(b, value)
+}
+
+func appendProtoString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return append(appendProtoLength(b, field, len(s)), s...)
+}
+
+func appendProtoBytes(b []byte, field int, data []byte) []byte {
+	return append(appendProtoLength(b, field, len(data)), data...)
+}
+
+func appendProtoLength(b []byte, field, length int) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireBytes)
+	return binary.AppendUvarint(b, uint64(length))
+}
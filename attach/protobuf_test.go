@@ -0,0 +1,85 @@
+package attach
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProtoRoundTrip(t *testing.T) {
+	now := time.Date(2026, 10, 14, 9, 30, 0, 123456789, time.UTC)
+	lines := []*Log{
+		{},
+		{
+			ID: "0123456789ab", Name: "web", Image: "nginx:1", Type: "stdout",
+			Data: "GET / 200 ünïcode", Time: now, Timestamp: now.Add(-time.Second),
+			Severity: "info", Truncated: true,
+			Fields: map[string]interface{}{
+				"method": "GET",
+				"status": float64(200),
+				"ok":     true,
+				"tags":   []interface{}{"a", "b"},
+				"nested": map[string]interface{}{"k": "v"},
+				"empty":  "",
+			},
+		},
+		// times before the epoch are negative
+		{Data: "old", Time: time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	var buf bytes.Buffer
+	for _, logline := range lines {
+		if err := EncodeProto(logline, &buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := buf.Bytes()
+	for i, want := range lines {
+		got, rest, err := DecodeProto(data)
+		if err != nil {
+			t.Fatalf("line %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("line %d: got %+v, want %+v", i, got, want)
+		}
+		data = rest
+	}
+	if len(data) != 0 {
+		t.Errorf("%d bytes left over", len(data))
+	}
+}
+
+func TestDecodeProtoErrors(t *testing.T) {
+	var buf bytes.Buffer
+	EncodeProto(&Log{ID: "abc", Data: "hello", Fields: map[string]interface{}{"k": "v"}}, &buf)
+	whole := buf.Bytes()
+	// every prefix of a message is short of its length
+	for n := 0; n < len(whole); n++ {
+		if _, _, err := DecodeProto(whole[:n]); err == nil {
+			t.Errorf("decoded %d of %d bytes", n, len(whole))
+		}
+	}
+	for name, data := range map[string][]byte{
+		"field longer than message": {2, 0x0a, 5},
+		"unsupported wire type":     {1, 0x0b},
+		"bad map entry":             {3, 0x52, 1, 0x08},
+		"bad json field":            {8, 0x5a, 6, 0x0a, 1, 'k', 0x12, 1, '{'},
+	} {
+		if _, _, err := DecodeProto(data); err == nil {
+			t.Errorf("%s: decoded", name)
+		}
+	}
+}
+
+func TestDecodeProtoSkipsUnknownFields(t *testing.T) {
+	// an unknown fixed64 field 20 and fixed32 field 21 around field 5
+	msg := []byte{0xa1, 0x01, 1, 2, 3, 4, 5, 6, 7, 8, 0x2a, 2, 'h', 'i', 0xad, 0x01, 1, 2, 3, 4}
+	data := append([]byte{byte(len(msg))}, msg...)
+	logline, _, err := DecodeProto(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logline.Data != "hi" {
+		t.Errorf("got data %q", logline.Data)
+	}
+}
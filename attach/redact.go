@@ -0,0 +1,117 @@
+package attach
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RedactRule masks matches of a pattern in log data. If the pattern has a
+// capture group, only what the group matched is masked.
+type RedactRule struct {
+	Name  string
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+var builtinRedactRules = map[string]*RedactRule{
+	"credit_card": {
+		Name:  "credit_card",
+		re:    regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		valid: luhnValid,
+	},
+	"bearer_token": {
+		Name: "bearer_token",
+		re:   regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`),
+	},
+	"email": {
+		Name: "email",
+		re:   regexp.MustCompile(`\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}\b`),
+	},
+}
+
+// rules applied to every line before it's streamed or routed, see REDACT
+var RedactRules []*RedactRule
+
+// text matches are replaced with, from REDACT_MASK
+var RedactMask = "[REDACTED]"
+
+// returns the built-in rules named in the comma-separated list names, plus
+// a rule for each REDACT_RULE_<NAME>=<regex> in environ
+func NewRedactRules(names string, environ []string) ([]*RedactRule, error) {
+	var rules []*RedactRule
+	for _, name := range SplitList(names) {
+		rule, ok := builtinRedactRules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redaction rule %q", name)
+		}
+		rules = append(rules, rule)
+	}
+	sort.Strings(environ)
+	for _, env := range environ {
+		if !strings.HasPrefix(env, "REDACT_RULE_") {
+			continue
+		}
+		key, pattern, _ := strings.Cut(env, "=")
+		name := strings.ToLower(strings.TrimPrefix(key, "REDACT_RULE_"))
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		rules = append(rules, &RedactRule{Name: name, re: re})
+	}
+	return rules, nil
+}
+
+// masks the rule's matches in data, counting each one
+func (r *RedactRule) apply(data string) string {
+	matches := r.re.FindAllStringSubmatchIndex(data, -1)
+	if matches == nil {
+		return data
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if len(match) > 2 && match[2] >= 0 {
+			start, end = match[2], match[3]
+		}
+		if r.valid != nil && !r.valid(data[start:end]) {
+			continue
+		}
+		b.WriteString(data[last:start])
+		b.WriteString(RedactMask)
+		last = end
+		redactions.WithLabelValues(r.Name).Inc()
+	}
+	b.WriteString(data[last:])
+	return b.String()
+}
+
+func redact(data string) string {
+	for _, rule := range RedactRules {
+		data = rule.apply(data)
+	}
+	return data
+}
+
+// checks a card number's Luhn checksum, ignoring separators
+func luhnValid(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
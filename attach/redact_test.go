@@ -0,0 +1,66 @@
+package attach
+
+import "testing"
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"4111-1111-1111-1111", true},
+		{"378282246310005", true},
+		{"4222222222222", true},
+		{"4111111111111112", false},
+		{"1234567812345678", false},
+		// too short to be a card, however it sums
+		{"0000000000", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := luhnValid(test.number); got != test.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", test.number, got, test.want)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	rules, err := NewRedactRules("credit_card, bearer_token,email", []string{
+		"PATH=/bin",
+		`REDACT_RULE_PASSWORD=password=(\S+)`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 || rules[3].Name != "password" {
+		t.Fatalf("got rules %v", rules)
+	}
+	saved := RedactRules
+	defer func() { RedactRules = saved }()
+	RedactRules = rules
+	tests := []struct{ data, want string }{
+		{"paid with 4111 1111 1111 1111 today", "paid with [REDACTED] today"},
+		{"order 4111111111111112 shipped", "order 4111111111111112 shipped"},
+		{"cards 4111111111111111,378282246310005", "cards [REDACTED],[REDACTED]"},
+		{"Authorization: Bearer abc.def-123==", "Authorization: Bearer [REDACTED]"},
+		{"mail ops@example.com now", "mail [REDACTED] now"},
+		{"login password=hunter2 ok", "login password=[REDACTED] ok"},
+		{"nothing to see", "nothing to see"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := redact(test.data); got != test.want {
+			t.Errorf("redact(%q) = %q, want %q", test.data, got, test.want)
+		}
+	}
+}
+
+func TestNewRedactRulesErrors(t *testing.T) {
+	if _, err := NewRedactRules("ssn", nil); err == nil {
+		t.Error("unknown rule accepted")
+	}
+	if _, err := NewRedactRules("", []string{"REDACT_RULE_BAD=("}); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
@@ -0,0 +1,65 @@
+package attach
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// how long repeats of a line are counted before they're summarized, from
+// REPEAT_WINDOW. Zero turns collapsing off.
+var RepeatWindow time.Duration
+
+// repeatCollapser collapses identical consecutive lines of a container
+// stream into "last message repeated N times" events, sent when a different
+// line arrives or at most a window after the first repeat
+type repeatCollapser struct {
+	sync.Mutex
+	window time.Duration
+	emit   func(*Log)
+	last   *Log
+	count  int
+	timer  *time.Timer
+}
+
+func newRepeatCollapser(window time.Duration, emit func(*Log)) *repeatCollapser {
+	return &repeatCollapser{window: window, emit: emit}
+}
+
+func (c *repeatCollapser) Add(logline *Log) {
+	c.Lock()
+	defer c.Unlock()
+	if c.last != nil && logline.Data == c.last.Data {
+		c.count++
+		if c.timer == nil {
+			c.timer = time.AfterFunc(c.window, c.Flush)
+		}
+		return
+	}
+	c.flush()
+	c.last = logline
+	c.emit(logline)
+}
+
+// sends a summary of the repeats counted so far, if any
+func (c *repeatCollapser) Flush() {
+	c.Lock()
+	defer c.Unlock()
+	c.flush()
+}
+
+func (c *repeatCollapser) flush() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.count == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	summary := *c.last
+	summary.Data = fmt.Sprintf("last message repeated %d times", c.count)
+	summary.Time, summary.Timestamp = now, now
+	c.count = 0
+	c.emit(&summary)
+}
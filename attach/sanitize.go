@@ -0,0 +1,66 @@
+package attach
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// what's done with invalid UTF-8 in lines, from INVALID_UTF8: replace with
+// U+FFFD, drop, hex escape as \xNN, or keep
+var InvalidUTF8 = "replace"
+
+func ValidInvalidUTF8(mode string) error {
+	switch mode {
+	case "replace", "drop", "hex", "keep":
+		return nil
+	}
+	return fmt.Errorf("must be replace, drop, hex or keep, not %q", mode)
+}
+
+// fixes up invalid UTF-8 byte sequences in data as InvalidUTF8 says
+func sanitizeUTF8(data string) string {
+	if InvalidUTF8 == "keep" || utf8.ValidString(data) {
+		return data
+	}
+	var b strings.Builder
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRuneInString(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			switch InvalidUTF8 {
+			case "replace":
+				b.WriteRune(utf8.RuneError)
+			case "hex":
+				fmt.Fprintf(&b, "\\x%02x", data[i])
+			}
+		} else {
+			b.WriteString(data[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// strip ANSI escapes and control characters from lines, from STRIP_ANSI
+var StripANSI = false
+
+// CSI sequences like colors and cursor movement, OSC sequences like window
+// titles, and other two-byte escapes
+var ansiRE = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-Z\\-_])`)
+
+// removes ANSI escape sequences and non-printable control characters,
+// keeping tabs and the newlines of merged multiline events
+func stripControl(data string) string {
+	if !strings.ContainsFunc(data, unicode.IsControl) {
+		return data
+	}
+	data = ansiRE.ReplaceAllString(data, "")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' {
+			return -1
+		}
+		return r
+	}, data)
+}
@@ -0,0 +1,117 @@
+package attach
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Selector selects the lines a listener is sent, and can change what it
+// selects while it's listened with, as websocket subscriptions do
+type Selector interface {
+	// reports whether any lines of a container may be selected
+	MatchContainer(id, name, image string, labels map[string]string) bool
+	// reports whether a line of a pump is selected. Called as the pump
+	// sends it, so it must be quick.
+	Match(logline *Log, pump *LogPump) bool
+	// receives whenever what's selected changes, or never
+	Changed() <-chan struct{}
+}
+
+// sourceSelector selects what a source does, which never changes
+type sourceSelector struct {
+	source *Source
+}
+
+func (s sourceSelector) MatchContainer(id, name, image string, labels map[string]string) bool {
+	return s.source.MatchContainer(id, name, image, labels)
+}
+
+func (s sourceSelector) Match(logline *Log, pump *LogPump) bool {
+	return s.source.matchPumped(logline)
+}
+
+func (s sourceSelector) Changed() <-chan struct{} {
+	return nil
+}
+
+// sends the lines selector selects to logstream until ctx is done, or
+// window.Until is. Listeners are added to the pumps of the containers it
+// selects, so lines it doesn't aren't sent at all, and moved when it
+// changes. window's Tail, Since and Until pick the lines replayed first and
+// when to stop; lines of containers only selected after a change aren't
+// replayed.
+func (m *AttachManager) ListenSelector(ctx context.Context, window *Source, selector Selector, logstream chan *Log) {
+	if !window.Until.IsZero() {
+		if !window.Until.After(time.Now()) {
+			m.sendHistory(window, selector, logstream)
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, window.Until)
+		defer cancel()
+	}
+	events := make(chan *AttachEvent)
+	m.addListener(events)
+	defer m.removeListener(events)
+	listened := make(map[*LogPump]bool)
+	defer func() {
+		for pump := range listened {
+			pump.RemoveListener(logstream)
+		}
+	}()
+	add := func(pump *LogPump, replay bool) {
+		match := func(logline *Log) bool {
+			return window.matchPumped(logline) && selector.Match(logline, pump)
+		}
+		from := time.Now()
+		if replay {
+			var history []*Log
+			history, from = m.history(pump, window)
+			for _, logline := range history {
+				if selector.Match(logline, pump) {
+					logstream <- logline
+				}
+			}
+		}
+		pump.addListener(logstream, window, from, match)
+		listened[pump] = true
+	}
+	for {
+		select {
+		case event := <-events:
+			if event.Type != "attach" {
+				event.done()
+				if event.Type == "detach" {
+					for pump := range listened {
+						if pump.ID == event.ID && m.Get(pump.ID) != pump {
+							pump.RemoveListener(logstream)
+							delete(listened, pump)
+						}
+					}
+					if window.ID != "" && strings.HasPrefix(event.ID, window.ID) {
+						return
+					}
+				}
+				continue
+			}
+			pump := m.Get(event.ID)
+			if pump != nil && !listened[pump] && selector.MatchContainer(pump.ID, pump.Name, pump.Image, pump.Labels) {
+				add(pump, true)
+			}
+			event.done()
+		case <-selector.Changed():
+			for _, pump := range m.Pumps() {
+				selected := selector.MatchContainer(pump.ID, pump.Name, pump.Image, pump.Labels)
+				if selected && !listened[pump] {
+					add(pump, false)
+				} else if !selected && listened[pump] {
+					pump.RemoveListener(logstream)
+					delete(listened, pump)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package attach
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// name of the pump logspout's own logs are published on, so routes can
+// select them with name:logspout or types:["internal"]
+const selfName = "logspout"
+
+// selfLog publishes logspout's own log records as the lines of a pump, for
+// routing like any container's. Records are queued, and dropped if the
+// queue is full, so logging from a route never waits on that route.
+type selfLog struct {
+	pump  *LogPump
+	lines chan *Log
+}
+
+// registers the pump and returns a handler writing records at or above
+// level to it
+func NewSelfLog(level slog.Level, attacher *AttachManager) slog.Handler {
+	s := &selfLog{
+		pump:  NewLogPump(strings.NewReader(""), strings.NewReader(""), "self", selfName, selfName, nil),
+		lines: make(chan *Log, 1000),
+	}
+	attacher.AddPump(s.pump)
+	go func() {
+		for logline := range s.lines {
+			s.pump.prepare(logline)
+		}
+	}()
+	return slog.NewTextHandler(s, &slog.HandlerOptions{
+		Level: level,
+		// lines carry their own time
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})
+}
+
+// queues one record, as the handler writes each in a single call
+func (s *selfLog) Write(p []byte) (int, error) {
+	now := time.Now().UTC()
+	logline := &Log{
+		ID:        s.pump.ID,
+		Name:      selfName,
+		Image:     selfName,
+		Type:      StreamInternal,
+		Data:      string(bytes.TrimSuffix(p, []byte("\n"))),
+		Time:      now,
+		Timestamp: now,
+	}
+	select {
+	case s.lines <- logline:
+	default:
+	}
+	return len(p), nil
+}
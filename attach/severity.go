@@ -0,0 +1,78 @@
+package attach
+
+import (
+	"regexp"
+	"strings"
+)
+
+// severities from most to least severe, named as in syslog
+var severities = []string{"emerg", "alert", "crit", "error", "warning", "notice", "info", "debug"}
+
+var severityAliases = map[string]string{
+	"emergency":   "emerg",
+	"panic":       "crit",
+	"fatal":       "crit",
+	"critical":    "crit",
+	"err":         "error",
+	"e":           "error",
+	"warn":        "warning",
+	"w":           "warning",
+	"i":           "info",
+	"information": "info",
+	"d":           "debug",
+	"trace":       "debug",
+}
+
+// returns the syslog name for a level, or "" if it isn't one
+func NormalizeSeverity(level string) string {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if alias, ok := severityAliases[level]; ok {
+		return alias
+	}
+	for _, severity := range severities {
+		if severity == level {
+			return severity
+		}
+	}
+	return ""
+}
+
+var (
+	// an upper case level in the first few words, e.g. after a timestamp
+	severityWordRE = regexp.MustCompile(`^(?:\S+\s+){0,3}?\[?(EMERG|ALERT|CRIT|CRITICAL|FATAL|PANIC|ERROR|ERR|WARN|WARNING|NOTICE|INFO|DEBUG|TRACE)\]?(?::|\s|$)`)
+	// a leading level in any case followed by a colon, e.g. Error: ...
+	severityPrefixRE = regexp.MustCompile(`^(?i:(emerg|alert|crit|critical|fatal|panic|error|err|warn|warning|notice|info|debug|trace)):`)
+	severityLogfmtRE = regexp.MustCompile(`(?:^|\s)(?:level|lvl|severity)="?(\w+)`)
+	// glog, e.g. E0102 15:04:05.000000
+	severityGlogRE = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}`)
+)
+
+// returns the severity a line's text states, or ""
+func detectSeverity(data string) string {
+	for _, re := range []*regexp.Regexp{severityGlogRE, severityPrefixRE, severityWordRE, severityLogfmtRE} {
+		if match := re.FindStringSubmatch(data); match != nil {
+			if match[1] == "F" {
+				return "crit"
+			}
+			if severity := NormalizeSeverity(match[1]); severity != "" {
+				return severity
+			}
+		}
+	}
+	return ""
+}
+
+// returns the severity of a syslog priority, which includes the facility
+func PrioritySeverity(priority int) string {
+	return severities[priority&7]
+}
+
+// returns the syslog priority for a severity, info if it's unknown
+func SyslogPriority(severity string) int {
+	for i, name := range severities {
+		if name == severity {
+			return i
+		}
+	}
+	return 6 // info
+}
@@ -0,0 +1,155 @@
+package attach
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Source struct {
+	ID         string            `json:"id,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Prefix     string            `json:"prefix,omitempty"`
+	Filter     string            `json:"filter,omitempty"`
+	NameRegex  string            `json:"name_regex,omitempty"`
+	ImageRegex string            `json:"image_regex,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Namespace  string            `json:"namespace,omitempty"`
+	Types      []string          `json:"types,omitempty"`
+	DataRegex  string            `json:"data_regex,omitempty"`
+	Severities []string          `json:"severities,omitempty"`
+
+	// number of buffered lines to replay per container before streaming
+	Tail int `json:"-"`
+	// the window lines' timestamps must be in: lines since Since are
+	// replayed before streaming, and streaming stops at Until
+	Since time.Time `json:"-"`
+	Until time.Time `json:"-"`
+	// set when the listener derives severities itself, so pumps can't
+	// filter on them
+	LaterSeverity bool `json:"-"`
+
+	once       sync.Once
+	err        error
+	nameRE     *regexp.Regexp
+	imageRE    *regexp.Regexp
+	dataRE     *regexp.Regexp
+	types      StreamTypes
+	severities map[string]bool
+}
+
+func (s *Source) All() bool {
+	return s.ID == "" && s.Name == "" && s.Filter == "" && s.Prefix == "" &&
+		s.NameRegex == "" && s.ImageRegex == "" && len(s.Labels) == 0 && s.Namespace == ""
+}
+
+// compiles the source's regexps, returning the first invalid one
+func (s *Source) Validate() error {
+	s.once.Do(func() {
+		compile := func(expr string) *regexp.Regexp {
+			if expr == "" || s.err != nil {
+				return nil
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				s.err = err
+			}
+			return re
+		}
+		s.nameRE = compile(s.NameRegex)
+		s.imageRE = compile(s.ImageRegex)
+		s.dataRE = compile(s.DataRegex)
+		if types, err := ParseStreamTypes(s.Types); err != nil && s.err == nil {
+			s.err = err
+		} else {
+			s.types = types
+		}
+		for _, severity := range s.Severities {
+			if s.err == nil && NormalizeSeverity(severity) == "" {
+				s.err = fmt.Errorf("unknown severity %q", severity)
+			}
+			if s.severities == nil {
+				s.severities = make(map[string]bool)
+			}
+			s.severities[NormalizeSeverity(severity)] = true
+		}
+	})
+	return s.err
+}
+
+// changes the stream types a validated source selects. The source mustn't
+// be in use meanwhile.
+func (s *Source) SetTypes(types []string) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	set, err := ParseStreamTypes(types)
+	if err != nil {
+		return err
+	}
+	s.Types, s.types = types, set
+	return nil
+}
+
+// reports whether pumps should send a line to a listener with the source,
+// from the criteria that hold for every line of the pump. MatchLine still
+// has the final say.
+func (s *Source) matchPumped(logline *Log) bool {
+	if s == nil || s.Validate() != nil {
+		return true
+	}
+	return s.types.Match(logline.Type) && s.inWindow(logline) &&
+		(s.LaterSeverity || s.severities == nil || s.severities[logline.Severity])
+}
+
+// reports whether a container's logs are selected by all of the source's
+// container criteria. A nil source selects every container.
+func (s *Source) MatchContainer(id, name, image string, labels map[string]string) bool {
+	if s == nil {
+		return true
+	}
+	if s.Validate() != nil {
+		return false
+	}
+	switch {
+	case s.ID != "" && !strings.HasPrefix(id, s.ID),
+		s.Name != "" && name != s.Name,
+		s.Prefix != "" && !strings.HasPrefix(name, s.Prefix),
+		s.Filter != "" && !strings.Contains(name, s.Filter),
+		s.nameRE != nil && !s.nameRE.MatchString(name),
+		s.imageRE != nil && !s.imageRE.MatchString(image):
+		return false
+	}
+	if s.Namespace != "" {
+		k8sContainer := CachedK8sContainer(name)
+		if k8sContainer == nil || k8sContainer.Namespace != s.Namespace {
+			return false
+		}
+	}
+	for key, value := range s.Labels {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// reports whether a log line passes the source's type and content criteria.
+// A nil source passes every line.
+func (s *Source) MatchLine(logline *Log) bool {
+	if s == nil {
+		return true
+	}
+	if s.Validate() != nil {
+		return false
+	}
+	if !s.types.Match(logline.Type) || !s.inWindow(logline) {
+		return false
+	}
+	if s.severities != nil && !s.severities[logline.Severity] {
+		return false
+	}
+	return s.dataRE == nil || s.dataRE.MatchString(logline.Data)
+}
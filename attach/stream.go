@@ -0,0 +1,108 @@
+package attach
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// the stream types lines have, their Type. Inputs add their own, such as
+// syslog and journald.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	// Docker's events, published by PublishDockerEvents
+	StreamDockerEvent = "docker_event"
+	// Kubernetes' events and container stats, for inputs that publish them
+	StreamK8sEvent = "k8s_event"
+	StreamStats    = "stats"
+	// logspout's own logs and audit events
+	StreamInternal = "internal"
+)
+
+// older names of stream types, still accepted by sources
+var streamAliases = map[string]string{"logspout": StreamInternal}
+
+var streamTypeRE = regexp.MustCompile(`^[a-z0-9_.+-]+$`)
+
+// StreamTypes is the set of stream types a source selects, or nil for
+// every type
+type StreamTypes map[string]bool
+
+// parses a source's types. Each may also be a comma-separated list, as the
+// types query param is.
+func ParseStreamTypes(types []string) (StreamTypes, error) {
+	var set StreamTypes
+	for _, list := range types {
+		for _, typ := range SplitList(list) {
+			typ = strings.ToLower(typ)
+			if alias, ok := streamAliases[typ]; ok {
+				typ = alias
+			}
+			if !streamTypeRE.MatchString(typ) {
+				return nil, fmt.Errorf("invalid stream type %q", typ)
+			}
+			if set == nil {
+				set = make(StreamTypes)
+			}
+			set[typ] = true
+		}
+	}
+	return set, nil
+}
+
+// reports whether lines of a stream type are selected
+func (t StreamTypes) Match(typ string) bool {
+	return t == nil || t[typ]
+}
+
+// name of the pump Docker's events are published on, so routes can select
+// them with name:docker or types:["docker_event"]
+const dockerEventsName = "docker"
+
+// publishes Docker's events as lines of the docker_event type, see
+// DOCKER_EVENTS
+func (m *AttachManager) PublishDockerEvents() {
+	pump := NewInputPump("docker-events", dockerEventsName, dockerEventsName, nil)
+	m.AddPump(pump)
+	m.events.Store(pump)
+}
+
+// publishes an event from Docker, if they're published
+func (m *AttachManager) publishEvent(msg *docker.APIEvents) {
+	pump := m.events.Load()
+	if pump == nil || msg.Status == "" {
+		return
+	}
+	now := time.Now().UTC()
+	logline := &Log{
+		ID:        pump.ID,
+		Name:      dockerEventsName,
+		Image:     dockerEventsName,
+		Type:      StreamDockerEvent,
+		Data:      strings.TrimSpace(msg.Status + " " + shortID(msg.ID) + " " + msg.From),
+		Time:      now,
+		Timestamp: now,
+		Fields:    map[string]interface{}{"status": msg.Status},
+	}
+	if msg.ID != "" {
+		logline.Fields["id"] = msg.ID
+	}
+	if msg.From != "" {
+		logline.Fields["from"] = msg.From
+	}
+	if msg.Time > 0 {
+		logline.Timestamp = time.Unix(msg.Time, 0).UTC()
+	}
+	pump.Inject(logline)
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
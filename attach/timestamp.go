@@ -0,0 +1,114 @@
+package attach
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// layout used to parse timestamps at the start of lines, from
+// TIMESTAMP_LAYOUT. Empty means detect common layouts, none turns parsing off.
+var TimestampLayout = ""
+
+type timestampFormat struct {
+	re      *regexp.Regexp
+	layouts []string
+	// the layout has no year, so the current one is assumed
+	yearless bool
+}
+
+// layouts detected when no layout is configured. Times without a zone are
+// taken to be UTC.
+var timestampFormats = []timestampFormat{
+	{
+		re: regexp.MustCompile(`^\[?(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?)`),
+		layouts: []string{
+			"2006-01-02T15:04:05.999999999Z07:00",
+			"2006-01-02T15:04:05.999999999Z0700",
+			"2006-01-02T15:04:05.999999999",
+		},
+	},
+	{
+		re:      regexp.MustCompile(`^.{0,64}?\[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`),
+		layouts: []string{"02/Jan/2006:15:04:05 -0700"},
+	},
+	{
+		re:       regexp.MustCompile(`^\[?(\w{3} [ \d]\d \d{2}:\d{2}:\d{2}(?:\.\d+)?)`),
+		layouts:  []string{"Jan _2 15:04:05.999999999"},
+		yearless: true,
+	},
+	{
+		// glog, e.g. I0102 15:04:05.000000
+		re:       regexp.MustCompile(`^[IWEF](\d{4} \d{2}:\d{2}:\d{2}\.\d+)`),
+		layouts:  []string{"0102 15:04:05.999999999"},
+		yearless: true,
+	},
+}
+
+// returns the timestamp at the start of data, parsed with layout or, if
+// that's empty, any of the common layouts
+func ParseTimestamp(data, layout string) (time.Time, bool) {
+	if layout == "none" {
+		return time.Time{}, false
+	}
+	if layout != "" {
+		// try as many leading fields as the layout has
+		fields := strings.Fields(data)
+		n := len(strings.Fields(layout))
+		if n == 0 || len(fields) < n {
+			return time.Time{}, false
+		}
+		value := strings.TrimLeft(strings.Join(fields[:n], " "), "[")
+		value = strings.TrimRight(value, "]:")
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return withYear(t), true
+	}
+	for _, format := range timestampFormats {
+		match := format.re.FindStringSubmatch(data)
+		if match == nil {
+			continue
+		}
+		value := strings.Replace(match[1], ",", ".", 1)
+		if len(value) > 10 && value[10] == ' ' && value[4] == '-' {
+			value = value[:10] + "T" + value[11:]
+		}
+		for _, layout := range format.layouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				if format.yearless {
+					t = withYear(t)
+				}
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// fills in the current year for times parsed without one, going back a year
+// for times that would otherwise be in the future, e.g. around new year
+func withYear(t time.Time) time.Time {
+	if t.Year() != 0 {
+		return t
+	}
+	now := time.Now().UTC()
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// returns the timestamp layout for a container, its profile's if it has
+// one, which its logspout.timestamp.layout label can override
+func timestampLayoutFor(labels map[string]string, profile *Profile) string {
+	if layout := labels["logspout.timestamp.layout"]; layout != "" {
+		return layout
+	}
+	if profile != nil && profile.TimestampLayout != "" {
+		return profile.TimestampLayout
+	}
+	return TimestampLayout
+}
@@ -0,0 +1,55 @@
+package attach
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// longest line shipped, from MAX_LINE_BYTES. Zero means no limit.
+var MaxLineBytes = 0
+
+// whether longer lines are truncated or split into several, from LONG_LINES
+var LongLines = "truncate"
+
+func ValidLongLines(mode string) error {
+	if mode != "truncate" && mode != "split" {
+		return fmt.Errorf("must be truncate or split, not %q", mode)
+	}
+	return nil
+}
+
+// returns the line cut down to MaxLineBytes, or split into lines of up to
+// MaxLineBytes, never cutting a UTF-8 character in two
+func limitLength(logline *Log) []*Log {
+	if MaxLineBytes <= 0 || len(logline.Data) <= MaxLineBytes {
+		return []*Log{logline}
+	}
+	if LongLines != "split" {
+		logline.Data = logline.Data[:CutPoint(logline.Data, MaxLineBytes)]
+		logline.Truncated = true
+		return []*Log{logline}
+	}
+	var parts []*Log
+	for data := logline.Data; data != ""; {
+		n := len(data)
+		if n > MaxLineBytes {
+			n = CutPoint(data, MaxLineBytes)
+		}
+		part := *logline
+		part.Data = data[:n]
+		parts = append(parts, &part)
+		data = data[n:]
+	}
+	return parts
+}
+
+// returns the longest prefix length of at most n bytes that ends on a
+// character boundary
+func CutPoint(data string, n int) int {
+	for i := n; i > n-utf8.UTFMax && i > 0; i-- {
+		if utf8.RuneStart(data[i]) {
+			return i
+		}
+	}
+	return n
+}
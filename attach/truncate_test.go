@@ -0,0 +1,75 @@
+package attach
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCutPoint(t *testing.T) {
+	tests := []struct {
+		data string
+		n    int
+		want int
+	}{
+		{"hello world", 5, 5},
+		{"héllo", 1, 1},
+		// é is two bytes, from 1 to 3
+		{"héllo", 2, 1},
+		{"héllo", 3, 3},
+		// € is three bytes, from 1 to 4
+		{"a€b", 2, 1},
+		{"a€b", 3, 1},
+		{"a€b", 4, 4},
+		// 😀 is four bytes, from 1 to 5
+		{"a😀b", 4, 1},
+		{"a😀b", 5, 5},
+	}
+	for _, test := range tests {
+		if got := CutPoint(test.data, test.n); got != test.want {
+			t.Errorf("CutPoint(%q, %d) = %d, want %d", test.data, test.n, got, test.want)
+		}
+	}
+}
+
+func TestLimitLength(t *testing.T) {
+	savedMax, savedMode := MaxLineBytes, LongLines
+	defer func() { MaxLineBytes, LongLines = savedMax, savedMode }()
+	data := strings.Repeat("ab€", 5)
+
+	MaxLineBytes, LongLines = 0, "truncate"
+	if lines := limitLength(&Log{Data: data}); len(lines) != 1 || lines[0].Data != data || lines[0].Truncated {
+		t.Errorf("no limit changed the line: %+v", lines)
+	}
+
+	MaxLineBytes = 7
+	lines := limitLength(&Log{Data: data})
+	if len(lines) != 1 || lines[0].Data != "ab€ab" || !lines[0].Truncated {
+		t.Errorf("truncated to %+v", lines)
+	}
+	short := limitLength(&Log{Data: "ab€"})
+	if len(short) != 1 || short[0].Truncated {
+		t.Errorf("short line truncated: %+v", short)
+	}
+
+	LongLines = "split"
+	lines = limitLength(&Log{ID: "c1", Data: data})
+	var joined strings.Builder
+	for _, line := range lines {
+		if len(line.Data) > MaxLineBytes || !utf8.ValidString(line.Data) || line.ID != "c1" || line.Truncated {
+			t.Errorf("bad part %+v", line)
+		}
+		joined.WriteString(line.Data)
+	}
+	if joined.String() != data {
+		t.Errorf("parts join to %q, want %q", joined.String(), data)
+	}
+}
+
+func TestValidLongLines(t *testing.T) {
+	for mode, valid := range map[string]bool{"truncate": true, "split": true, "drop": false, "": false} {
+		if err := ValidLongLines(mode); (err == nil) != valid {
+			t.Errorf("ValidLongLines(%q) = %v", mode, err)
+		}
+	}
+}
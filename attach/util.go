@@ -0,0 +1,46 @@
+package attach
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+func Marshal(obj interface{}) []byte {
+	bytes, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		logging.Logger("main").Error("Marshal failed", "err", err)
+	}
+	return bytes
+}
+
+func Unmarshal(input io.ReadCloser, obj interface{}) error {
+	body, err := ioutil.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(obj); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+	return nil
+}
+
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,90 @@
+package attach
+
+import (
+	"errors"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// how long an attached container's stream may go without a line before the
+// watchdog checks whether the container has written any since, see
+// STALL_TIMEOUT. Zero disables the watchdog.
+var StallTimeout = 5 * time.Minute
+
+// what a stalled stream's pipes are closed with
+var errStalled = errors.New("stream stalled")
+
+// checks quiet containers every StallTimeout, reattaching to those whose
+// stream has stalled
+func (m *AttachManager) watchStalls() {
+	for range time.Tick(StallTimeout) {
+		for _, pump := range m.Pumps() {
+			if pump.stop == nil || time.Since(pump.lastRead()) < StallTimeout {
+				continue
+			}
+			if m.stalled(pump) {
+				m.reattach(pump)
+			}
+		}
+	}
+}
+
+// reports whether the pump's container is running and Docker has logged
+// lines for it since the pump last read one, which the stream should have
+// delivered
+func (m *AttachManager) stalled(pump *LogPump) bool {
+	container, err := m.client.InspectContainer(pump.ID)
+	if err != nil || !container.State.Running {
+		return false
+	}
+	var written countingWriter
+	err = m.client.Logs(docker.LogsOptions{
+		Container:    pump.ID,
+		OutputStream: &written,
+		ErrorStream:  &written,
+		Stdout:       true,
+		Stderr:       true,
+		// only lines from the second after the last read, so one read late
+		// in that second isn't mistaken for a missed one
+		Since:       pump.lastRead().Unix() + 1,
+		Tail:        "1",
+		RawTerminal: container.Config.Tty,
+	})
+	if err != nil {
+		// log drivers that can't be read back can't be checked
+		logging.Logger("attacher").Debug("checking for missed lines failed", "container", pump.ID, "err", err)
+		return false
+	}
+	return written > 0
+}
+
+// replaces a stalled pump with a new attachment to its container. Listeners
+// move to the new pump as they would for a container that was just started.
+func (m *AttachManager) reattach(pump *LogPump) {
+	logging.Logger("attacher").Warn("container stream stalled, reattaching", "container", pump.ID,
+		"name", pump.Name, "quiet", time.Since(pump.lastRead()).Round(time.Second))
+	stalledStreams.Inc()
+	pump.stop()
+	m.attach(pump.ID)
+	// the old attachment's cleanup only removes it if it's still current
+	m.Lock()
+	failed := m.attached[pump.ID] == pump
+	if failed {
+		delete(m.attached, pump.ID)
+	}
+	m.Unlock()
+	if failed {
+		m.send(&AttachEvent{ID: pump.ID, Name: pump.Name, Type: "detach"})
+	}
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter int
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
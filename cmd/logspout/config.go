@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// set at build time with -ldflags "-X main.version=..." etc., see Makefile
+var (
+	version   = "dev"
+	gitSHA    = "unknown"
+	buildDate = "unknown"
+)
+
+// settings from command line flags, which take precedence over the
+// environment, and from the config file, which the environment overrides
+var (
+	flagSettings   = make(map[string]string)
+	configSettings = make(map[string]string)
+	// guards configSettings, which reloads replace
+	configLock sync.RWMutex
+)
+
+// flags mirroring the environment variables they set
+var settingFlags = []struct {
+	flag, env, usage string
+	bool             bool
+}{
+	{"port", "PORT", "port to serve the API on, or none", false},
+	{"docker-host", "DOCKER_HOST", "Docker or Podman endpoint to attach to", false},
+	{"routes-path", "ROUTESPATH", "directory routes are persisted in", false},
+	{"config", "CONFIG", "file of KEY=VALUE settings read before the environment", false},
+	{"debug", "DEBUG", "log at debug level", true},
+}
+
+// commandOptions are the flags that pick what logspout does rather than
+// configure it
+type commandOptions struct {
+	version  bool
+	validate bool
+	probe    bool
+	// ship stdin to target, as the logs of a container described by the
+	// rest, see runStdin
+	stdin  bool
+	target string
+	name   string
+	image  string
+	labels string
+	fields string
+}
+
+// parses the command line, returning the remaining arguments
+func parseFlags(args []string) ([]string, commandOptions, error) {
+	flags := flag.NewFlagSet("logspout", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: logspout [flags] [route uri]")
+		flags.PrintDefaults()
+	}
+	var opts commandOptions
+	flags.BoolVar(&opts.version, "version", false, "print the version and exit")
+	flags.BoolVar(&opts.validate, "validate", false, "check the configuration and routes, then exit")
+	flags.BoolVar(&opts.probe, "probe", false, "with --validate, also check route targets can be reached")
+	flags.BoolVar(&opts.stdin, "stdin", false, "ship the lines of stdin to the route uri, then exit")
+	flags.StringVar(&opts.target, "target", "", "route uri, instead of the argument")
+	flags.StringVar(&opts.name, "name", "stdin", "with --stdin, container name lines are shipped as")
+	flags.StringVar(&opts.image, "image", "", "with --stdin, image lines are shipped as, the name by default")
+	flags.StringVar(&opts.labels, "labels", "", "with --stdin, comma-separated key=value container labels")
+	flags.StringVar(&opts.fields, "fields", "", "with --stdin, comma-separated key=value fields added to every line")
+	values := make(map[string]*string)
+	bools := make(map[string]*bool)
+	for _, f := range settingFlags {
+		if f.bool {
+			bools[f.flag] = flags.Bool(f.flag, false, f.usage+" ($"+f.env+")")
+		} else {
+			values[f.flag] = flags.String(f.flag, "", f.usage+" ($"+f.env+")")
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, opts, err
+	}
+	flags.Visit(func(set *flag.Flag) {
+		for _, f := range settingFlags {
+			switch {
+			case f.flag != set.Name:
+			case f.bool && *bools[f.flag]:
+				flagSettings[f.env] = "1"
+			case !f.bool:
+				flagSettings[f.env] = *values[f.flag]
+			}
+		}
+	})
+	args = flags.Args()
+	if opts.target != "" {
+		args = append([]string{opts.target}, args...)
+	}
+	return args, opts, nil
+}
+
+// reads KEY=VALUE settings from path, ignoring blank lines and # comments
+func loadConfig(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	settings := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want KEY=VALUE", path, n)
+		}
+		settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return settings, scanner.Err()
+}
+
+// reads the CONFIG file, if there is one, into configSettings
+func readConfig() error {
+	path := getopt("CONFIG", "")
+	if path == "" {
+		return nil
+	}
+	settings, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	configLock.Lock()
+	configSettings = settings
+	configLock.Unlock()
+	return nil
+}
+
+// sockets looked for when DOCKER_HOST isn't set, in order: Docker's, where
+// the image has it mounted, then Podman's rootful and rootless ones
+func dockerSockets() []string {
+	sockets := []string{"/var/run/docker.sock", "/tmp/docker.sock", "/run/podman/podman.sock"}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		sockets = append(sockets, filepath.Join(dir, "podman", "podman.sock"))
+	}
+	return append(sockets, fmt.Sprintf("/run/user/%d/podman/podman.sock", os.Getuid()))
+}
+
+// returns the endpoint to attach to: DOCKER_HOST, Docker's named pipe on
+// Windows, or the first socket of dockerSockets that exists
+func dockerEndpoint() string {
+	if endpoint := getopt("DOCKER_HOST", ""); endpoint != "" {
+		return endpoint
+	}
+	if runtime.GOOS == "windows" {
+		return "npipe:////./pipe/docker_engine"
+	}
+	for _, socket := range dockerSockets() {
+		if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return "unix://" + socket
+		}
+	}
+	return "unix:///var/run/docker.sock"
+}
+
+func versionString() string {
+	return fmt.Sprintf("logspout %s (%s, built %s, %s)", version, gitSHA, buildDate, runtime.Version())
+}
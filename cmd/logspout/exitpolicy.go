@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// how often the exit policy checks routes and Docker
+const exitCheckInterval = 10 * time.Second
+
+// exitPolicy exits logspout when it's been broken for long enough that a
+// restart by the orchestrator is the better bet, from the
+// EXIT_ON_ROUTES_FAILING and EXIT_ON_DOCKER_UNREACHABLE settings. Zero
+// durations never exit.
+type exitPolicy struct {
+	routesFailing     time.Duration
+	dockerUnreachable time.Duration
+}
+
+// checks routes and Docker until one has been failing for longer than the
+// policy allows, then exits nonzero
+func (p exitPolicy) watch(attacher *attach.AttachManager, routes *router.RouteManager) {
+	var routesSince, dockerSince time.Time
+	for range time.Tick(exitCheckInterval) {
+		if p.routesFailing > 0 && failingFor(&routesSince, routes.AllFailing()) >= p.routesFailing {
+			fatal("routes", fmt.Sprintf("every route has been failing for over %s", p.routesFailing))
+		}
+		if p.dockerUnreachable > 0 {
+			err := attacher.Ping()
+			if failingFor(&dockerSince, err != nil) >= p.dockerUnreachable {
+				fatal("docker", fmt.Sprintf("unreachable for over %s: %s", p.dockerUnreachable, err))
+			}
+		}
+	}
+}
+
+// returns how long a check has been failing, given since, when it started
+// failing, which is kept up to date
+func failingFor(since *time.Time, failing bool) time.Duration {
+	if !failing {
+		*since = time.Time{}
+		return 0
+	}
+	if since.IsZero() {
+		*since = time.Now()
+	}
+	return time.Since(*since)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	_ "github.com/jimmidyson/logspout/adapters/azure"
+	"github.com/jimmidyson/logspout/adapters/elasticsearch"
+	_ "github.com/jimmidyson/logspout/adapters/firehose"
+	_ "github.com/jimmidyson/logspout/adapters/kafka"
+	_ "github.com/jimmidyson/logspout/adapters/logentries"
+	_ "github.com/jimmidyson/logspout/adapters/mongodb"
+	_ "github.com/jimmidyson/logspout/adapters/postgres"
+	_ "github.com/jimmidyson/logspout/adapters/relay"
+	_ "github.com/jimmidyson/logspout/adapters/s3"
+	_ "github.com/jimmidyson/logspout/adapters/syslog"
+	_ "github.com/jimmidyson/logspout/adapters/tcp"
+	_ "github.com/jimmidyson/logspout/adapters/udp"
+	_ "github.com/jimmidyson/logspout/adapters/ws"
+	_ "github.com/jimmidyson/logspout/adapters/zmq"
+	"github.com/jimmidyson/logspout/api"
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/inputs"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+	_ "github.com/jimmidyson/logspout/stores"
+)
+
+func assert(err error, context string) {
+	if err != nil {
+		fatal(context, err)
+	}
+}
+
+// logs err as the reason logspout can't carry on and exits
+func fatal(context string, err interface{}) {
+	logging.Logger("main").Error(context+" failed", "err", err)
+	os.Exit(1)
+}
+
+// returns a setting from the command line flags, the environment or the
+// config file, in that order, then from the file named by the setting with
+// _FILE appended, see readSecret, or dfault if none of them set it
+func getopt(name, dfault string) string {
+	if value, ok := lookupSetting(name); ok {
+		return value
+	}
+	if path, ok := lookupSetting(name + "_FILE"); ok && path != "" {
+		value, err := readSecret(name, path)
+		if err != nil {
+			fatal(name+"_FILE", err)
+		}
+		return value
+	}
+	return dfault
+}
+
+// returns a setting from the command line flags, the environment or the
+// config file, in that order
+func lookupSetting(name string) (string, bool) {
+	if value, ok := flagSettings[name]; ok {
+		return value, true
+	}
+	if value := os.Getenv(name); value != "" {
+		return value, true
+	}
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if value := configSettings[name]; value != "" {
+		return value, true
+	}
+	return "", false
+}
+
+// listens on a unix socket at path, replacing a stale socket left by a
+// previous run
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func configureLogging() error {
+	level := getopt("LOG_LEVEL", "info")
+	if getopt("DEBUG", "") != "" {
+		level = "debug"
+	}
+	return logging.Setup(getopt("LOG_FORMAT", "text"), level, getopt("LOG_LEVELS", ""))
+}
+
+// re-reads the config file, secrets and the persisted routes. Logging
+// settings and credentials take effect right away; other settings need a
+// restart.
+func reload(routes *router.RouteManager, auth *api.Authenticator) error {
+	if err := readConfig(); err != nil {
+		return err
+	}
+	if err := configureLogging(); err != nil {
+		return err
+	}
+	if err := applySecrets(auth); err != nil {
+		return err
+	}
+	if err := routes.Reload(); err != nil {
+		return err
+	}
+	routes.RestartChangedCredentials()
+	return nil
+}
+
+func main() {
+	args, opts, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	if opts.version {
+		fmt.Println(versionString())
+		return
+	}
+	assert(readConfig(), "CONFIG")
+	assert(configureLogging(), "logging")
+	log := logging.Logger("main")
+	interval, err := time.ParseDuration(getopt("RESOLVE_INTERVAL", router.ResolveInterval.String()))
+	assert(err, "RESOLVE_INTERVAL")
+	router.ResolveInterval = interval
+	attach.BufferLines, err = strconv.Atoi(getopt("BUFFER_LINES", strconv.Itoa(attach.BufferLines)))
+	assert(err, "BUFFER_LINES")
+	if attach.BufferLines < 0 {
+		fatal("BUFFER_LINES", "must not be negative")
+	}
+	router.Hostname = router.DetectHostname(getopt("HOSTNAME", ""), getopt("HOSTNAME_FQDN", "true") != "false")
+	attach.TimestampLayout = getopt("TIMESTAMP_LAYOUT", "")
+	attach.StripANSI = getopt("STRIP_ANSI", "") != ""
+	attach.RepeatWindow, err = time.ParseDuration(getopt("REPEAT_WINDOW", "0"))
+	assert(err, "REPEAT_WINDOW")
+	attach.StallTimeout, err = time.ParseDuration(getopt("STALL_TIMEOUT", attach.StallTimeout.String()))
+	assert(err, "STALL_TIMEOUT")
+	attach.InvalidUTF8 = getopt("INVALID_UTF8", attach.InvalidUTF8)
+	assert(attach.ValidInvalidUTF8(attach.InvalidUTF8), "INVALID_UTF8")
+	attach.MaxLineBytes, err = strconv.Atoi(getopt("MAX_LINE_BYTES", "0"))
+	assert(err, "MAX_LINE_BYTES")
+	attach.LongLines = getopt("LONG_LINES", attach.LongLines)
+	assert(attach.ValidLongLines(attach.LongLines), "LONG_LINES")
+	router.StaticFields, err = router.ParseFields(getopt("FIELDS", ""))
+	assert(err, "FIELDS")
+	if path := getopt("METRIC_RULES", ""); path != "" {
+		attach.MetricRules, err = attach.LoadMetricRules(path)
+		assert(err, "METRIC_RULES")
+	}
+	if path := getopt("ALERT_RULES", ""); path != "" {
+		attach.AlertRules, err = attach.LoadAlertRules(path)
+		assert(err, "ALERT_RULES")
+	}
+	if path := getopt("GEOIP_DB", ""); path != "" {
+		router.GeoIPDB, err = router.NewGeoIP(path, getopt("GEOIP_ASN_DB", ""),
+			attach.SplitList(getopt("GEOIP_FIELDS", "client_ip,remote_addr,ip")))
+		assert(err, "GEOIP_DB")
+	}
+	attach.RedactMask = getopt("REDACT_MASK", attach.RedactMask)
+	attach.RedactRules, err = attach.NewRedactRules(getopt("REDACT", ""), os.Environ())
+	assert(err, "redact")
+	attach.Multiline, err = attach.NewMultilineConfig(getopt("MULTILINE_FIRSTLINE", ""), getopt("MULTILINE_CONTINUATION", ""),
+		getopt("MULTILINE_TIMEOUT", "1s"), getopt("MULTILINE_MAX_BYTES", "65536"))
+	assert(err, "multiline")
+	if path := getopt("PROFILES", ""); path != "" {
+		attach.Profiles, err = attach.LoadProfiles(path)
+		assert(err, "PROFILES")
+		assert(router.ValidateProfiles(attach.Profiles), "PROFILES")
+	}
+	elasticsearch.Sniff = getopt("ES_SNIFF", "") != ""
+	elasticsearch.SetCredentials(getopt("ES_USERNAME", ""), getopt("ES_PASSWORD", ""), getopt("ES_API_KEY", ""))
+	assert(router.SetDefaultTLS(&router.TLSConfig{
+		CA:           getopt("TARGET_TLS_CA", ""),
+		Cert:         getopt("TARGET_TLS_CERT", ""),
+		Key:          getopt("TARGET_TLS_KEY", ""),
+		MinVersion:   getopt("TARGET_TLS_MIN_VERSION", ""),
+		CipherSuites: attach.SplitList(getopt("TARGET_TLS_CIPHER_SUITES", "")),
+	}), "TARGET_TLS")
+
+	port := getopt("PORT", "8000")
+	endpoint := dockerEndpoint()
+	routespath := getopt("ROUTESPATH", "/var/lib/logspout")
+	if opts.validate {
+		os.Exit(validate(args, routespath, opts.probe))
+	}
+	if opts.stdin {
+		os.Exit(runStdin(args, opts))
+	}
+	log.Info("starting", "version", version, "git_sha", gitSHA, "docker_host", endpoint)
+
+	client, err := docker.NewClient(endpoint)
+	assert(err, "docker")
+	attacher, err := attach.NewAttachManager(client)
+	assert(err, "attacher")
+	attach.RegisterMetrics(attacher)
+	if level := getopt("SELF_LOGS", ""); level != "" {
+		var selfLevel slog.Level
+		assert(selfLevel.UnmarshalText([]byte(level)), "SELF_LOGS")
+		logging.AddHandler(attach.NewSelfLog(selfLevel, attacher))
+		log = logging.Logger("main")
+	}
+	if getopt("DOCKER_EVENTS", "") != "" {
+		attacher.PublishDockerEvents()
+	}
+	routes := router.NewRouteManager(attacher)
+	shutdownTimeout, err := time.ParseDuration(getopt("SHUTDOWN_TIMEOUT", "8s"))
+	assert(err, "SHUTDOWN_TIMEOUT")
+	inputs.IdleTimeout, err = time.ParseDuration(getopt("INPUT_IDLE_TIMEOUT", inputs.IdleTimeout.String()))
+	assert(err, "INPUT_IDLE_TIMEOUT")
+	inputs.MaxConns, err = strconv.Atoi(getopt("INPUT_MAX_CONNS", strconv.Itoa(inputs.MaxConns)))
+	assert(err, "INPUT_MAX_CONNS")
+	if inputs.MaxConns < 1 {
+		fatal("INPUT_MAX_CONNS", "must be at least 1")
+	}
+	if listen := getopt("SYSLOG_LISTEN", ""); listen != "" {
+		assert(inputs.ListenSyslog(attach.SplitList(listen), attacher), "SYSLOG_LISTEN")
+	}
+	if patterns := getopt("TAIL_FILES", ""); patterns != "" {
+		positionsFile := ""
+		if _, err := os.Stat(routespath); err == nil {
+			positionsFile = routespath + "/files.positions"
+		}
+		poll, err := time.ParseDuration(getopt("TAIL_POLL_INTERVAL", "1s"))
+		assert(err, "TAIL_POLL_INTERVAL")
+		assert(inputs.TailFiles(inputs.FileConfig{
+			Patterns:      attach.SplitList(patterns),
+			PositionsFile: getopt("TAIL_POSITIONS_FILE", positionsFile),
+			PollInterval:  poll,
+		}, attacher), "TAIL_FILES")
+	}
+	if listen := getopt("GELF_LISTEN", ""); listen != "" {
+		assert(inputs.ListenGELF(attach.SplitList(listen), attacher), "GELF_LISTEN")
+	}
+	if listen := getopt("RELAY_LISTEN", ""); listen != "" {
+		relayTLS, err := api.NewServerTLSConfig(getopt("RELAY_TLS_CERT", ""), getopt("RELAY_TLS_KEY", ""),
+			getopt("RELAY_TLS_CLIENT_CA", ""), false)
+		assert(err, "RELAY_TLS")
+		assert(inputs.ListenRelay(listen, relayTLS, attacher), "RELAY_LISTEN")
+	}
+	if getopt("JOURNALD", "") != "" {
+		cursorFile := ""
+		if _, err := os.Stat(routespath); err == nil {
+			cursorFile = routespath + "/journald.cursor"
+		}
+		assert(inputs.ReadJournal(inputs.JournalConfig{
+			Journalctl: getopt("JOURNALCTL", "journalctl"),
+			Directory:  getopt("JOURNALD_DIRECTORY", ""),
+			CursorFile: getopt("JOURNALD_CURSOR_FILE", cursorFile),
+			Units:      attach.SplitList(getopt("JOURNALD_UNITS", "")),
+		}, attacher), "JOURNALD")
+	}
+
+	if len(args) > 0 {
+		expandedUrl := os.ExpandEnv(args[0])
+		u, err := url.Parse(expandedUrl)
+		assert(err, "url")
+		log.Info("routing all", "url", expandedUrl)
+		assert(routes.Add(&router.Route{Target: router.URITarget(u)}), "route")
+	}
+
+	if uri := getopt("ROUTES_STORE", ""); uri != "" {
+		store, err := router.OpenRouteStore(uri)
+		assert(err, "ROUTES_STORE")
+		log.Info("loading and persisting routes", "store", uri)
+		assert(routes.Load(store), "persistor")
+	} else if _, err := os.Stat(routespath); err == nil {
+		log.Info("loading and persisting routes", "path", routespath)
+		assert(routes.Load(router.RouteFileStore(routespath)), "persistor")
+	}
+
+	if highWater := getopt("QUEUE_HIGH_WATER", ""); highWater != "" {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(highWater, "%"), 64)
+		assert(err, "QUEUE_HIGH_WATER")
+		if percent <= 0 || percent > 100 {
+			fatal("QUEUE_HIGH_WATER", "must be a percentage between 0 and 100")
+		}
+		sustained, err := time.ParseDuration(getopt("QUEUE_HIGH_WATER_FOR", "30s"))
+		assert(err, "QUEUE_HIGH_WATER_FOR")
+		go routes.WatchQueues(percent, sustained)
+	}
+	var policy exitPolicy
+	policy.routesFailing, err = time.ParseDuration(getopt("EXIT_ON_ROUTES_FAILING", "0"))
+	assert(err, "EXIT_ON_ROUTES_FAILING")
+	policy.dockerUnreachable, err = time.ParseDuration(getopt("EXIT_ON_DOCKER_UNREACHABLE", "0"))
+	assert(err, "EXIT_ON_DOCKER_UNREACHABLE")
+	if policy.routesFailing > 0 || policy.dockerUnreachable > 0 {
+		go policy.watch(attacher, routes)
+	}
+
+	limiter, err := api.NewStreamLimiterFromEnv(getopt)
+	assert(err, "limits")
+	auth, err := api.NewAuthenticator(getopt("API_TOKENS", ""), getopt("API_USERS", ""))
+	assert(err, "auth")
+	go watchSecrets(func() {
+		if err := applySecrets(auth); err != nil {
+			logging.Logger("main").Error("applying changed secrets failed", "err", err)
+			return
+		}
+		logging.Logger("main").Info("secrets reloaded")
+	})
+	go watchRouteCredentials(routes)
+
+	httpAPI := api.NewAPI(attacher, routes, auth, limiter)
+	httpAPI.DebugEndpoints = getopt("DEBUG_ENDPOINTS", "") != ""
+	httpAPI.Reload = func() error { return reload(routes, auth) }
+	httpAPI.Build = api.BuildInfo{Version: version, GitSHA: gitSHA, BuildDate: buildDate}
+	httpAPI.CORS = api.NewCORSPolicy(getopt("CORS_ORIGINS", ""), getopt("CORS_HEADERS", ""))
+	if audit := getopt("AUDIT_LOG", ""); audit != "" {
+		if audit == "stream" {
+			audit = ""
+		}
+		httpAPI.Audit, err = api.NewAuditLog(audit, attacher)
+		assert(err, "audit")
+	}
+
+	tlsConfig, err := api.NewServerTLSConfig(
+		getopt("TLS_CERT", ""), getopt("TLS_KEY", ""), getopt("TLS_CLIENT_CA", ""),
+		getopt("TLS_SELF_SIGNED", "") != "")
+	assert(err, "tls")
+	// streams run until the client goes away, so they're ended on shutdown
+	// by cancelling the context every request derives from
+	baseCtx, stopStreams := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:        ":" + port,
+		Handler:     httpAPI.Handler(),
+		TLSConfig:   tlsConfig,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	go func() {
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		for range hangups {
+			if err := reload(routes, auth); err != nil {
+				logging.Logger("main").Error("reload failed", "err", err)
+				continue
+			}
+			logging.Logger("main").Info("reloaded")
+		}
+	}()
+	shutdown := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		log.Info("shutting down", "signal", <-signals)
+		stopStreams()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Error("shutdown failed", "err", err)
+		}
+		if !routes.Shutdown(shutdownTimeout) {
+			log.Error("routes didn't finish sending in time", "timeout", shutdownTimeout)
+		}
+		httpAPI.Audit.Close()
+		close(shutdown)
+	}()
+
+	serveErrs := make(chan error, 2)
+	listeners := 0
+	if socket := getopt("API_SOCKET", ""); socket != "" {
+		mode, err := strconv.ParseUint(getopt("API_SOCKET_MODE", "0660"), 8, 32)
+		assert(err, "API_SOCKET_MODE")
+		ln, err := listenUnix(socket, os.FileMode(mode))
+		assert(err, "socket")
+		log.Info("serving http", "addr", "unix:"+socket)
+		listeners++
+		go func() { serveErrs <- server.Serve(ln) }()
+	}
+	if port != "none" {
+		listeners++
+		go func() {
+			if tlsConfig != nil {
+				log.Info("serving https", "addr", ":"+port)
+				serveErrs <- server.ListenAndServeTLS("", "")
+			} else {
+				log.Info("serving http", "addr", ":"+port)
+				serveErrs <- server.ListenAndServe()
+			}
+		}()
+	}
+	if listeners == 0 {
+		fatal("serving", "nothing to listen on: PORT is none and API_SOCKET is unset")
+	}
+	for i := 0; i < listeners; i++ {
+		if err := <-serveErrs; err != http.ErrServerClosed {
+			fatal("serving", err)
+		}
+	}
+	<-shutdown
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmidyson/logspout/adapters/elasticsearch"
+	"github.com/jimmidyson/logspout/api"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// how often secret files are checked for changes
+const secretPollInterval = 10 * time.Second
+
+// secretFile is a file a setting was read from, with what it held
+type secretFile struct {
+	path, value string
+}
+
+// the files settings have been read from, by setting, so changes to them
+// are noticed
+var (
+	secretFiles = make(map[string]secretFile)
+	secretLock  sync.Mutex
+)
+
+// reads the value of a setting from a file, as Docker and Kubernetes mount
+// secrets, without the trailing newline editors add. If the file can't be
+// read after it has been once, as while a secret is being replaced, the
+// value read last is kept.
+func readSecret(name, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		secretLock.Lock()
+		defer secretLock.Unlock()
+		if file, ok := secretFiles[name]; ok && file.path == path {
+			return file.value, nil
+		}
+		return "", err
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	secretLock.Lock()
+	secretFiles[name] = secretFile{path: path, value: value}
+	secretLock.Unlock()
+	return value, nil
+}
+
+// calls changed whenever a file a setting was read from holds something
+// else. Files that can't be read, as while a secret is being replaced, are
+// checked again next time.
+func watchSecrets(changed func()) {
+	for range time.Tick(secretPollInterval) {
+		secretLock.Lock()
+		files := make([]secretFile, 0, len(secretFiles))
+		for _, file := range secretFiles {
+			files = append(files, file)
+		}
+		secretLock.Unlock()
+		for _, file := range files {
+			if data, err := os.ReadFile(file.path); err == nil && strings.TrimRight(string(data), "\r\n") != file.value {
+				changed()
+				break
+			}
+		}
+	}
+}
+
+// restarts routes whose credential files, like a sign key_file or a
+// password_file, have changed, as often as secret files are checked
+func watchRouteCredentials(routes *router.RouteManager) {
+	for range time.Tick(secretPollInterval) {
+		routes.RestartChangedCredentials()
+	}
+}
+
+// re-reads the settings that are credentials, which may have been rotated,
+// and puts them into effect
+func applySecrets(auth *api.Authenticator) error {
+	if err := auth.Update(getopt("API_TOKENS", ""), getopt("API_USERS", "")); err != nil {
+		return err
+	}
+	elasticsearch.SetCredentials(getopt("ES_USERNAME", ""), getopt("ES_PASSWORD", ""), getopt("ES_API_KEY", ""))
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// how long shipping waits for the route to start listening, and for its
+// adapter to send what's left once stdin is closed
+const (
+	stdinStartTimeout    = 5 * time.Second
+	stdinShutdownTimeout = 30 * time.Second
+)
+
+// ships the lines of stdin to the route uri in args, as the logs of a
+// container described by opts, until stdin is closed. Docker isn't needed
+// and the API isn't served. Returns the exit code.
+func runStdin(args []string, opts commandOptions) int {
+	log := logging.Logger("main")
+	if len(args) == 0 {
+		log.Error("--stdin needs a route uri")
+		return 2
+	}
+	u, err := url.Parse(os.ExpandEnv(args[0]))
+	if err != nil {
+		log.Error("url failed", "err", err)
+		return 2
+	}
+	labels, err := router.ParseFields(opts.labels)
+	if err != nil {
+		log.Error("labels failed", "err", err)
+		return 2
+	}
+	fields, err := router.ParseFields(opts.fields)
+	if err != nil {
+		log.Error("fields failed", "err", err)
+		return 2
+	}
+	image := opts.image
+	if image == "" {
+		image = opts.name
+	}
+
+	attacher := attach.NewInputManager()
+	routes := router.NewRouteManager(attacher)
+	if err := routes.Add(&router.Route{ID: "stdin", Target: router.URITarget(u)}); err != nil {
+		log.Error("route failed", "err", err)
+		return 1
+	}
+	pump := attach.NewInputPump("stdin", opts.name, image, labels)
+	attacher.AddPump(pump)
+	// lines published before the route listens would be lost
+	for start := time.Now(); pump.Listeners() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > stdinStartTimeout {
+			log.Error("route didn't start listening")
+			return 1
+		}
+	}
+
+	rd := bufio.NewReaderSize(os.Stdin, 64*1024)
+	for {
+		line, err := rd.ReadString('\n')
+		if line != "" {
+			now := time.Now().UTC()
+			logline := &attach.Log{
+				ID:        pump.ID,
+				Name:      opts.name,
+				Image:     image,
+				Type:      "stdout",
+				Data:      strings.TrimRight(line, "\r\n"),
+				Time:      now,
+				Timestamp: now,
+			}
+			if len(fields) > 0 {
+				logline.Fields = make(map[string]interface{}, len(fields))
+				for key, value := range fields {
+					logline.Fields[key] = value
+				}
+			}
+			pump.Inject(logline)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Error("reading stdin failed", "err", err)
+			break
+		}
+	}
+	if !routes.Shutdown(stdinShutdownTimeout) {
+		log.Error("route didn't finish sending in time")
+		return 1
+	}
+	return 0
+}
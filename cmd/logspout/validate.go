@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jimmidyson/logspout/api"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// checks the API settings and the routes logspout would start with, and
+// with probe, that the routes' targets can be reached. Problems are printed
+// to stderr and the exit code returned, so CI can gate config changes.
+// Settings read before this are checked by failing to start.
+func validate(args []string, routespath string, probe bool) int {
+	var problems []string
+	report := func(what string, err error) {
+		problems = append(problems, what+": "+err.Error())
+	}
+	if _, err := api.NewStreamLimiterFromEnv(getopt); err != nil {
+		report("limits", err)
+	}
+	if _, err := api.NewAuthenticator(getopt("API_TOKENS", ""), getopt("API_USERS", "")); err != nil {
+		report("auth", err)
+	}
+	if _, err := api.NewServerTLSConfig(getopt("TLS_CERT", ""), getopt("TLS_KEY", ""), getopt("TLS_CLIENT_CA", ""), false); err != nil {
+		report("tls", err)
+	}
+
+	var routes []*router.Route
+	if len(args) > 0 {
+		u, err := url.Parse(os.ExpandEnv(args[0]))
+		if err != nil {
+			report("url", err)
+		} else {
+			routes = append(routes, &router.Route{ID: "command line", Target: router.URITarget(u)})
+		}
+	}
+	if uri := getopt("ROUTES_STORE", ""); uri != "" {
+		store, err := router.OpenRouteStore(uri)
+		if err == nil {
+			var stored []*router.Route
+			stored, err = store.GetAll()
+			routes = append(routes, stored...)
+		}
+		if err != nil {
+			report("ROUTES_STORE", err)
+		}
+	} else if files, err := os.ReadDir(routespath); err == nil {
+		store := router.RouteFileStore(routespath)
+		for _, file := range files {
+			id, ok := strings.CutSuffix(file.Name(), ".json")
+			if !ok {
+				continue
+			}
+			route, err := store.Get(id)
+			if err != nil {
+				report(store.Filename(id), err)
+				continue
+			}
+			routes = append(routes, route)
+		}
+	} else if !os.IsNotExist(err) {
+		report("ROUTESPATH", err)
+	}
+	for _, route := range routes {
+		if err := route.Validate(); err != nil {
+			report("route "+route.ID, err)
+			continue
+		}
+		if probe {
+			if err := probeTarget(route); err != nil {
+				report("route "+route.ID, err)
+			}
+		}
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Printf("configuration ok, %d routes\n", len(routes))
+	return 0
+}
+
+// target types connected to over TCP, besides the *+tls ones
+var tcpTargets = map[string]bool{"es": true, "kafka": true, "logentries": true, "relay": true, "syslog+tcp": true, "tcp+json": true}
+
+// checks a route's target addresses resolve and, for TCP based targets,
+// accept connections, completing a TLS handshake for *+tls ones
+func probeTarget(route *router.Route) error {
+	resolver, err := router.NewAddrResolver(route.Target, 0)
+	if err != nil {
+		return err
+	}
+	if !tcpTargets[route.Target.Type] && !route.Target.UsesTLS() {
+		return nil
+	}
+	for _, hostport := range resolver.Hosts() {
+		conn, err := route.DialTCP(hostport)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	}
+	return nil
+}
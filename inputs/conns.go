@@ -0,0 +1,82 @@
+package inputs
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// how long a connection to the syslog, GELF or relay inputs may go without
+// sending anything before it's closed, set from INPUT_IDLE_TIMEOUT
+var IdleTimeout = 5 * time.Minute
+
+// how many connections each of those inputs' listeners serves at once,
+// set from INPUT_MAX_CONNS. Connections over the limit are closed as
+// they're accepted.
+var MaxConns = 256
+
+// accepts connections from ln until it fails, handling each in its own
+// goroutine with reads that fail once IdleTimeout passes without data, and
+// closing it once handle returns
+func serveStreams(ln net.Listener, input string, handle func(net.Conn)) {
+	slots := make(chan struct{}, MaxConns)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			logging.Logger("inputs").Error(input+" accept failed", "addr", ln.Addr(), "err", err)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			logging.Logger("inputs").Warn(input+" connection refused, too many connections", "client", conn.RemoteAddr(), "max", MaxConns)
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer func() { <-slots }()
+			defer conn.Close()
+			handle(&idleConn{Conn: conn, timeout: IdleTimeout})
+		}()
+	}
+}
+
+// idleConn is a connection whose reads time out after a while without data
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if c.timeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(p)
+}
+
+// reads from rd up to and including delim, failing once more than max bytes
+// come without it, so a client that never sends it can't grow the frame
+// without bound
+func readFrame(rd *bufio.Reader, delim byte, max int) ([]byte, error) {
+	var frame []byte
+	for {
+		chunk, err := rd.ReadSlice(delim)
+		size := len(frame) + len(chunk)
+		if err == nil {
+			// not counting delim
+			size--
+		}
+		if size > max {
+			return nil, fmt.Errorf("frame longer than %d bytes", max)
+		}
+		frame = append(frame, chunk...)
+		if err != bufio.ErrBufferFull {
+			return frame, err
+		}
+	}
+}
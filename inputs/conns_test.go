@@ -0,0 +1,69 @@
+package inputs
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServeStreams(t *testing.T) {
+	defer func(timeout time.Duration, max int) { IdleTimeout, MaxConns = timeout, max }(IdleTimeout, MaxConns)
+	IdleTimeout, MaxConns = 100*time.Millisecond, 1
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	handled := make(chan error, 2)
+	go serveStreams(ln, "test", func(conn net.Conn) {
+		_, err := io.Copy(io.Discard, conn)
+		handled <- err
+	})
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if _, err := first.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	// over the limit, so closed without being handled
+	second, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection over the limit: got %v, want EOF", err)
+	}
+
+	// the first is closed once it's idle
+	select {
+	case err := <-handled:
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Errorf("idle connection: got %v, want a timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle connection wasn't closed")
+	}
+	first.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := first.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("idle connection: got %v, want EOF", err)
+	}
+
+	// and its slot is free again
+	third, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	third.Close()
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection after the idle one closed wasn't handled")
+	}
+}
@@ -0,0 +1,239 @@
+package inputs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// name and stream type of the pump tailed files' lines are published on
+const fileName = "file"
+
+// longest line read from a file before it's published as it is
+const maxFileLine = 1 << 20
+
+// FileConfig configures the file tail input
+type FileConfig struct {
+	// glob patterns of the files to tail, as filepath.Match describes
+	Patterns []string
+	// file the position reached in each file is saved to, so lines aren't
+	// shipped twice or missed across restarts. Empty starts at the end of
+	// files that exist at startup every time.
+	PositionsFile string
+	// how often files are checked for new lines, new files and rotation
+	PollInterval time.Duration
+}
+
+// filePosition is where tailing a file got to, saved to the positions file
+type filePosition struct {
+	// identifies the file, to tell if it's been rotated while logspout
+	// wasn't running
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// tailedFile is a file being read
+type tailedFile struct {
+	path    string
+	file    *os.File
+	info    os.FileInfo
+	offset  int64
+	pending []byte
+}
+
+// fileTail polls the files matching its patterns and publishes their new
+// lines as the logs of a file pump
+type fileTail struct {
+	config    FileConfig
+	pump      *attach.LogPump
+	files     map[string]*tailedFile
+	positions map[string]filePosition
+	saved     []byte
+}
+
+// starts tailing the files matching config's patterns. Files that exist now
+// are read from their saved position, or their end without one; files that
+// appear later are read from the start.
+func TailFiles(config FileConfig, attacher *attach.AttachManager) error {
+	for _, pattern := range config.Patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	t := &fileTail{
+		config:    config,
+		pump:      attach.NewInputPump(fileName, fileName, fileName, nil),
+		files:     make(map[string]*tailedFile),
+		positions: make(map[string]filePosition),
+	}
+	if config.PositionsFile != "" {
+		data, err := os.ReadFile(config.PositionsFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &t.positions); err != nil {
+				return err
+			}
+		}
+		t.saved = data
+	}
+	attacher.AddPump(t.pump)
+	t.scan(true)
+	go func() {
+		for range time.Tick(config.PollInterval) {
+			t.scan(false)
+		}
+	}()
+	return nil
+}
+
+// opens newly matching files, reads what's been added to every file and
+// saves the positions reached
+func (t *fileTail) scan(startup bool) {
+	matched := make(map[string]bool)
+	for _, pattern := range t.config.Patterns {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			matched[path] = true
+			if _, ok := t.files[path]; !ok {
+				t.open(path, startup)
+			}
+		}
+	}
+	for path, f := range t.files {
+		t.read(f)
+		if !t.checkRotated(f) && !matched[path] {
+			// deleted, or renamed to a name the patterns don't match
+			t.flush(f)
+			f.file.Close()
+			delete(t.files, path)
+		}
+	}
+	t.savePositions()
+}
+
+func (t *fileTail) open(path string, startup bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		logging.Logger("inputs").Debug("opening tailed file failed", "path", path, "err", err)
+		return
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return
+	}
+	f := &tailedFile{path: path, file: file, info: info}
+	if position, ok := t.positions[path]; ok && position.Inode == fileInode(info) && position.Offset <= info.Size() {
+		f.offset = position.Offset
+	} else if startup {
+		f.offset = info.Size()
+	}
+	if _, err := file.Seek(f.offset, io.SeekStart); err != nil {
+		file.Close()
+		return
+	}
+	t.files[path] = f
+}
+
+// publishes the complete lines added to the file since it was last read
+func (t *fileTail) read(f *tailedFile) {
+	info, err := f.file.Stat()
+	if err == nil && info.Size() < f.offset+int64(len(f.pending)) {
+		// truncated in place, as copytruncate rotation does
+		f.file.Seek(0, io.SeekStart)
+		f.offset, f.pending = 0, nil
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.file.Read(buf)
+		f.pending = append(f.pending, buf[:n]...)
+		for {
+			i := bytes.IndexByte(f.pending, '\n')
+			if i < 0 {
+				break
+			}
+			t.publish(f, f.pending[:i])
+			f.offset += int64(i + 1)
+			f.pending = f.pending[i+1:]
+		}
+		if len(f.pending) > maxFileLine {
+			t.flush(f)
+		}
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+// reports whether the file's path now names another file, switching to
+// that file after publishing what's left of the old one
+func (t *fileTail) checkRotated(f *tailedFile) bool {
+	info, err := os.Stat(f.path)
+	if err != nil || os.SameFile(info, f.info) {
+		return err == nil
+	}
+	t.flush(f)
+	f.file.Close()
+	delete(t.files, f.path)
+	t.open(f.path, false)
+	if reopened, ok := t.files[f.path]; ok {
+		t.read(reopened)
+	}
+	return true
+}
+
+// publishes a partial last line
+func (t *fileTail) flush(f *tailedFile) {
+	if len(f.pending) > 0 {
+		t.publish(f, f.pending)
+		f.offset += int64(len(f.pending))
+		f.pending = nil
+	}
+}
+
+func (t *fileTail) publish(f *tailedFile, line []byte) {
+	now := time.Now().UTC()
+	t.pump.Inject(&attach.Log{
+		ID:        fileName,
+		Name:      fileName,
+		Image:     fileName,
+		Type:      fileName,
+		Data:      string(bytes.TrimSuffix(line, []byte("\r"))),
+		Time:      now,
+		Timestamp: now,
+		Fields:    map[string]interface{}{"path": f.path},
+	})
+}
+
+// writes the position reached in each file to the positions file, if
+// they've moved on
+func (t *fileTail) savePositions() {
+	if t.config.PositionsFile == "" {
+		return
+	}
+	positions := make(map[string]filePosition, len(t.files))
+	for path, f := range t.files {
+		positions[path] = filePosition{Inode: fileInode(f.info), Offset: f.offset}
+	}
+	t.positions = positions
+	data, _ := json.Marshal(positions)
+	if bytes.Equal(data, t.saved) {
+		return
+	}
+	if err := writeFileAtomic(t.config.PositionsFile, data); err != nil {
+		logging.Logger("inputs").Error("saving file positions failed", "file", t.config.PositionsFile, "err", err)
+		return
+	}
+	t.saved = data
+}
@@ -0,0 +1,258 @@
+package inputs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// name and stream type of the pump GELF messages that don't come from a
+// container are published on
+const gelfName = "gelf"
+
+const (
+	// largest GELF message accepted, after decompression
+	maxGELFMessage = 1 << 20
+	// the spec's limit on chunks per message
+	maxGELFChunks = 128
+	// how long the chunks of a message may take to arrive
+	gelfChunkTimeout = 5 * time.Second
+)
+
+// gelfMessage is a message being reassembled from chunks
+type gelfMessage struct {
+	chunks   [][]byte
+	received int
+	started  time.Time
+}
+
+// gelf receives GELF messages and publishes them. Messages from Docker's
+// gelf log driver are published as the logs of their container, see
+// ContainerPumps.
+type gelf struct {
+	pump       *attach.LogPump
+	containers *ContainerPumps
+
+	sync.Mutex
+	chunked map[string]*gelfMessage
+}
+
+// starts receiving GELF messages on addrs, each udp://host:port or
+// tcp://host:port. UDP messages may be chunked and compressed; TCP ones are
+// separated by null bytes.
+func ListenGELF(addrs []string, attacher *attach.AttachManager) error {
+	g := &gelf{
+		pump:       attach.NewInputPump(gelfName, gelfName, gelfName, nil),
+		containers: NewContainerPumps(attacher, 0),
+		chunked:    make(map[string]*gelfMessage),
+	}
+	var listeners []func()
+	for _, addr := range addrs {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return err
+		}
+		switch u.Scheme {
+		case "udp":
+			conn, err := net.ListenPacket("udp", u.Host)
+			if err != nil {
+				return err
+			}
+			listeners = append(listeners, func() { g.receivePackets(conn) })
+		case "tcp":
+			ln, err := net.Listen("tcp", u.Host)
+			if err != nil {
+				return err
+			}
+			listeners = append(listeners, func() { g.accept(ln) })
+		default:
+			return fmt.Errorf("GELF listen address must be udp:// or tcp://, not %q", addr)
+		}
+	}
+	attacher.AddPump(g.pump)
+	for _, listen := range listeners {
+		go listen()
+	}
+	go g.expire()
+	return nil
+}
+
+func (g *gelf) receivePackets(conn net.PacketConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			logging.Logger("inputs").Error("GELF receive failed", "addr", conn.LocalAddr(), "err", err)
+			return
+		}
+		packet := append([]byte(nil), buf[:n]...)
+		if message := g.reassemble(packet); message != nil {
+			g.handle(message, addr)
+		}
+	}
+}
+
+func (g *gelf) accept(ln net.Listener) {
+	serveStreams(ln, "GELF", func(conn net.Conn) {
+		rd := bufio.NewReader(conn)
+		for {
+			message, err := readGELFFrame(rd)
+			if len(message) > 0 {
+				g.handle(message, conn.RemoteAddr())
+			}
+			if err != nil {
+				if err != io.EOF {
+					logging.Logger("inputs").Debug("GELF read failed", "client", conn.RemoteAddr(), "err", err)
+				}
+				return
+			}
+		}
+	})
+}
+
+// reads a message up to the null byte after it, failing on those longer
+// than maxGELFMessage
+func readGELFFrame(rd *bufio.Reader) ([]byte, error) {
+	message, err := readFrame(rd, 0, maxGELFMessage)
+	return bytes.TrimSuffix(message, []byte{0}), err
+}
+
+// returns the message a packet is, or completes, or nil while the rest of
+// its chunks are yet to come
+func (g *gelf) reassemble(packet []byte) []byte {
+	if len(packet) < 12 || packet[0] != 0x1e || packet[1] != 0x0f {
+		return packet
+	}
+	id, seq, count := string(packet[2:10]), int(packet[10]), int(packet[11])
+	if count == 0 || count > maxGELFChunks || seq >= count {
+		return nil
+	}
+	g.Lock()
+	defer g.Unlock()
+	message, ok := g.chunked[id]
+	if !ok {
+		message = &gelfMessage{chunks: make([][]byte, count), started: time.Now()}
+		g.chunked[id] = message
+	}
+	if len(message.chunks) != count || message.chunks[seq] != nil {
+		return nil
+	}
+	message.chunks[seq] = packet[12:]
+	message.received++
+	if message.received < count {
+		return nil
+	}
+	delete(g.chunked, id)
+	return bytes.Join(message.chunks, nil)
+}
+
+// drops messages whose chunks didn't all arrive
+func (g *gelf) expire() {
+	for range time.Tick(gelfChunkTimeout) {
+		g.Lock()
+		for id, message := range g.chunked {
+			if time.Since(message.started) > gelfChunkTimeout {
+				delete(g.chunked, id)
+			}
+		}
+		g.Unlock()
+	}
+}
+
+// decompresses and decodes a message and publishes it
+func (g *gelf) handle(message []byte, addr net.Addr) {
+	var rd io.Reader = bytes.NewReader(message)
+	var err error
+	switch {
+	case len(message) > 1 && message[0] == 0x1f && message[1] == 0x8b:
+		rd, err = gzip.NewReader(rd)
+	case len(message) > 0 && message[0] == 0x78:
+		rd, err = zlib.NewReader(rd)
+	}
+	if err != nil {
+		return
+	}
+	var doc map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(rd, maxGELFMessage)).Decode(&doc); err != nil {
+		logging.Logger("inputs").Debug("invalid GELF message", "client", addr, "err", err)
+		return
+	}
+	if logline, pump := g.line(doc, addr); logline != nil {
+		pump.Inject(logline)
+	}
+}
+
+// returns the line for a message and the pump to publish it on, or nil for
+// messages from containers logspout is attached to, which it has already
+func (g *gelf) line(doc map[string]interface{}, addr net.Addr) (*attach.Log, *attach.LogPump) {
+	short, _ := doc["short_message"].(string)
+	now := time.Now().UTC()
+	logline := &attach.Log{
+		ID:        gelfName,
+		Name:      gelfName,
+		Image:     gelfName,
+		Type:      gelfName,
+		Data:      short,
+		Time:      now,
+		Timestamp: now,
+		Fields:    make(map[string]interface{}),
+	}
+	if seconds, ok := doc["timestamp"].(float64); ok {
+		whole, frac := math.Modf(seconds)
+		logline.Timestamp = time.Unix(int64(whole), int64(frac*1e9)).UTC()
+	}
+	if host, ok := doc["host"].(string); ok && host != "" {
+		logline.Fields["host"] = host
+	} else if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		logline.Fields["host"] = host
+	}
+	if full, ok := doc["full_message"].(string); ok && full != "" {
+		logline.Fields["full_message"] = full
+	}
+	for key, value := range doc {
+		if field, ok := strings.CutPrefix(key, "_"); ok && field != "id" {
+			logline.Fields[field] = value
+		}
+	}
+	level, hasLevel := doc["level"].(float64)
+	id, _ := doc["_container_id"].(string)
+	if id == "" {
+		if hasLevel && level >= 0 && level < 8 {
+			logline.Severity = attach.PrioritySeverity(int(level))
+		}
+		return logline, g.pump
+	}
+	// Docker's gelf driver sends stdout at level 6 and stderr at 3, rather
+	// than the line's severity
+	logline.Type = "stdout"
+	if hasLevel && level == 3 {
+		logline.Type = "stderr"
+	}
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	name, _ := doc["_container_name"].(string)
+	image, _ := doc["_image_name"].(string)
+	for _, field := range []string{"container_id", "container_name", "image_name", "image_id"} {
+		delete(logline.Fields, field)
+	}
+	logline.ID, logline.Name, logline.Image = id, name, image
+	pump := g.containers.Get(id, name, image, nil)
+	if pump == nil {
+		return nil, nil
+	}
+	return logline, pump
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package inputs
+
+import (
+	"os"
+	"syscall"
+)
+
+// returns the inode of a file, 0 if it's unknown
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,236 @@
+package inputs
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// name and stream type of the pump journal entries are published on
+const journalName = "journald"
+
+// how often the journal cursor is saved, and how long to wait before
+// restarting journalctl after it exits
+const (
+	journalCursorInterval = time.Second
+	journalRestartDelay   = 5 * time.Second
+)
+
+// JournalConfig configures the journald input
+type JournalConfig struct {
+	// journalctl binary, found on the PATH if it has no slash
+	Journalctl string
+	// journal directory to read rather than the system journal, for a
+	// host journal mounted into the container
+	Directory string
+	// file the position in the journal is saved to, so entries aren't
+	// shipped twice or missed across restarts. Empty starts at the end of
+	// the journal every time.
+	CursorFile string
+	// glob patterns of the units entries are shipped from, with kernel for
+	// kernel messages. Empty ships every entry.
+	Units []string
+}
+
+// journal reads the systemd journal with journalctl and publishes its
+// entries as the logs of a journald pump
+type journal struct {
+	config JournalConfig
+	pump   *attach.LogPump
+
+	sync.Mutex
+	cursor string
+	saved  string
+}
+
+// starts reading the journal, following it from the saved cursor or its end
+func ReadJournal(config JournalConfig, attacher *attach.AttachManager) error {
+	if config.Journalctl == "" {
+		config.Journalctl = "journalctl"
+	}
+	if _, err := exec.LookPath(config.Journalctl); err != nil {
+		return err
+	}
+	for _, unit := range config.Units {
+		if _, err := path.Match(unit, ""); err != nil {
+			return err
+		}
+	}
+	j := &journal{config: config, pump: attach.NewInputPump(journalName, journalName, journalName, nil)}
+	if config.CursorFile != "" {
+		cursor, err := os.ReadFile(config.CursorFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		j.cursor = strings.TrimSpace(string(cursor))
+		j.saved = j.cursor
+	}
+	attacher.AddPump(j.pump)
+	go j.run()
+	if config.CursorFile != "" {
+		go j.saveCursor()
+	}
+	return nil
+}
+
+func (j *journal) run() {
+	for {
+		if err := j.follow(); err != nil {
+			logging.Logger("inputs").Error("journalctl failed", "err", err)
+		}
+		time.Sleep(journalRestartDelay)
+	}
+}
+
+// runs journalctl until it exits, publishing the entries it outputs
+func (j *journal) follow() error {
+	args := []string{"--follow", "--output=json", "--all"}
+	if j.config.Directory != "" {
+		args = append(args, "--directory="+j.config.Directory)
+	}
+	j.Lock()
+	if j.cursor != "" {
+		args = append(args, "--after-cursor="+j.cursor)
+	} else {
+		args = append(args, "--lines=0")
+	}
+	j.Unlock()
+	cmd := exec.Command(j.config.Journalctl, args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	rd := bufio.NewReaderSize(stdout, 64*1024)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		if logline := j.entryLine(entry); logline != nil {
+			j.pump.Inject(logline)
+		}
+		if cursor, ok := entry["__CURSOR"].(string); ok {
+			j.Lock()
+			j.cursor = cursor
+			j.Unlock()
+		}
+	}
+	return cmd.Wait()
+}
+
+// returns the line for a journal entry, or nil if its unit isn't shipped
+func (j *journal) entryLine(entry map[string]interface{}) *attach.Log {
+	unit := journalField(entry, "_SYSTEMD_UNIT")
+	if journalField(entry, "_TRANSPORT") == "kernel" {
+		unit = "kernel"
+	}
+	if len(j.config.Units) > 0 && !matchesAny(j.config.Units, unit) {
+		return nil
+	}
+	now := time.Now().UTC()
+	logline := &attach.Log{
+		ID:        journalName,
+		Name:      journalName,
+		Image:     journalName,
+		Type:      journalName,
+		Data:      journalField(entry, "MESSAGE"),
+		Time:      now,
+		Timestamp: now,
+		Fields:    make(map[string]interface{}),
+	}
+	if usec, err := strconv.ParseInt(journalField(entry, "__REALTIME_TIMESTAMP"), 10, 64); err == nil {
+		logline.Timestamp = time.UnixMicro(usec).UTC()
+	}
+	if priority, err := strconv.Atoi(journalField(entry, "PRIORITY")); err == nil && priority >= 0 {
+		logline.Severity = attach.PrioritySeverity(priority)
+	}
+	for field, key := range map[string]string{"unit": "_SYSTEMD_UNIT", "host": "_HOSTNAME",
+		"app": "SYSLOG_IDENTIFIER", "pid": "_PID", "transport": "_TRANSPORT"} {
+		if value := journalField(entry, key); value != "" {
+			logline.Fields[field] = value
+		}
+	}
+	if unit == "kernel" {
+		logline.Fields["unit"] = unit
+	}
+	return logline
+}
+
+// returns a journal field as text. journalctl outputs fields that aren't
+// valid UTF-8 as arrays of bytes.
+func journalField(entry map[string]interface{}, key string) string {
+	switch value := entry[key].(type) {
+	case string:
+		return value
+	case []interface{}:
+		data := make([]byte, 0, len(value))
+		for _, b := range value {
+			if n, ok := b.(float64); ok {
+				data = append(data, byte(n))
+			}
+		}
+		return string(data)
+	}
+	return ""
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// writes the cursor to the cursor file whenever it's moved on
+func (j *journal) saveCursor() {
+	for range time.Tick(journalCursorInterval) {
+		j.Lock()
+		cursor := j.cursor
+		j.Unlock()
+		if cursor == j.saved {
+			continue
+		}
+		if err := writeFileAtomic(j.config.CursorFile, []byte(cursor+"\n")); err != nil {
+			logging.Logger("inputs").Error("saving journal cursor failed", "file", j.config.CursorFile, "err", err)
+			continue
+		}
+		j.saved = cursor
+	}
+}
+
+// replaces a file's contents, so a crash leaves either the old or the new
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
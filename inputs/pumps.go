@@ -0,0 +1,85 @@
+package inputs
+
+import (
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// how long a container's pump lasts without lines
+const containerPumpIdle = 10 * time.Minute
+
+// ContainerPumps are pumps for the lines of containers logspout isn't
+// attached to, like those using another log driver, on other hosts or
+// posted to the API, created as their first lines arrive and removed once
+// they go quiet. They let routes select those containers' lines by name,
+// image and labels like any others.
+type ContainerPumps struct {
+	attacher *attach.AttachManager
+	max      int
+
+	sync.Mutex
+	pumps map[string]*containerPump
+}
+
+type containerPump struct {
+	pump     *attach.LogPump
+	lastSeen time.Time
+}
+
+// returns pumps for containers' lines on attacher, up to max of them at
+// once unless it's 0
+func NewContainerPumps(attacher *attach.AttachManager, max int) *ContainerPumps {
+	p := &ContainerPumps{attacher: attacher, max: max, pumps: make(map[string]*containerPump)}
+	go p.expire()
+	return p
+}
+
+// returns the pump with id for a container's lines, creating it the first
+// time, and again if the container's name, image or labels have changed,
+// or nil if logspout is attached to the container and so has its lines
+// already, or if there are max pumps
+func (p *ContainerPumps) Get(id, name, image string, labels map[string]string) *attach.LogPump {
+	p.Lock()
+	defer p.Unlock()
+	if container, ok := p.pumps[id]; ok {
+		pump := container.pump
+		if pump.Name == name && pump.Image == image && maps.Equal(pump.Labels, labels) {
+			container.lastSeen = time.Now()
+			return pump
+		}
+		// pumps' names, images and labels are fixed when they're added, as
+		// routes select them by those
+		delete(p.pumps, id)
+		p.attacher.RemovePump(id)
+	} else if p.attacher.Get(id) != nil {
+		return nil
+	}
+	if p.max > 0 && len(p.pumps) >= p.max {
+		return nil
+	}
+	pump := attach.NewInputPump(id, name, image, labels)
+	p.pumps[id] = &containerPump{pump: pump, lastSeen: time.Now()}
+	p.attacher.AddPump(pump)
+	return pump
+}
+
+// removes the pumps of containers that have stopped sending lines
+func (p *ContainerPumps) expire() {
+	for range time.Tick(containerPumpIdle / 10) {
+		var idle []string
+		p.Lock()
+		for id, container := range p.pumps {
+			if time.Since(container.lastSeen) > containerPumpIdle {
+				idle = append(idle, id)
+				delete(p.pumps, id)
+			}
+		}
+		p.Unlock()
+		for _, id := range idle {
+			p.attacher.RemovePump(id)
+		}
+	}
+}
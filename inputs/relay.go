@@ -0,0 +1,126 @@
+package inputs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+const (
+	// default port of the relay input
+	RelayPort = "8010"
+	// what relay connections start with, naming the protocol and its
+	// version, for frames of JSON lines and of length-prefixed protobuf
+	// messages, see attach.EncodeProto
+	RelayHello         = "LSRELAY1"
+	RelayProtobufHello = "LSRELPB1"
+	// largest frame accepted
+	maxRelayFrame = 16 << 20
+)
+
+// starts receiving lines from other logspouts' relay targets on addr,
+// securing connections with config unless it's nil, and publishes them as
+// the logs of their containers, see ContainerPumps, with the sender's
+// address before their IDs. Each frame of lines is
+// acknowledged once they've been published.
+func ListenRelay(addr string, config *tls.Config, attacher *attach.AttachManager) error {
+	var ln net.Listener
+	var err error
+	if config != nil {
+		ln, err = tls.Listen("tcp", addr, config)
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	containers := NewContainerPumps(attacher, 0)
+	go serveStreams(ln, "relay", func(conn net.Conn) {
+		if err := receiveRelay(conn, containers); err != nil && err != io.EOF {
+			logging.Logger("inputs").Warn("relay connection failed", "client", conn.RemoteAddr(), "err", err)
+		}
+	})
+	return nil
+}
+
+// reads frames until the sender closes the connection, see the relay
+// target's sendFrame
+func receiveRelay(conn net.Conn, containers *ContainerPumps) error {
+	rd := bufio.NewReaderSize(conn, 64*1024)
+	hello := make([]byte, len(RelayHello))
+	if _, err := io.ReadFull(rd, hello); err != nil {
+		return err
+	}
+	if string(hello) != RelayHello && string(hello) != RelayProtobufHello {
+		return fmt.Errorf("not a relay connection")
+	}
+	decode := decodeJSONFrame
+	if string(hello) == RelayProtobufHello {
+		decode = decodeProtobufFrame
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		host = conn.RemoteAddr().String()
+	}
+	var header [12]byte
+	for {
+		if _, err := io.ReadFull(rd, header[:]); err != nil {
+			return err
+		}
+		length := binary.BigEndian.Uint32(header[8:])
+		if length > maxRelayFrame {
+			return fmt.Errorf("frame of %d bytes is too large", length)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(rd, payload); err != nil {
+			return err
+		}
+		err := decode(payload, func(logline *attach.Log) {
+			// edges can have containers and inputs with the same IDs as
+			// each other's and this logspout's
+			if pump := containers.Get(host+"/"+logline.ID, logline.Name, logline.Image, nil); pump != nil {
+				pump.Inject(logline)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(header[:8]); err != nil {
+			return err
+		}
+	}
+}
+
+// calls publish with each line of a frame of JSON lines
+func decodeJSONFrame(payload []byte, publish func(*attach.Log)) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	for decoder.More() {
+		logline := new(attach.Log)
+		if err := decoder.Decode(logline); err != nil {
+			return err
+		}
+		publish(logline)
+	}
+	return nil
+}
+
+// calls publish with each line of a frame of protobuf messages
+func decodeProtobufFrame(payload []byte, publish func(*attach.Log)) error {
+	for len(payload) > 0 {
+		logline, rest, err := attach.DecodeProto(payload)
+		if err != nil {
+			return err
+		}
+		publish(logline)
+		payload = rest
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package inputs
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+func TestRelayedLineProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(path, []byte(`[{"name":"nginx","image":"^nginx:","severity":[{"pattern":"upstream","severity":"crit"}]}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := attach.LoadProfiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(profiles []*attach.Profile) { attach.Profiles = profiles }(attach.Profiles)
+	attach.Profiles = profiles
+
+	containers := NewContainerPumps(attach.NewInputManager(), 0)
+	// the relay connection is a pipe, whose address has no port
+	pump := containers.Get("pipe/abc", "web", "nginx:1.27", nil)
+	logs := make(chan *attach.Log, 1)
+	pump.AddListener(logs, &attach.Source{})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	errs := make(chan error, 1)
+	go func() { errs <- receiveRelay(server, containers) }()
+	payload := []byte(`{"id":"abc","name":"web","image":"nginx:1.27","type":"stdout","data":"upstream timed out"}` + "\n")
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(payload)))
+	data := append([]byte(RelayHello), header...)
+	if _, err := client.Write(append(data, payload...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 8)); err != nil {
+		t.Fatalf("reading the acknowledgement: %v", err)
+	}
+	client.Close()
+	if err := <-errs; err != io.EOF {
+		t.Fatalf("got %v receiving", err)
+	}
+
+	select {
+	case logline := <-logs:
+		if logline.Profile == nil || logline.Profile.Name != "nginx" {
+			t.Errorf("got profile %+v, want nginx's", logline.Profile)
+		}
+		if logline.Severity != "crit" {
+			t.Errorf("got severity %q, want crit", logline.Severity)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no line was published")
+	}
+}
@@ -0,0 +1,260 @@
+// Package inputs feeds lines from outside Docker, like syslog messages, into
+// logspout's routes. Each input publishes its lines as the logs of a pump,
+// so routes and streams select them like any container's.
+package inputs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// name and stream type of the pump syslog messages are published on
+const syslogName = "syslog"
+
+// largest syslog message read, over UDP or TCP
+const maxSyslogMessage = 64 * 1024
+
+// most digits the octet count of a TCP frame has, enough for
+// maxSyslogMessage
+const maxSyslogLengthDigits = 5
+
+var facilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+// starts receiving syslog messages on addrs, each udp://host:port or
+// tcp://host:port, and publishes them as the logs of a syslog pump. Lines
+// carry the sending host in a host field, and the app, pid, msgid and
+// facility when the message states them.
+func ListenSyslog(addrs []string, attacher *attach.AttachManager) error {
+	pump := attach.NewInputPump(syslogName, syslogName, syslogName, nil)
+	var listeners []func()
+	for _, addr := range addrs {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return err
+		}
+		switch u.Scheme {
+		case "udp":
+			conn, err := net.ListenPacket("udp", u.Host)
+			if err != nil {
+				return err
+			}
+			listeners = append(listeners, func() { receiveSyslogPackets(conn, pump) })
+		case "tcp":
+			ln, err := net.Listen("tcp", u.Host)
+			if err != nil {
+				return err
+			}
+			listeners = append(listeners, func() {
+				serveStreams(ln, "syslog", func(conn net.Conn) { receiveSyslogStream(conn, pump) })
+			})
+		default:
+			return fmt.Errorf("syslog listen address must be udp:// or tcp://, not %q", addr)
+		}
+	}
+	attacher.AddPump(pump)
+	for _, listen := range listeners {
+		go listen()
+	}
+	return nil
+}
+
+func receiveSyslogPackets(conn net.PacketConn, pump *attach.LogPump) {
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			logging.Logger("inputs").Error("syslog receive failed", "addr", conn.LocalAddr(), "err", err)
+			return
+		}
+		pump.Inject(syslogLine(string(buf[:n]), addr))
+	}
+}
+
+// reads messages framed by octet counting or newlines, as RFC 6587
+// describes, until the sender closes the connection
+func receiveSyslogStream(conn net.Conn, pump *attach.LogPump) {
+	rd := bufio.NewReaderSize(conn, maxSyslogMessage)
+	for {
+		msg, err := readSyslogFrame(rd)
+		if err != nil {
+			if err != io.EOF {
+				logging.Logger("inputs").Debug("syslog read failed", "client", conn.RemoteAddr(), "err", err)
+			}
+			return
+		}
+		if msg != "" {
+			pump.Inject(syslogLine(msg, conn.RemoteAddr()))
+		}
+	}
+}
+
+// reads a message from a TCP stream, framed by a newline or prefixed by its
+// octet count as in RFC 6587, failing on frames over maxSyslogMessage
+func readSyslogFrame(rd *bufio.Reader) (string, error) {
+	first, err := rd.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		line, err := readFrame(rd, '\n', maxSyslogMessage)
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+	length, err := readFrame(rd, ' ', maxSyslogLengthDigits)
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(string(length), " "))
+	if err != nil || n > maxSyslogMessage {
+		return "", fmt.Errorf("invalid frame length %q", length)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(rd, msg); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(msg), "\r\n"), nil
+}
+
+// returns the line for a message from addr
+func syslogLine(msg string, addr net.Addr) *attach.Log {
+	now := time.Now().UTC()
+	logline := &attach.Log{
+		ID:        syslogName,
+		Name:      syslogName,
+		Image:     syslogName,
+		Type:      syslogName,
+		Data:      msg,
+		Time:      now,
+		Timestamp: now,
+		Fields:    make(map[string]interface{}),
+	}
+	parseSyslog(logline)
+	if _, ok := logline.Fields["host"]; !ok {
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			host = addr.String()
+		}
+		logline.Fields["host"] = host
+	}
+	return logline
+}
+
+// parses an RFC 5424 or RFC 3164 message in the line's data, leaving the
+// data as the message and setting the line's time, severity and fields from
+// the header. Messages without a priority are left as they are.
+func parseSyslog(logline *attach.Log) {
+	msg := logline.Data
+	if !strings.HasPrefix(msg, "<") {
+		return
+	}
+	end := strings.IndexByte(msg, '>')
+	if end < 2 || end > 4 {
+		return
+	}
+	priority, err := strconv.Atoi(msg[1:end])
+	if err != nil || priority < 0 || priority >= len(facilities)*8 {
+		return
+	}
+	logline.Severity = attach.PrioritySeverity(priority)
+	logline.Fields["facility"] = facilities[priority/8]
+	msg = msg[end+1:]
+	if rest, ok := strings.CutPrefix(msg, "1 "); ok {
+		logline.Data = parseRFC5424(logline, rest)
+	} else {
+		logline.Data = parseRFC3164(logline, msg)
+	}
+}
+
+// parses the header after the version, returning the message
+func parseRFC5424(logline *attach.Log, header string) string {
+	parts := strings.SplitN(header, " ", 6)
+	if len(parts) < 5 {
+		return header
+	}
+	if t, err := time.Parse(time.RFC3339Nano, parts[0]); err == nil {
+		logline.Timestamp = t.UTC()
+	}
+	for i, field := range []string{"host", "app", "pid", "msgid"} {
+		if parts[i+1] != "-" {
+			logline.Fields[field] = parts[i+1]
+		}
+	}
+	if len(parts) < 6 {
+		return ""
+	}
+	msg := skipStructuredData(parts[5])
+	// messages may start with a UTF-8 byte order mark
+	return strings.TrimPrefix(strings.TrimPrefix(msg, " "), "\ufeff")
+}
+
+// returns what follows the structured data at the start of s
+func skipStructuredData(s string) string {
+	if strings.HasPrefix(s, "-") {
+		return s[1:]
+	}
+	for strings.HasPrefix(s, "[") {
+		i := 1
+		for i < len(s) && s[i] != ']' {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				// values can contain ] unescaped within quotes
+				for i++; i < len(s) && s[i] != '"'; i++ {
+					if s[i] == '\\' {
+						i++
+					}
+				}
+			}
+			i++
+		}
+		if i >= len(s) {
+			return ""
+		}
+		s = s[i+1:]
+	}
+	return s
+}
+
+// parses a BSD syslog header, returning the message
+func parseRFC3164(logline *attach.Log, msg string) string {
+	if len(msg) >= len(time.Stamp) {
+		if t, err := time.ParseInLocation(time.Stamp, msg[:len(time.Stamp)], time.Local); err == nil {
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			// messages from late December arriving in January
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			logline.Timestamp = t.UTC()
+			msg = strings.TrimPrefix(msg[len(time.Stamp):], " ")
+			if host, rest, ok := strings.Cut(msg, " "); ok && host != "" {
+				logline.Fields["host"] = host
+				msg = rest
+			}
+		}
+	}
+	tag, rest, ok := strings.Cut(msg, ": ")
+	if !ok || tag == "" || strings.ContainsAny(tag, " \t") {
+		return msg
+	}
+	if app, pid, ok := strings.Cut(strings.TrimSuffix(tag, "]"), "["); ok {
+		logline.Fields["app"] = app
+		logline.Fields["pid"] = pid
+	} else {
+		logline.Fields["app"] = tag
+	}
+	return rest
+}
@@ -0,0 +1,180 @@
+package inputs
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+func TestParseSyslog(t *testing.T) {
+	tests := []struct {
+		msg      string
+		data     string
+		severity string
+		fields   map[string]interface{}
+		stamp    string
+	}{
+		{
+			msg:      `<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed for lonvick on /dev/pts/8`,
+			data:     `'su root' failed for lonvick on /dev/pts/8`,
+			severity: "crit",
+			fields:   map[string]interface{}{"facility": "auth", "host": "mymachine.example.com", "app": "su", "msgid": "ID47"},
+			stamp:    "2003-10-11T22:14:15.003Z",
+		},
+		{
+			msg:      "<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - \ufeff%% It's time to make the do-nuts.",
+			data:     "%% It's time to make the do-nuts.",
+			severity: "notice",
+			fields:   map[string]interface{}{"facility": "local4", "host": "192.0.2.1", "app": "myproc", "pid": "8710"},
+			stamp:    "2003-08-24T12:14:15.000003Z",
+		},
+		{
+			msg:      `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high"] An application event`,
+			data:     "An application event",
+			severity: "notice",
+			fields:   map[string]interface{}{"facility": "local4", "host": "mymachine.example.com", "app": "evntslog", "msgid": "ID47"},
+			stamp:    "2003-10-11T22:14:15.003Z",
+		},
+		{
+			// no message, and nil values
+			msg:      `<14>1 - - - - -`,
+			data:     "",
+			severity: "info",
+			fields:   map[string]interface{}{"facility": "user"},
+		},
+		{
+			msg:      `<13>1 2003-10-11T22:14:15Z host app - - [meta x="a]b"]`,
+			data:     "",
+			severity: "notice",
+			fields:   map[string]interface{}{"facility": "user", "host": "host", "app": "app"},
+			stamp:    "2003-10-11T22:14:15Z",
+		},
+		{
+			// too few header fields to be RFC 5424
+			msg:      `<13>1 truncated header`,
+			data:     "truncated header",
+			severity: "notice",
+			fields:   map[string]interface{}{"facility": "user"},
+		},
+		{
+			// RFC 3164 without a timestamp
+			msg:      `<30>sshd[1234]: Accepted publickey for root`,
+			data:     "Accepted publickey for root",
+			severity: "info",
+			fields:   map[string]interface{}{"facility": "daemon", "app": "sshd", "pid": "1234"},
+		},
+		{
+			msg:      `<30>cron: job started`,
+			data:     "job started",
+			severity: "info",
+			fields:   map[string]interface{}{"facility": "daemon", "app": "cron"},
+		},
+		{
+			// a colon that isn't after a tag
+			msg:      `<30>no tag here: at all`,
+			data:     "no tag here: at all",
+			severity: "info",
+			fields:   map[string]interface{}{"facility": "daemon"},
+		},
+		{
+			msg:    `no priority`,
+			data:   "no priority",
+			fields: map[string]interface{}{},
+		},
+		{
+			msg:    `<999>out of range`,
+			data:   "<999>out of range",
+			fields: map[string]interface{}{},
+		},
+		{
+			msg:    `<x>not a number`,
+			data:   "<x>not a number",
+			fields: map[string]interface{}{},
+		},
+	}
+	for _, test := range tests {
+		var zero time.Time
+		logline := &attach.Log{Data: test.msg, Fields: make(map[string]interface{})}
+		parseSyslog(logline)
+		if logline.Data != test.data {
+			t.Errorf("%q: got data %q, want %q", test.msg, logline.Data, test.data)
+		}
+		if logline.Severity != test.severity {
+			t.Errorf("%q: got severity %q, want %q", test.msg, logline.Severity, test.severity)
+		}
+		if !reflect.DeepEqual(logline.Fields, test.fields) {
+			t.Errorf("%q: got fields %v, want %v", test.msg, logline.Fields, test.fields)
+		}
+		if test.stamp == "" {
+			if logline.Timestamp != zero {
+				t.Errorf("%q: got timestamp %v, want none", test.msg, logline.Timestamp)
+			}
+		} else if got := logline.Timestamp.Format(time.RFC3339Nano); got != test.stamp {
+			t.Errorf("%q: got timestamp %s, want %s", test.msg, got, test.stamp)
+		}
+	}
+}
+
+func TestParseRFC3164Timestamp(t *testing.T) {
+	// the year is the current one, unless that puts it in the future
+	sent := time.Now().Add(-time.Hour).Truncate(time.Second)
+	logline := &attach.Log{Data: "<13>" + sent.Format(time.Stamp) + " web01 nginx: started", Fields: make(map[string]interface{})}
+	parseSyslog(logline)
+	if !logline.Timestamp.Equal(sent) {
+		t.Errorf("got timestamp %v, want %v", logline.Timestamp, sent)
+	}
+	if logline.Data != "started" || logline.Fields["host"] != "web01" || logline.Fields["app"] != "nginx" {
+		t.Errorf("got data %q and fields %v", logline.Data, logline.Fields)
+	}
+
+	late := time.Date(time.Now().Year(), time.December, 31, 23, 59, 0, 0, time.Local)
+	if time.Now().Before(late.Add(-24 * time.Hour)) {
+		logline = &attach.Log{Data: "<13>" + late.Format(time.Stamp) + " web01 nginx: started", Fields: make(map[string]interface{})}
+		parseSyslog(logline)
+		if want := late.AddDate(-1, 0, 0); !logline.Timestamp.Equal(want) {
+			t.Errorf("got timestamp %v, want last year's %v", logline.Timestamp, want)
+		}
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	rd := bufio.NewReader(strings.NewReader("first line\r\n11 octet\nframe5 third<14>last"))
+	for _, want := range []string{"first line", "octet\nframe", "third", "<14>last"} {
+		msg, err := readSyslogFrame(rd)
+		if err != nil || msg != want {
+			t.Errorf("got %q, %v, want %q", msg, err, want)
+		}
+	}
+	if _, err := readSyslogFrame(rd); err != io.EOF {
+		t.Errorf("got %v at the end, want EOF", err)
+	}
+
+	// frames up to the limit are fine
+	longest := strings.Repeat("x", maxSyslogMessage)
+	if msg, err := readSyslogFrame(bufio.NewReaderSize(strings.NewReader(longest+"\n"), maxSyslogMessage)); err != nil || msg != longest {
+		t.Errorf("longest frame: got %d bytes, %v", len(msg), err)
+	}
+
+	overlong := strings.Repeat("x", maxSyslogMessage+1)
+	for _, stream := range []string{"99999999 too long", "12x bad length", "20 short", overlong + "\n", overlong, strings.Repeat("1", maxSyslogMessage+1)} {
+		if msg, err := readSyslogFrame(bufio.NewReader(strings.NewReader(stream))); err == nil {
+			t.Errorf("%.20q: got %.20q, want an error", stream, msg)
+		}
+	}
+}
+
+func TestSyslogLineHost(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 7), Port: 514}
+	if logline := syslogLine("<13>plain message", addr); logline.Fields["host"] != "192.0.2.7" {
+		t.Errorf("got host %v, want the sender's address", logline.Fields["host"])
+	}
+	if logline := syslogLine("<13>1 - named app - - - hi", addr); logline.Fields["host"] != "named" {
+		t.Errorf("got host %v, want the message's", logline.Fields["host"])
+	}
+}
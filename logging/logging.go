@@ -0,0 +1,158 @@
+// Package logging provides the leveled per-subsystem loggers logspout
+// writes its own logs with.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// state holds the handler the loggers of every subsystem write to
+var state = struct {
+	sync.Mutex
+	handler slog.Handler
+	// handlers records are also sent to, see AddHandler
+	extra   []slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+	loggers map[string]*slog.Logger
+}{
+	handler: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	loggers: make(map[string]*slog.Logger),
+}
+
+// configures logging from LOG_FORMAT (text or json), LOG_LEVEL and
+// LOG_LEVELS, a comma-separated list of subsystem=level overrides
+func Setup(format, level, levels string) error {
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("LOG_FORMAT must be text or json, not %q", format)
+	}
+	var defaultLevel slog.Level
+	if err := defaultLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("LOG_LEVEL: %s", err)
+	}
+	overrides := make(map[string]slog.Level)
+	for _, pair := range strings.Split(levels, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		subsystem, value, ok := strings.Cut(pair, "=")
+		var level slog.Level
+		if !ok || level.UnmarshalText([]byte(value)) != nil {
+			return fmt.Errorf("LOG_LEVELS: want subsystem=level, not %q", pair)
+		}
+		overrides[subsystem] = level
+	}
+	state.Lock()
+	if len(state.extra) > 0 {
+		handler = append(teeHandler{handler}, state.extra...)
+	}
+	state.handler, state.level, state.levels = handler, defaultLevel, overrides
+	clear(state.loggers)
+	state.Unlock()
+	// lines from the standard log package, like net/http's, come through
+	// as info
+	slog.SetDefault(Logger("main"))
+	return nil
+}
+
+// sends records to handler as well, for loggers returned from now on
+func AddHandler(handler slog.Handler) {
+	state.Lock()
+	state.extra = append(state.extra, handler)
+	state.handler = teeHandler{state.handler, handler}
+	clear(state.loggers)
+	state.Unlock()
+	slog.SetDefault(Logger("main"))
+}
+
+// returns the logger for a subsystem, which tags its records with it
+func Logger(subsystem string) *slog.Logger {
+	state.Lock()
+	defer state.Unlock()
+	if l, ok := state.loggers[subsystem]; ok {
+		return l
+	}
+	level, ok := state.levels[subsystem]
+	if !ok {
+		level = state.level
+	}
+	handler := levelHandler{state.handler.WithAttrs([]slog.Attr{slog.String("subsystem", subsystem)}), level}
+	l := slog.New(handler)
+	state.loggers[subsystem] = l
+	return l
+}
+
+// reports whether a subsystem logs at debug level
+func Debugging(subsystem string) bool {
+	return Logger(subsystem).Enabled(context.Background(), slog.LevelDebug)
+}
+
+// levelHandler passes on records at or above its level
+type levelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{h.Handler.WithGroup(name), h.level}
+}
+
+// teeHandler passes records on to each of its handlers that wants them
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var err error
+	for _, h := range t {
+		if h.Enabled(ctx, record.Level) {
+			if herr := h.Handle(ctx, record.Clone()); herr != nil {
+				err = herr
+			}
+		}
+	}
+	return err
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
@@ -0,0 +1,82 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// Adapter ships the logs of a route to its target. Stream returns once
+// logstream is closed and everything received has been sent or dropped.
+type Adapter interface {
+	Stream(logstream chan *attach.Log)
+}
+
+// AdapterType describes an adapter for routes with a target type, which is
+// also the scheme of route URIs like syslog://host:514
+type AdapterType struct {
+	// returns an adapter for a validated route, connected to its target or
+	// ready to connect
+	New func(route *Route) (Adapter, error)
+	// port used for target addresses that don't have one
+	DefaultPort string
+	// checks the addresses of targets that aren't host:port lists, such as
+	// s3's bucket/prefix, in place of Target.HostPorts
+	ValidateAddr func(addr string) error
+	// parsers used when a route doesn't list any
+	DefaultParsers []string
+	// compression the adapter supports, see Target.Compression
+	Compressions []string
+	// encodings the adapter can send lines in besides JSON, see
+	// Target.Encoding
+	Encodings []string
+	// whether the adapter signs what it sends when the target sets sign,
+	// see SignConfig
+	Signs bool
+	// whether the adapter's JSON lines honor the target's schema,
+	// field_names and field_case, see Target.LineEncoder
+	ShapesJSON bool
+	// key of the target options the adapter uses, which other adapters'
+	// targets can't set, see RegisterTargetOptions
+	Options string
+	// whether the adapter batches lines itself, by the target's batch
+	// max_bytes and max_latency only, so max_events isn't allowed
+	BatchesByBytes bool
+	// reads back the lines a route archived, for target types that archive,
+	// see Route.Replay
+	Replay func(ctx context.Context, route *Route, since, until time.Time, emit func(*attach.Log)) error
+}
+
+// registered adapter types by target type
+var adapters = make(map[string]AdapterType)
+
+// registers an adapter type for targets of type scheme. Adapters register
+// from init functions, so extra ones can be built in by adding files.
+func RegisterAdapter(scheme string, adapter AdapterType) {
+	if _, exists := adapters[scheme]; exists {
+		panic("adapter " + scheme + " registered twice")
+	}
+	adapters[scheme] = adapter
+}
+
+// returns the names of the registered adapter types, sorted
+func AdapterTypes() []string {
+	var names []string
+	for name := range adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returns a new adapter for the route's target type
+func newAdapter(route *Route) (Adapter, error) {
+	adapter, ok := adapters[route.Target.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown target type %q", route.Target.Type)
+	}
+	return adapter.New(route)
+}
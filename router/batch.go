@@ -0,0 +1,171 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// BatchConfig says how many lines adapters send at a time. A batch is sent
+// once it has MaxEvents lines, would grow past MaxBytes, or its first line
+// has waited MaxLatency.
+type BatchConfig struct {
+	MaxEvents  int    `json:"max_events,omitempty"`
+	MaxBytes   int    `json:"max_bytes,omitempty"`
+	MaxLatency string `json:"max_latency,omitempty"`
+
+	latency time.Duration
+}
+
+// returns how long a batch's first line may wait, MaxLatency parsed
+func (c BatchConfig) Latency() time.Duration {
+	return c.latency
+}
+
+// batching used by targets that don't configure it
+var defaultBatch = BatchConfig{MaxEvents: 100, MaxBytes: 1 << 20, MaxLatency: "100ms"}
+
+// fills in unset limits from defaultBatch and checks the latency parses
+func (c *BatchConfig) normalize() error {
+	if c.MaxEvents <= 0 {
+		c.MaxEvents = defaultBatch.MaxEvents
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = defaultBatch.MaxBytes
+	}
+	if c.MaxLatency == "" {
+		c.MaxLatency = defaultBatch.MaxLatency
+	}
+	latency, err := time.ParseDuration(c.MaxLatency)
+	if err != nil {
+		return fmt.Errorf("batch max_latency: %s", err)
+	}
+	c.latency = latency
+	return nil
+}
+
+// most sender workers a route can run
+const maxWorkers = 64
+
+// BatchItem is a processed line along with its encoding for the target.
+// Items come from itemPool and go back to it once their batch is sent.
+type BatchItem struct {
+	Log *attach.Log
+	Buf *bytes.Buffer
+}
+
+// collects items into batches and hands each to send, until items is
+// closed. Whatever is left is sent before returning.
+func runBatches(items <-chan *BatchItem, config BatchConfig, send func([]*BatchItem)) {
+	var batch []*BatchItem
+	size := 0
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	flush := func() {
+		// a timer that fired unread would flush the next batch early
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if len(batch) > 0 {
+			send(batch)
+		}
+		batch, size = nil, 0
+	}
+	for {
+		select {
+		case item, ok := <-items:
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) > 0 && size+item.Buf.Len() > config.MaxBytes {
+				flush()
+			}
+			batch = append(batch, item)
+			size += item.Buf.Len()
+			if len(batch) >= config.MaxEvents || config.latency <= 0 {
+				flush()
+			} else if len(batch) == 1 {
+				timer.Reset(config.latency)
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// batches items by the route's batch settings and sends the batches from
+// the target's workers goroutines in parallel, each with its own send func
+// from newSender along with a func to clean it up. How long each send takes
+// is recorded in the route's status. Returns once items is closed and every
+// batch has been sent.
+func (r *Route) RunWorkers(items <-chan *BatchItem, newSender func() (func([]*BatchItem), func())) {
+	workers := r.Target.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	batches := make(chan []*BatchItem, workers)
+	// a batch being sent and one waiting per worker
+	r.status.SetQueueCapacity(2*workers*r.batch.MaxEvents, 2*workers*r.batch.MaxBytes)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send, done := newSender()
+			defer done()
+			for batch := range batches {
+				start := time.Now()
+				send(batch)
+				r.status.SendDuration(time.Since(start))
+				r.status.Dequeued(len(batch), batchBytes(batch))
+				putBatch(batch)
+			}
+		}()
+	}
+	runBatches(items, r.batch, func(batch []*BatchItem) {
+		r.status.Queued(len(batch), batchBytes(batch))
+		batches <- batch
+	})
+	close(batches)
+	wg.Wait()
+}
+
+// returns the encoded size of a batch's items
+func batchBytes(batch []*BatchItem) int {
+	size := 0
+	for _, item := range batch {
+		size += item.Buf.Len()
+	}
+	return size
+}
+
+// processes lines from logstream and encodes them with encode into items
+// for runBatches, closing items once logstream is closed. Lines encode
+// fails for are counted as dropped.
+func (r *Route) EncodeLines(logstream chan *attach.Log, items chan<- *BatchItem, encode func(*attach.Log, *bytes.Buffer) error) {
+	defer close(items)
+	for logline := range logstream {
+		logline, ok := r.Process(logline)
+		if !ok {
+			continue
+		}
+		item := getItem(logline)
+		if err := encode(logline, item.Buf); err != nil {
+			logging.Logger("route").Debug("encoding failed", "route", r.ID, "err", err)
+			r.status.Failed(err)
+			r.status.Dropped("encoding", 1)
+			putBatch([]*BatchItem{item})
+			continue
+		}
+		items <- item
+	}
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunBatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		config BatchConfig
+		items  []string
+		want   []int
+	}{
+		{"max_events", BatchConfig{MaxEvents: 2, MaxBytes: 1000, MaxLatency: "1h"}, []string{"a", "b", "c", "d", "e"}, []int{2, 2, 1}},
+		{"max_bytes", BatchConfig{MaxEvents: 100, MaxBytes: 4, MaxLatency: "1h"}, []string{"ab", "cd", "e", "fghij", "k"}, []int{2, 1, 1, 1}},
+		{"no latency", BatchConfig{MaxEvents: 100, MaxBytes: 1000, MaxLatency: "0s"}, []string{"a", "b"}, []int{1, 1}},
+	}
+	for _, test := range tests {
+		if err := test.config.normalize(); err != nil {
+			t.Fatal(err)
+		}
+		items := make(chan *BatchItem, len(test.items))
+		for _, data := range test.items {
+			items <- &BatchItem{Buf: bytes.NewBufferString(data)}
+		}
+		close(items)
+		var sizes []int
+		runBatches(items, test.config, func(batch []*BatchItem) { sizes = append(sizes, len(batch)) })
+		if !reflect.DeepEqual(sizes, test.want) {
+			t.Errorf("%s: got batches of %v, want %v", test.name, sizes, test.want)
+		}
+	}
+}
+
+// a lone line is sent once it has waited max_latency, and so is the next,
+// rather than straight away
+func TestRunBatchesLatency(t *testing.T) {
+	config := BatchConfig{MaxEvents: 2, MaxBytes: 1000, MaxLatency: "50ms"}
+	if err := config.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	items := make(chan *BatchItem)
+	sent := make(chan int, 10)
+	go runBatches(items, config, func(batch []*BatchItem) { sent <- len(batch) })
+	items <- &BatchItem{Buf: bytes.NewBufferString("a")}
+	if n := <-sent; n != 1 {
+		t.Fatalf("got a batch of %d after max_latency, want 1", n)
+	}
+	start := time.Now()
+	items <- &BatchItem{Buf: bytes.NewBufferString("b")}
+	if n := <-sent; n != 1 || time.Since(start) < 40*time.Millisecond {
+		t.Errorf("got a batch of %d after %s, want 1 after max_latency", n, time.Since(start))
+	}
+	close(items)
+}
+
+func TestValidateMaxEvents(t *testing.T) {
+	adapters["test-bytes"] = AdapterType{BatchesByBytes: true}
+	defer delete(adapters, "test-bytes")
+	route := &Route{Target: Target{Type: "test-bytes", Addr: "host:1", Batch: &BatchConfig{MaxEvents: 10}}}
+	if err := route.Validate(); err == nil || !strings.Contains(err.Error(), "max_events") {
+		t.Errorf("got %v, want max_events rejected", err)
+	}
+	route = &Route{Target: Target{Type: "test-bytes", Addr: "host:1", Batch: &BatchConfig{MaxBytes: 10}}}
+	if err := route.Validate(); err != nil {
+		t.Error(err)
+	}
+}
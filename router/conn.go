@@ -0,0 +1,73 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// ConnConfig tunes the connections adapters make, so hung targets are
+// noticed in seconds. Times are durations, with "0" turning them off.
+type ConnConfig struct {
+	// how long connecting to the target may take
+	DialTimeout string `json:"dial_timeout,omitempty"`
+	// how long sending a batch, or waiting for its response, may take
+	WriteTimeout string `json:"write_timeout,omitempty"`
+	// how often idle TCP connections are probed
+	KeepAlive string `json:"keepalive,omitempty"`
+
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+	keepAlive    time.Duration
+}
+
+// connection tuning used by targets that don't configure it
+var defaultConn = ConnConfig{DialTimeout: "10s", WriteTimeout: "30s", KeepAlive: "15s"}
+
+// fills in unset times from defaultConn and parses them
+func (c *ConnConfig) normalize() error {
+	var err error
+	if c.dialTimeout, err = connDuration("dial_timeout", &c.DialTimeout, defaultConn.DialTimeout); err != nil {
+		return err
+	}
+	if c.writeTimeout, err = connDuration("write_timeout", &c.WriteTimeout, defaultConn.WriteTimeout); err != nil {
+		return err
+	}
+	c.keepAlive, err = connDuration("keepalive", &c.KeepAlive, defaultConn.KeepAlive)
+	return err
+}
+
+func connDuration(name string, value *string, dfault string) (time.Duration, error) {
+	if *value == "" {
+		*value = dfault
+	}
+	d, err := time.ParseDuration(*value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("conn %s: invalid duration %q", name, *value)
+	}
+	return d, nil
+}
+
+// returns a dialer with the configured timeouts
+func (c ConnConfig) Dialer() *net.Dialer {
+	keepAlive := c.keepAlive
+	if keepAlive == 0 {
+		// a zero KeepAlive means the default to net.Dialer
+		keepAlive = -1
+	}
+	return &net.Dialer{Timeout: c.dialTimeout, KeepAlive: keepAlive}
+}
+
+// returns how long a write may take, zero for no limit
+func (c ConnConfig) WriteTimeoutDuration() time.Duration {
+	return c.writeTimeout
+}
+
+// returns when a write started now must be done by, or the zero time for
+// no deadline
+func (c ConnConfig) WriteDeadline() time.Time {
+	if c.writeTimeout == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.writeTimeout)
+}
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"os"
+	"slices"
+)
+
+// returns the files the target reads keys and credentials from: its sign
+// key_file, its options' files, see CredentialOptions, and TLS files. Its
+// JSON doesn't change when they do, so routes are restarted when what they
+// hold does, see RouteManager.Reload.
+func (t Target) credentialFiles() []string {
+	var files []string
+	add := func(file string) {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	if t.Sign != nil {
+		add(t.Sign.KeyFile)
+	}
+	for _, key := range slices.Sorted(maps.Keys(t.options)) {
+		if options, ok := t.options[key].(CredentialOptions); ok {
+			for _, file := range options.CredentialFiles() {
+				add(file)
+			}
+		}
+	}
+	if t.UsesTLS() || t.TLS != nil {
+		tls := t.TLS.withDefaults()
+		add(tls.CA)
+		add(tls.Cert)
+		add(tls.Key)
+	}
+	return files
+}
+
+// returns a digest of what the target's credential files hold, files that
+// can't be read counting as empty
+func (t Target) credentialsDigest() string {
+	files := t.credentialFiles()
+	if len(files) == 0 {
+		return ""
+	}
+	hash := sha256.New()
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		sum := sha256.Sum256(data)
+		hash.Write([]byte(file))
+		hash.Write(sum[:])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,565 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// Expr is a compiled route expression, like
+//
+//	severity == "error" && k8s.namespace != "dev"
+//
+// evaluated against each line. Names are the line's id, name, image, type,
+// data, message, severity and truncated, k8s.namespace, k8s.pod and
+// k8s.name, and fields.<name> for parsed fields. Any other name is a parsed
+// field too, and dots reach into nested ones. Missing names are null.
+type Expr struct {
+	text string
+	root exprNode
+}
+
+type exprNode func(logline *attach.Log) interface{}
+
+// functions expressions can call, by name and number of arguments
+var exprFuncs = map[string]struct {
+	args int
+	call func(args []interface{}) interface{}
+}{
+	"lower":       {1, func(a []interface{}) interface{} { return strings.ToLower(exprString(a[0])) }},
+	"upper":       {1, func(a []interface{}) interface{} { return strings.ToUpper(exprString(a[0])) }},
+	"len":         {1, func(a []interface{}) interface{} { return float64(len(exprString(a[0]))) }},
+	"contains":    {2, func(a []interface{}) interface{} { return strings.Contains(exprString(a[0]), exprString(a[1])) }},
+	"starts_with": {2, func(a []interface{}) interface{} { return strings.HasPrefix(exprString(a[0]), exprString(a[1])) }},
+	"ends_with":   {2, func(a []interface{}) interface{} { return strings.HasSuffix(exprString(a[0]), exprString(a[1])) }},
+}
+
+// parses an expression, see Expr
+func CompileExpr(text string) (*Expr, error) {
+	p := &exprParser{text: text}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.or()
+	if err == nil && p.tok != "" {
+		err = p.errorf("unexpected %q", p.tok)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{text: text, root: root}, nil
+}
+
+// returns the expression's value for a line: a string, a float64, a bool,
+// nil, or a parsed field's value
+func (e *Expr) Eval(logline *attach.Log) interface{} {
+	return e.root(logline)
+}
+
+// reports whether the expression is true for a line. A nil Expr matches
+// every line.
+func (e *Expr) Match(logline *attach.Log) bool {
+	return e == nil || exprTruthy(e.root(logline))
+}
+
+func (e *Expr) String() string {
+	return e.text
+}
+
+// exprParser is a recursive descent parser over the expression's tokens,
+// lowest precedence first
+type exprParser struct {
+	text string
+	pos  int
+	// the current token, "" at the end, and whether it's a string literal
+	tok    string
+	quoted bool
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("expression %q: %s", p.text, fmt.Sprintf(format, args...))
+}
+
+var exprOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", ","}
+
+func (p *exprParser) next() error {
+	for p.pos < len(p.text) && unicode.IsSpace(rune(p.text[p.pos])) {
+		p.pos++
+	}
+	p.tok, p.quoted = "", false
+	if p.pos >= len(p.text) {
+		return nil
+	}
+	rest := p.text[p.pos:]
+	switch c := rest[0]; {
+	case c == '"' || c == '\'':
+		end := 1
+		for end < len(rest) && rest[end] != c {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return p.errorf("unterminated string")
+		}
+		literal := rest[:end+1]
+		if c == '\'' {
+			literal = `"` + strings.ReplaceAll(rest[1:end], `"`, `\"`) + `"`
+		}
+		value, err := strconv.Unquote(literal)
+		if err != nil {
+			return p.errorf("invalid string %s", rest[:end+1])
+		}
+		p.tok, p.quoted, p.pos = value, true, p.pos+end+1
+		return nil
+	case c == '_' || c == '@' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+		end := 0
+		for end < len(rest) && (rest[end] == '_' || rest[end] == '.' || rest[end] == '@' ||
+			unicode.IsLetter(rune(rest[end])) || unicode.IsDigit(rune(rest[end]))) {
+			end++
+		}
+		p.tok, p.pos = rest[:end], p.pos+end
+		return nil
+	}
+	for _, op := range exprOperators {
+		if strings.HasPrefix(rest, op) {
+			p.tok, p.pos = op, p.pos+len(op)
+			return nil
+		}
+	}
+	return p.errorf("unexpected %q", rest[:1])
+}
+
+// consumes the current token if it's the operator op
+func (p *exprParser) accept(op string) (bool, error) {
+	if p.quoted || p.tok != op {
+		return false, nil
+	}
+	return true, p.next()
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	left, err := p.and()
+	for err == nil {
+		var ok bool
+		if ok, err = p.accept("||"); !ok || err != nil {
+			break
+		}
+		var right exprNode
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(logline *attach.Log) interface{} {
+				return exprTruthy(l(logline)) || exprTruthy(right(logline))
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	left, err := p.comparison()
+	for err == nil {
+		var ok bool
+		if ok, err = p.accept("&&"); !ok || err != nil {
+			break
+		}
+		var right exprNode
+		if right, err = p.comparison(); err == nil {
+			l := left
+			left = func(logline *attach.Log) interface{} {
+				return exprTruthy(l(logline)) && exprTruthy(right(logline))
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) comparison() (exprNode, error) {
+	left, err := p.sum()
+	if err != nil || p.quoted {
+		return left, err
+	}
+	op := p.tok
+	switch op {
+	case "=~", "!~":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if !p.quoted {
+			return nil, p.errorf("%s needs a string regexp", op)
+		}
+		re, err := regexp.Compile(p.tok)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		want := op == "=~"
+		return func(logline *attach.Log) interface{} {
+			return re.MatchString(exprString(left(logline))) == want
+		}, nil
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	right, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	return func(logline *attach.Log) interface{} {
+		c, ok := exprCompare(left(logline), right(logline))
+		switch op {
+		case "==":
+			return ok && c == 0
+		case "!=":
+			return !ok || c != 0
+		case "<":
+			return ok && c < 0
+		case "<=":
+			return ok && c <= 0
+		case ">":
+			return ok && c > 0
+		default:
+			return ok && c >= 0
+		}
+	}, nil
+}
+
+func (p *exprParser) sum() (exprNode, error) {
+	left, err := p.product()
+	for err == nil && !p.quoted && (p.tok == "+" || p.tok == "-") {
+		op := p.tok
+		if err = p.next(); err != nil {
+			break
+		}
+		var right exprNode
+		if right, err = p.product(); err == nil {
+			l := left
+			left = func(logline *attach.Log) interface{} {
+				a, b := l(logline), right(logline)
+				aNum, bNum := exprIsNumber(a), exprIsNumber(b)
+				x, xok := exprNumber(a)
+				y, yok := exprNumber(b)
+				numbers := xok && yok && (aNum || bNum || op == "-")
+				switch {
+				case numbers && op == "+":
+					return x + y
+				case numbers:
+					return x - y
+				case op == "+":
+					// strings are joined, like the parts of a message
+					return exprString(a) + exprString(b)
+				}
+				return nil
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) product() (exprNode, error) {
+	left, err := p.unary()
+	for err == nil && !p.quoted && (p.tok == "*" || p.tok == "/" || p.tok == "%") {
+		op := p.tok
+		if err = p.next(); err != nil {
+			break
+		}
+		var right exprNode
+		if right, err = p.unary(); err == nil {
+			l := left
+			left = func(logline *attach.Log) interface{} {
+				x, xok := exprNumber(l(logline))
+				y, yok := exprNumber(right(logline))
+				if !xok || !yok || (op != "*" && y == 0) {
+					return nil
+				}
+				switch op {
+				case "*":
+					return x * y
+				case "/":
+					return x / y
+				}
+				return math.Mod(x, y)
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	not, err := p.accept("!")
+	if err != nil {
+		return nil, err
+	}
+	if not {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(logline *attach.Log) interface{} { return !exprTruthy(operand(logline)) }, nil
+	}
+	negate, err := p.accept("-")
+	if err != nil {
+		return nil, err
+	}
+	if negate {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(logline *attach.Log) interface{} {
+			if x, ok := exprNumber(operand(logline)); ok {
+				return -x
+			}
+			return nil
+		}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	tok, quoted := p.tok, p.quoted
+	if tok == "" && !quoted {
+		return nil, p.errorf("unexpected end")
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if quoted {
+		return func(*attach.Log) interface{} { return tok }, nil
+	}
+	if tok == "(" {
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := p.accept(")"); !ok || err != nil {
+			return nil, p.errorf("missing )")
+		}
+		return inner, nil
+	}
+	if n, err := strconv.ParseFloat(tok, 64); err == nil {
+		return func(*attach.Log) interface{} { return n }, nil
+	}
+	switch tok {
+	case "true", "false":
+		value := tok == "true"
+		return func(*attach.Log) interface{} { return value }, nil
+	case "null":
+		return func(*attach.Log) interface{} { return nil }, nil
+	}
+	if !unicode.IsLetter(rune(tok[0])) && tok[0] != '_' && tok[0] != '@' {
+		return nil, p.errorf("unexpected %q", tok)
+	}
+	if ok, err := p.accept("("); err != nil {
+		return nil, err
+	} else if ok {
+		return p.call(tok)
+	}
+	return exprName(tok), nil
+}
+
+// parses the arguments of a call to the function name, after its (
+func (p *exprParser) call(name string) (exprNode, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, p.errorf("unknown function %s", name)
+	}
+	var args []exprNode
+	if ok, err := p.accept(")"); err != nil {
+		return nil, err
+	} else if !ok {
+		for {
+			arg, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if ok, err := p.accept(","); err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+		}
+		if ok, err := p.accept(")"); !ok || err != nil {
+			return nil, p.errorf("missing ) after %s arguments", name)
+		}
+	}
+	if len(args) != fn.args {
+		return nil, p.errorf("%s takes %d arguments", name, fn.args)
+	}
+	return func(logline *attach.Log) interface{} {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			values[i] = arg(logline)
+		}
+		return fn.call(values)
+	}, nil
+}
+
+// returns the node looking up a name in a line
+func exprName(name string) exprNode {
+	switch name {
+	case "id":
+		return func(l *attach.Log) interface{} { return l.ID }
+	case "name":
+		return func(l *attach.Log) interface{} { return l.Name }
+	case "image":
+		return func(l *attach.Log) interface{} { return l.Image }
+	case "type":
+		return func(l *attach.Log) interface{} { return l.Type }
+	case "data":
+		return func(l *attach.Log) interface{} { return l.Data }
+	case "message":
+		return func(l *attach.Log) interface{} { return l.Message() }
+	case "severity":
+		return func(l *attach.Log) interface{} { return l.Severity }
+	case "truncated":
+		return func(l *attach.Log) interface{} { return l.Truncated }
+	case "k8s.namespace", "k8s.pod", "k8s.name":
+		return func(l *attach.Log) interface{} {
+			k8s := attach.CachedK8sContainer(l.Name)
+			switch {
+			case k8s == nil:
+				return nil
+			case name == "k8s.namespace":
+				return k8s.Namespace
+			case name == "k8s.pod":
+				return k8s.Pod
+			}
+			return k8s.Name
+		}
+	}
+	path := strings.Split(strings.TrimPrefix(name, "fields."), ".")
+	return func(l *attach.Log) interface{} {
+		var value interface{} = l.Fields
+		for _, key := range path {
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			value = fields[key]
+		}
+		return value
+	}
+}
+
+func exprTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	if n, ok := exprNumber(value); ok {
+		return n != 0
+	}
+	return true
+}
+
+func exprString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// returns a value as a number, including strings that hold one, as
+// logfmt fields do. Fields can hold any of Go's numbers: extracted integers
+// are int64s, and plugins and processors may decode json.Numbers.
+func exprNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// reports whether a value is a number rather than a string that may hold
+// one
+func exprIsNumber(value interface{}) bool {
+	if _, ok := value.(string); ok {
+		return false
+	}
+	_, ok := exprNumber(value)
+	return ok
+}
+
+// compares two values, as numbers if either is one and both can be,
+// otherwise as strings. null only equals null, and isn't ordered.
+func exprCompare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0, true
+		}
+		return 0, false
+	}
+	if exprIsNumber(a) || exprIsNumber(b) {
+		if x, ok := exprNumber(a); ok {
+			if y, ok := exprNumber(b); ok {
+				switch {
+				case x < y:
+					return -1, true
+				case x > y:
+					return 1, true
+				}
+				return 0, true
+			}
+		}
+	}
+	if x, ok := a.(bool); ok {
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			return 0, false
+		}
+	}
+	return strings.Compare(exprString(a), exprString(b)), true
+}
@@ -0,0 +1,147 @@
+package router
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+func TestExprMatch(t *testing.T) {
+	logline := &attach.Log{
+		Name:     "web",
+		Data:     "GET /health 200",
+		Severity: "error",
+		Fields: map[string]interface{}{
+			// as extracted, parsed from JSON, and parsed from logfmt
+			"status":    int64(503),
+			"latency":   float64(1.5),
+			"bytes":     "2048",
+			"count":     json.Number("7"),
+			"small":     int32(-3),
+			"big":       uint64(1 << 40),
+			"ratio":     float32(0.25),
+			"zero":      int64(0),
+			"other":     int64(503),
+			"nested":    map[string]interface{}{"code": int64(42)},
+			"retry":     true,
+			"empty":     "",
+			"version":   "10",
+			"nullfield": nil,
+		},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`status >= 500`, true},
+		{`status > 503`, false},
+		{`status == 503`, true},
+		{`status != 503`, false},
+		{`status < 1000 && status >= 500`, true},
+		{`fields.status == 503`, true},
+		{`status == other`, true},
+		{`status == "503"`, true},
+		{`latency > 1`, true},
+		{`latency <= 1.5`, true},
+		{`bytes > 1000`, true},
+		{`bytes == 2048`, true},
+		{`count == 7`, true},
+		{`count > 6.5`, true},
+		{`small < 0`, true},
+		{`small == -3`, true},
+		{`big > 1000000000000`, true},
+		{`ratio == 0.25`, true},
+		{`nested.code == 42`, true},
+		{`status + 1 == 504`, true},
+		{`status - other == 0`, true},
+		{`status * 2 == 1006`, true},
+		{`status / 0 == null`, true},
+		{`status % 10 == 3`, true},
+		{`-small == 3`, true},
+		{`status`, true},
+		{`zero`, false},
+		{`!zero`, true},
+		{`retry`, true},
+		{`empty`, false},
+		{`missing`, false},
+		{`missing == null`, true},
+		{`nullfield == null`, true},
+		{`missing > 0`, false},
+		{`missing < 0`, false},
+		{`missing != 0`, true},
+		// strings compare as strings unless either side is a number
+		{`version > "9"`, false},
+		{`version > 9`, true},
+		{`severity == "error"`, true},
+		{`name =~ "^we"`, true},
+		{`name !~ "^we"`, false},
+		{`data =~ "health" || status < 500`, true},
+		{`contains(lower(data), "health")`, true},
+		{`starts_with(name, "w") && ends_with(name, "b")`, true},
+		{`len(name) == 3`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`(status >= 500 || retry) && !(severity == "info")`, true},
+	}
+	for _, test := range tests {
+		expr, err := CompileExpr(test.expr)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+		if got := expr.Match(logline); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	for _, text := range []string{
+		``,
+		`status >=`,
+		`(status > 1`,
+		`status > 1)`,
+		`unknown(name)`,
+		`lower(name, name)`,
+		`name =~ "("`,
+		`"unterminated`,
+		`status ? 1`,
+	} {
+		if _, err := CompileExpr(text); err == nil {
+			t.Errorf("%q compiled", text)
+		}
+	}
+}
+
+func TestExprNumber(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  float64
+		ok    bool
+	}{
+		{float64(1.5), 1.5, true},
+		{float32(0.5), 0.5, true},
+		{int(-2), -2, true},
+		{int8(3), 3, true},
+		{int16(4), 4, true},
+		{int32(5), 5, true},
+		{int64(6), 6, true},
+		{uint(7), 7, true},
+		{uint8(8), 8, true},
+		{uint16(9), 9, true},
+		{uint32(10), 10, true},
+		{uint64(11), 11, true},
+		{json.Number("12.5"), 12.5, true},
+		{json.Number("x"), 0, false},
+		{" 13 ", 13, true},
+		{"fourteen", 0, false},
+		{true, 0, false},
+		{nil, 0, false},
+	}
+	for _, test := range tests {
+		got, ok := exprNumber(test.value)
+		if got != test.want || ok != test.ok {
+			t.Errorf("exprNumber(%#v) = %v, %v, want %v, %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
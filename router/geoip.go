@@ -0,0 +1,87 @@
+package router
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// GeoIP looks up where IP address fields are, from local MaxMind databases
+type GeoIP struct {
+	city   *geoip2.Reader
+	asn    *geoip2.Reader
+	fields []string
+}
+
+// the GeoIP databases, from GEOIP_DB and GEOIP_ASN_DB, nil if not configured
+var GeoIPDB *GeoIP
+
+// opens a city or country database, and optionally an ASN database. fields
+// are the names of fields holding addresses to look up.
+func NewGeoIP(cityPath, asnPath string, fields []string) (*GeoIP, error) {
+	g := &GeoIP{fields: fields}
+	var err error
+	if g.city, err = geoip2.Open(cityPath); err != nil {
+		return nil, err
+	}
+	if asnPath != "" {
+		if g.asn, err = geoip2.Open(asnPath); err != nil {
+			g.city.Close()
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// adds a <field>_geo object for each address field of the line
+func (g *GeoIP) enrich(logline *attach.Log) {
+	for _, field := range g.fields {
+		value, ok := logline.Fields[field].(string)
+		if !ok {
+			continue
+		}
+		if host, _, err := net.SplitHostPort(value); err == nil {
+			value = host
+		}
+		ip := net.ParseIP(value)
+		if ip == nil || ip.IsPrivate() || ip.IsLoopback() {
+			continue
+		}
+		geo := make(map[string]interface{})
+		if city, err := g.city.City(ip); err == nil {
+			if city.Country.IsoCode != "" {
+				geo["country_code"] = city.Country.IsoCode
+				geo["country"] = city.Country.Names["en"]
+			}
+			if name := city.City.Names["en"]; name != "" {
+				geo["city"] = name
+			}
+			if city.Location.Latitude != 0 || city.Location.Longitude != 0 {
+				geo["location"] = map[string]float64{"lat": city.Location.Latitude, "lon": city.Location.Longitude}
+			}
+		}
+		if g.asn != nil {
+			if asn, err := g.asn.ASN(ip); err == nil && asn.AutonomousSystemNumber != 0 {
+				geo["asn"] = asn.AutonomousSystemNumber
+				geo["as_org"] = asn.AutonomousSystemOrganization
+			}
+		}
+		if len(geo) > 0 {
+			logline.Fields[field+"_geo"] = geo
+		}
+	}
+}
+
+// enrichers add fields derived from parsed ones, after parsing and
+// extraction
+var enrichers = map[string]func() (func(*attach.Log), error){
+	"geoip": func() (func(*attach.Log), error) {
+		if GeoIPDB == nil {
+			return nil, fmt.Errorf("geoip enrichment needs GEOIP_DB")
+		}
+		return GeoIPDB.enrich, nil
+	},
+}
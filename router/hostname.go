@@ -0,0 +1,65 @@
+package router
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// name of the host lines are reported as coming from, by syslog, es, s3
+// and v2 JSON lines, see DetectHostname
+var Hostname, _ = os.Hostname()
+
+// where the Docker host's /etc/hostname is mounted, so logspout running in
+// a container reports the host's name rather than its container ID
+var hostHostnameFile = "/etc/host_hostname"
+
+// how long resolving the host's FQDN may take
+const fqdnTimeout = 2 * time.Second
+
+// returns the name of the host logspout runs on: override, unless it's
+// just the container's own hostname as Docker sets HOSTNAME to, otherwise
+// the name in hostHostnameFile, otherwise the container's. With fqdn, names
+// other than override that aren't qualified are resolved to their FQDN if
+// they have one.
+func DetectHostname(override string, fqdn bool) string {
+	own, _ := os.Hostname()
+	if override != "" && override != own {
+		return override
+	}
+	name := own
+	if data, err := os.ReadFile(hostHostnameFile); err == nil && strings.TrimSpace(string(data)) != "" {
+		name = strings.TrimSpace(string(data))
+	}
+	if fqdn && name != "" && !strings.Contains(name, ".") {
+		return resolveFQDN(name)
+	}
+	return name
+}
+
+// returns the fully qualified name of a host, its canonical name or that
+// of its addresses, or the name as it is if neither qualifies it
+func resolveFQDN(name string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), fqdnTimeout)
+	defer cancel()
+	if cname, err := net.DefaultResolver.LookupCNAME(ctx, name); err == nil {
+		if cname = strings.TrimSuffix(cname, "."); strings.HasPrefix(cname, name+".") {
+			return cname
+		}
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+	if err != nil {
+		return name
+	}
+	for _, addr := range addrs {
+		names, _ := net.DefaultResolver.LookupAddr(ctx, addr)
+		for _, qualified := range names {
+			if qualified = strings.TrimSuffix(qualified, "."); strings.HasPrefix(qualified, name+".") {
+				return qualified
+			}
+		}
+	}
+	return name
+}
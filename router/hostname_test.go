@@ -0,0 +1,51 @@
+package router
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectHostname(t *testing.T) {
+	defer func(file string) { hostHostnameFile = file }(hostHostnameFile)
+	own, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	mounted := filepath.Join(dir, "host_hostname")
+	if err := os.WriteFile(mounted, []byte("dockerhost.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	blank := filepath.Join(dir, "blank")
+	if err := os.WriteFile(blank, []byte(" \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		override, file string
+		want           string
+	}{
+		{"override.example.com", mounted, "override.example.com"},
+		// HOSTNAME set by Docker to the container's own name
+		{own, mounted, "dockerhost.example.com"},
+		{"", mounted, "dockerhost.example.com"},
+		{"", blank, own},
+		{"", filepath.Join(dir, "missing"), own},
+		{own, filepath.Join(dir, "missing"), own},
+	}
+	for _, test := range tests {
+		hostHostnameFile = test.file
+		if got := DetectHostname(test.override, false); got != test.want {
+			t.Errorf("DetectHostname(%q) with %s: got %q, want %q", test.override, filepath.Base(test.file), got, test.want)
+		}
+	}
+
+	// qualified names, and overrides, aren't resolved
+	hostHostnameFile = mounted
+	if got := DetectHostname("", true); got != "dockerhost.example.com" {
+		t.Errorf("got %q, want the mounted name as it is", got)
+	}
+	if got := DetectHostname("short", true); got != "short" {
+		t.Errorf("got %q, want the override as it is", got)
+	}
+}
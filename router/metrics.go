@@ -0,0 +1,80 @@
+package router
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	linesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "route_lines_received_total",
+		Help:      "Log lines received by a route.",
+	}, []string{"route"})
+	linesShipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "route_lines_shipped_total",
+		Help:      "Log lines successfully delivered by a route.",
+	}, []string{"route"})
+	bytesShipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "route_bytes_shipped_total",
+		Help:      "Bytes of encoded log lines successfully delivered by a route, before compression.",
+	}, []string{"route"})
+	linesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "route_lines_dropped_total",
+		Help:      "Log lines a route failed to deliver.",
+	}, []string{"route", "reason"})
+	linesSampledOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "route_lines_sampled_out_total",
+		Help:      "Log lines a route skipped because of its sampling rates.",
+	}, []string{"route"})
+	linesFilteredOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "route_lines_filtered_out_total",
+		Help:      "Log lines a route skipped because they didn't match its where expression.",
+	}, []string{"route"})
+	linesSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "route_lines_skipped_total",
+		Help:      "Log lines a route chose not to ship, by the filter that skipped them.",
+	}, []string{"route", "reason"})
+	queuedLines = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "logspout",
+		Name:      "route_queued_lines",
+		Help:      "Log lines a route's adapter holds waiting to be sent.",
+	}, []string{"route"})
+	queueUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "logspout",
+		Name:      "route_queue_usage_ratio",
+		Help:      "How full a route's queue is, from 0 to 1.",
+	}, []string{"route"})
+	queueHighWater = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "route_queue_high_water_total",
+		Help:      "Times a route's queue stayed above the QUEUE_HIGH_WATER threshold for QUEUE_HIGH_WATER_FOR.",
+	}, []string{"route"})
+	adapterErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "adapter_errors_total",
+		Help:      "Errors returned by a route's adapter.",
+	}, []string{"route", "type"})
+	sendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "logspout",
+		Name:      "adapter_send_duration_seconds",
+		Help:      "Time a route's adapter took to send a batch to its target, including retries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "type"})
+	routeReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logspout",
+		Name:      "route_reconnects_total",
+		Help:      "Times a route reconnected to its target.",
+	}, []string{"route"})
+)
+
+func init() {
+	prometheus.MustRegister(linesReceived, linesShipped, bytesShipped, linesDropped, linesSampledOut, linesFilteredOut,
+		linesSkipped, queuedLines, queueUsage, queueHighWater,
+		adapterErrors, sendDuration, routeReconnects)
+}
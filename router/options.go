@@ -0,0 +1,157 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// TargetOptions are settings of a target that only its adapter uses, like
+// kafka's topic or s3's object size. Adapter packages define them and
+// register them with RegisterTargetOptions under the key they have in the
+// target's JSON, so the router doesn't need to know every backend.
+type TargetOptions interface {
+	// checks the settings as the route is validated, preparing anything
+	// derived from them such as templates
+	Validate() error
+}
+
+// SecretOptions are target options that hold secrets, which Route.Redacted
+// replaces
+type SecretOptions interface {
+	TargetOptions
+	// returns a copy with secrets replaced with RedactedSecret
+	Redacted() TargetOptions
+}
+
+// CredentialOptions are target options that read credentials from files,
+// whose routes are restarted when what the files hold changes, see
+// RouteManager.RestartChangedCredentials
+type CredentialOptions interface {
+	TargetOptions
+	CredentialFiles() []string
+}
+
+// registered target options by their key
+var targetOptions = make(map[string]func() TargetOptions)
+
+// registers the options targets set under key, new returning empty ones to
+// decode them into. Adapters register them from init functions, along with
+// their adapter types, whose Options is the key.
+func RegisterTargetOptions(key string, new func() TargetOptions) {
+	if _, exists := targetOptions[key]; exists {
+		panic("target options " + key + " registered twice")
+	}
+	if slices.Contains(targetKeys(), key) {
+		panic("target options " + key + " would replace a target field")
+	}
+	targetOptions[key] = new
+}
+
+// returns the target's options under key, or nil if it doesn't set them
+func (t Target) Options(key string) TargetOptions {
+	return t.options[key]
+}
+
+// sets the target's options under key, for targets built in code rather
+// than decoded
+func (t *Target) SetOptions(key string, options TargetOptions) {
+	// copies of the target share its options until one of them sets some
+	t.options = maps.Clone(t.options)
+	if t.options == nil {
+		t.options = make(map[string]TargetOptions)
+	}
+	t.options[key] = options
+}
+
+// targetFields is Target without its JSON methods, which encode and decode
+// its fields with it
+type targetFields Target
+
+// returns the JSON keys of Target's own fields
+func targetKeys() []string {
+	var keys []string
+	fields := reflect.TypeFor[targetFields]()
+	for i := 0; i < fields.NumField(); i++ {
+		if name, _, _ := strings.Cut(fields.Field(i).Tag.Get("json"), ","); name != "" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// encodes the target's fields followed by its options, by key
+func (t Target) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(targetFields(t))
+	if err != nil || len(t.options) == 0 {
+		return data, err
+	}
+	// type and addr are always there, so there's a field before each option
+	buf := bytes.NewBuffer(data[:len(data)-1])
+	for _, key := range slices.Sorted(maps.Keys(t.options)) {
+		options, err := json.Marshal(t.options[key])
+		if err != nil {
+			return nil, err
+		}
+		name, _ := json.Marshal(key)
+		buf.WriteByte(',')
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(options)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodes a target, the options of registered keys into the types their
+// adapters registered. Unknown fields are errors, as for the rest of a
+// route, see attach.Unmarshal.
+func (t *Target) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	options := make(map[string]TargetOptions)
+	for key, raw := range fields {
+		newOptions, ok := targetOptions[key]
+		if !ok {
+			continue
+		}
+		delete(fields, key)
+		if string(raw) == "null" {
+			continue
+		}
+		decoded := newOptions()
+		if err := decodeStrict(raw, decoded); err != nil {
+			return fmt.Errorf("target %s: %s", key, err)
+		}
+		options[key] = decoded
+	}
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	var decoded targetFields
+	if err := decodeStrict(rest, &decoded); err != nil {
+		return err
+	}
+	*t = Target(decoded)
+	if len(options) > 0 {
+		t.options = options
+	}
+	return nil
+}
+
+// decodes data into v, failing on fields v doesn't have
+func decodeStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
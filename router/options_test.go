@@ -0,0 +1,115 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// testOptions are the options of test targets
+type testOptions struct {
+	Password string `json:"password,omitempty"`
+	File     string `json:"file,omitempty"`
+	Invalid  bool   `json:"invalid,omitempty"`
+}
+
+func (o *testOptions) Validate() error {
+	if o.Invalid {
+		return fmt.Errorf("test options are invalid")
+	}
+	return nil
+}
+
+func (o *testOptions) Redacted() TargetOptions {
+	copied := *o
+	if o.Password != "" {
+		copied.Password = RedactedSecret
+	}
+	return &copied
+}
+
+func (o *testOptions) CredentialFiles() []string {
+	if o.File == "" {
+		return nil
+	}
+	return []string{o.File}
+}
+
+func TestTargetOptionsJSON(t *testing.T) {
+	tests := []struct {
+		json    string
+		options *testOptions
+		ok      bool
+	}{
+		{`{"type":"test","addr":"host:1"}`, nil, true},
+		{`{"type":"test","addr":"host:1","test":null}`, nil, true},
+		{`{"type":"test","addr":"host:1","test":{"password":"x"},"workers":2}`, &testOptions{Password: "x"}, true},
+		{`{"type":"test","addr":"host:1","test":{"unknown":"x"}}`, nil, false},
+		{`{"type":"test","addr":"host:1","unknown":{}}`, nil, false},
+		{`{"type":"test","addr":"host:1","test":"x"}`, nil, false},
+	}
+	for _, test := range tests {
+		var target Target
+		err := attach.Unmarshal(io.NopCloser(strings.NewReader(test.json)), &target)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got %v", test.json, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		options, _ := target.Options("test").(*testOptions)
+		if !reflect.DeepEqual(options, test.options) {
+			t.Errorf("%s: got options %+v, want %+v", test.json, options, test.options)
+		}
+		// and they're encoded as they were decoded
+		var decoded Target
+		if err := attach.Unmarshal(io.NopCloser(strings.NewReader(string(attach.Marshal(target)))), &decoded); err != nil {
+			t.Errorf("%s: decoding it encoded: %v", test.json, err)
+		} else if !reflect.DeepEqual(decoded, target) {
+			t.Errorf("%s: got %+v encoded and decoded, want %+v", test.json, decoded, target)
+		}
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		key     string
+		options *testOptions
+		ok      bool
+	}{
+		{"test", &testOptions{}, true},
+		{"test", &testOptions{Invalid: true}, false},
+		// the test adapter's options are under test
+		{"other", &testOptions{}, false},
+	}
+	for _, test := range tests {
+		route := &Route{Target: Target{Type: "test", Addr: "host:1"}}
+		route.Target.SetOptions(test.key, test.options)
+		if err := route.Validate(); (err == nil) != test.ok {
+			t.Errorf("%s %+v: got %v", test.key, test.options, err)
+		}
+	}
+}
+
+func TestSetOptionsCopies(t *testing.T) {
+	target := Target{Type: "test", Addr: "host:1"}
+	target.SetOptions("test", &testOptions{Password: "x"})
+	copied := target
+	copied.SetOptions("test", &testOptions{Password: "y"})
+	if target.Options("test").(*testOptions).Password != "x" {
+		t.Error("setting a copy's options changed the target's")
+	}
+}
+
+func TestCredentialFilesOfOptions(t *testing.T) {
+	target := Target{Type: "test", Addr: "host:1"}
+	target.SetOptions("test", &testOptions{File: "/run/secrets/password"})
+	if files := target.credentialFiles(); !reflect.DeepEqual(files, []string{"/run/secrets/password"}) {
+		t.Errorf("got credential files %v", files)
+	}
+}
@@ -0,0 +1,222 @@
+package router
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// Stage is one step of a route's pipeline, between the containers it reads
+// from and the adapter that ships its lines. Stages work on the route's own
+// copy of a line and return false to drop it.
+type Stage interface {
+	Process(logline *attach.Log) bool
+}
+
+// StageFunc adapts a func to a Stage
+type StageFunc func(logline *attach.Log) bool
+
+func (f StageFunc) Process(logline *attach.Log) bool {
+	return f(logline)
+}
+
+// StageConfig is one step of Target.Pipeline. Exactly one of its settings
+// is set, naming the kind of stage and configuring it.
+type StageConfig struct {
+	// parser, see parsers
+	Parse string `json:"parse,omitempty"`
+	// rule pulling fields out of the message
+	Extract *attach.ExtractRule `json:"extract,omitempty"`
+	// enricher, see enrichers
+	Enrich string `json:"enrich,omitempty"`
+	// fields set from expressions, in order of their names
+	Compute map[string]string `json:"compute,omitempty"`
+	// file of a plugin, see Plugin
+	Plugin string `json:"plugin,omitempty"`
+	// expression lines must match to go on, see Expr
+	Where string `json:"where,omitempty"`
+	// fraction of lines kept by severity, see Target.Sample
+	Sample map[string]float64 `json:"sample,omitempty"`
+}
+
+// returns the stages a target's lines go through: its pipeline if it has
+// one, otherwise one built from its parsers, extract, enrich, compute,
+// plugins, where and sample settings, in that order
+func (t Target) stageConfigs() []StageConfig {
+	if t.Pipeline != nil {
+		return t.Pipeline
+	}
+	names := t.Parsers
+	if names == nil {
+		names = adapters[t.Type].DefaultParsers
+	}
+	var configs []StageConfig
+	for _, name := range names {
+		if name != "none" {
+			configs = append(configs, StageConfig{Parse: name})
+		}
+	}
+	for i := range t.Extract {
+		configs = append(configs, StageConfig{Extract: &t.Extract[i]})
+	}
+	for _, name := range t.Enrich {
+		configs = append(configs, StageConfig{Enrich: name})
+	}
+	if len(t.Compute) > 0 {
+		configs = append(configs, StageConfig{Compute: t.Compute})
+	}
+	for _, path := range t.Plugins {
+		configs = append(configs, StageConfig{Plugin: path})
+	}
+	if t.Where != "" {
+		configs = append(configs, StageConfig{Where: t.Where})
+	}
+	if len(t.Sample) > 0 {
+		configs = append(configs, StageConfig{Sample: t.Sample})
+	}
+	return configs
+}
+
+// builds the stage a config describes for a route
+func (r *Route) newStage(c StageConfig) (Stage, error) {
+	set := 0
+	for _, isSet := range []bool{c.Parse != "", c.Extract != nil, c.Enrich != "",
+		c.Compute != nil, c.Plugin != "", c.Where != "", c.Sample != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("stage must set exactly one of parse, extract, enrich, compute, plugin, where or sample")
+	}
+	switch {
+	case c.Parse != "":
+		parse, ok := parsers[c.Parse]
+		if !ok {
+			return nil, fmt.Errorf("unknown parser %q", c.Parse)
+		}
+		return parseStage{fieldsStage(parse)}, nil
+	case c.Extract != nil:
+		if err := c.Extract.Compile(); err != nil {
+			return nil, err
+		}
+		return fieldsStage(c.Extract.Apply), nil
+	case c.Enrich != "":
+		enricher, ok := enrichers[c.Enrich]
+		if !ok {
+			return nil, fmt.Errorf("unknown enricher %q", c.Enrich)
+		}
+		enrich, err := enricher()
+		if err != nil {
+			return nil, err
+		}
+		return fieldsStage(enrich), nil
+	case c.Compute != nil:
+		return computeStage(c.Compute)
+	case c.Plugin != "":
+		plugin, err := getPlugin(c.Plugin)
+		if err != nil {
+			return nil, err
+		}
+		return StageFunc(func(logline *attach.Log) bool {
+			if plugin.Process(logline) {
+				return true
+			}
+			r.status.Skipped("plugin", 1)
+			return false
+		}), nil
+	case c.Where != "":
+		where, err := CompileExpr(c.Where)
+		if err != nil {
+			return nil, fmt.Errorf("where: %s", err)
+		}
+		return StageFunc(func(logline *attach.Log) bool {
+			if where.Match(logline) {
+				return true
+			}
+			linesFilteredOut.WithLabelValues(r.ID).Inc()
+			r.status.Skipped("where", 1)
+			return false
+		}), nil
+	default:
+		return r.sampleStage(c.Sample)
+	}
+}
+
+// wraps a func adding fields to a line, picking up the event time and
+// severity from the fields it adds
+func fieldsStage(add func(*attach.Log)) Stage {
+	return StageFunc(func(logline *attach.Log) bool {
+		add(logline)
+		fieldTimestamp(logline)
+		fieldSeverity(logline)
+		return true
+	})
+}
+
+// parseStage is a parser's stage, which lines whose container profile has
+// parsers of its own skip, see profileParse
+type parseStage struct {
+	Stage
+}
+
+// computedField is a field a route sets from an expression, see
+// StageConfig.Compute
+type computedField struct {
+	name string
+	expr *Expr
+}
+
+func computeStage(compute map[string]string) (Stage, error) {
+	var names []string
+	for name := range compute {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var computed []computedField
+	for _, name := range names {
+		expr, err := CompileExpr(compute[name])
+		if err != nil {
+			return nil, fmt.Errorf("compute %s: %s", name, err)
+		}
+		computed = append(computed, computedField{name, expr})
+	}
+	return StageFunc(func(logline *attach.Log) bool {
+		for _, field := range computed {
+			if value := field.expr.Eval(logline); value != nil {
+				if logline.Fields == nil {
+					logline.Fields = make(map[string]interface{})
+				}
+				logline.Fields[field.name] = value
+			}
+		}
+		return true
+	}), nil
+}
+
+func (r *Route) sampleStage(rates map[string]float64) (Stage, error) {
+	for severity, rate := range rates {
+		if severity != "default" && attach.NormalizeSeverity(severity) != severity {
+			return nil, fmt.Errorf("unknown sample severity %q", severity)
+		}
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("sample rate for %s must be between 0 and 1", severity)
+		}
+	}
+	return StageFunc(func(logline *attach.Log) bool {
+		rate, ok := rates[logline.Severity]
+		if !ok {
+			if rate, ok = rates["default"]; !ok {
+				return true
+			}
+		}
+		if rate >= 1 || rand.Float64() < rate {
+			return true
+		}
+		linesSampledOut.WithLabelValues(r.ID).Inc()
+		r.status.Skipped("sampled", 1)
+		return false
+	}), nil
+}
@@ -0,0 +1,42 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// Plugin filters or transforms lines with code loaded at run time, see
+// Target.Plugins. Process returns false to drop the line.
+type Plugin interface {
+	Process(logline *attach.Log) bool
+}
+
+// loads the plugin in a file, nil in builds without plugin support. Builds
+// with the wasm_plugins tag load WebAssembly modules, see wasm.go.
+var loadPlugin func(path string) (Plugin, error)
+
+// loaded plugins by path, so routes sharing one share its instance
+var plugins = struct {
+	sync.Mutex
+	loaded map[string]Plugin
+}{loaded: make(map[string]Plugin)}
+
+// returns the plugin in path, loading it the first time it's used
+func getPlugin(path string) (Plugin, error) {
+	if loadPlugin == nil {
+		return nil, fmt.Errorf("plugin %s: this build doesn't support plugins, build with -tags wasm_plugins", path)
+	}
+	plugins.Lock()
+	defer plugins.Unlock()
+	if plugin, ok := plugins.loaded[path]; ok {
+		return plugin, nil
+	}
+	plugin, err := loadPlugin(path)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %s", path, err)
+	}
+	plugins.loaded[path] = plugin
+	return plugin, nil
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// buffers larger than this aren't pooled, so one huge line doesn't pin its
+// buffer for good
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func GetBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+var docPool = sync.Pool{New: func() interface{} { return make(map[string]interface{}, 16) }}
+
+func GetDoc() map[string]interface{} {
+	return docPool.Get().(map[string]interface{})
+}
+
+func PutDoc(doc map[string]interface{}) {
+	clear(doc)
+	docPool.Put(doc)
+}
+
+var itemPool = sync.Pool{New: func() interface{} { return new(BatchItem) }}
+
+// returns an item with an empty pooled buffer for the line's encoding
+func getItem(logline *attach.Log) *BatchItem {
+	item := itemPool.Get().(*BatchItem)
+	item.Log, item.Buf = logline, GetBuffer()
+	return item
+}
+
+// returns the items of a sent batch and their buffers to the pools
+func putBatch(batch []*BatchItem) {
+	for _, item := range batch {
+		PutBuffer(item.Buf)
+		item.Log, item.Buf = nil, nil
+		itemPool.Put(item)
+	}
+}
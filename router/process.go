@@ -0,0 +1,259 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// parsers turn a line's data into structured fields. Each works on its own
+// copy of the line, since lines are shared by every route listening to a
+// container.
+var parsers = map[string]func(*attach.Log){
+	"json":   parseJSON,
+	"logfmt": parseLogfmt,
+}
+
+// fields added to every routed line that doesn't already have them, from
+// FIELDS
+var StaticFields map[string]string
+
+// parses a comma-separated list of key=value pairs
+func ParseFields(list string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for _, pair := range attach.SplitList(list) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid field %q, expected key=value", pair)
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return fields, nil
+}
+
+// merges the fields of a JSON object line. A msg field is used as the
+// message when there's no message field, so adapters agree on where the
+// message is.
+func parseJSON(logline *attach.Log) {
+	data := strings.TrimSpace(logline.Data)
+	if !strings.HasPrefix(data, "{") {
+		return
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil || doc == nil {
+		return
+	}
+	if _, present := doc["message"]; !present {
+		if msg, ok := doc["msg"].(string); ok {
+			doc["message"] = msg
+		}
+	}
+	attach.MergeFields(logline, doc)
+	logline.Document = true
+}
+
+// merges the key=value pairs of a logfmt line. Lines with anything but
+// pairs are left alone, so plain text isn't mistaken for logfmt.
+func parseLogfmt(logline *attach.Log) {
+	fields := make(map[string]interface{})
+	rest := strings.TrimSpace(logline.Data)
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 || strings.ContainsAny(rest[:eq], " \t\"") {
+			return
+		}
+		key := rest[:eq]
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rest) {
+				return
+			}
+			unquoted, err := strconv.Unquote(rest[:end+1])
+			if err != nil {
+				return
+			}
+			value, rest = unquoted, rest[end+1:]
+		} else if space := strings.IndexAny(rest, " \t"); space >= 0 {
+			value, rest = rest[:space], rest[space:]
+		} else {
+			value, rest = rest, ""
+		}
+		if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			return
+		}
+		fields[key] = value
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	if len(fields) == 0 {
+		return
+	}
+	if _, present := fields["message"]; !present {
+		if msg, ok := fields["msg"]; ok {
+			fields["message"] = msg
+		}
+	}
+	attach.MergeFields(logline, fields)
+}
+
+// runs the parsers of a line's container profile on it
+func profileParse(logline *attach.Log) {
+	for _, name := range logline.Profile.Parsers {
+		if parse, ok := parsers[name]; ok {
+			fieldsStage(parse).Process(logline)
+		}
+	}
+}
+
+// checks the profiles' parsers are ones routes have, or none
+func ValidateProfiles(profiles []*attach.Profile) error {
+	for _, profile := range profiles {
+		for _, name := range profile.Parsers {
+			if _, ok := parsers[name]; !ok && name != "none" {
+				return fmt.Errorf("%s: unknown parser %q", profile.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// counts a line arriving at the route and runs it through the route's
+// pipeline. It returns false if a stage or the route's source doesn't want
+// the line. Lines of routes with a processor went through the pipeline on
+// their way to it, so they're returned as they are.
+func (r *Route) Process(logline *attach.Log) (*attach.Log, bool) {
+	if r.Target.Processor != nil {
+		return logline, true
+	}
+	return r.process(logline)
+}
+
+func (r *Route) process(logline *attach.Log) (*attach.Log, bool) {
+	r.status.Received()
+	// an archive doesn't archive again what's replayed from it
+	if from, _ := logline.Fields[ReplayedFromField].(string); from == r.ID && from != "" {
+		r.status.Skipped("replayed", 1)
+		return nil, false
+	}
+	profiled := logline.Profile != nil && logline.Profile.Parsers != nil
+	if len(r.stages) == 0 && len(StaticFields) == 0 && !profiled {
+		return logline, r.matchSource(logline)
+	}
+	processed := *logline
+	// lines from a processor can have fields already
+	processed.Fields = maps.Clone(logline.Fields)
+	// static fields are defaults, which parsed fields replace
+	if len(StaticFields) > 0 && processed.Fields == nil {
+		processed.Fields = make(map[string]interface{}, len(StaticFields))
+	}
+	for field, value := range StaticFields {
+		if _, present := processed.Fields[field]; !present {
+			processed.Fields[field] = value
+		}
+	}
+	// a container's profile chooses how its lines are parsed, ahead of
+	// the route's other stages
+	if profiled {
+		profileParse(&processed)
+	}
+	for _, stage := range r.stages {
+		if _, parse := stage.(parseStage); parse && profiled {
+			continue
+		}
+		if !stage.Process(&processed) {
+			return nil, false
+		}
+	}
+	// matched after the pipeline so routes can select on parsed severities
+	if !r.matchSource(&processed) {
+		return nil, false
+	}
+	return &processed, true
+}
+
+// matches a line against the route's source, counting it as skipped if it
+// doesn't
+func (r *Route) matchSource(logline *attach.Log) bool {
+	if r.Source.MatchLine(logline) {
+		return true
+	}
+	r.status.Skipped("source", 1)
+	return false
+}
+
+// renders a line's message followed by its other parsed fields as logfmt
+// pairs, for text-based targets
+func TextWithFields(logline *attach.Log) string {
+	if len(logline.Fields) == 0 {
+		return logline.Data
+	}
+	keys := make([]string, 0, len(logline.Fields))
+	for key := range logline.Fields {
+		if key != "message" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	text := logline.Message()
+	for _, key := range keys {
+		value, ok := logline.Fields[key].(string)
+		if !ok {
+			encoded, _ := json.Marshal(logline.Fields[key])
+			value = string(encoded)
+		}
+		text += " " + key + "=" + LogfmtValue(value)
+	}
+	return text
+}
+
+// quotes a logfmt value if it needs it
+func LogfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\\\t\r\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// fields parsed lines commonly carry their event time in
+var timestampFields = []string{"@timestamp", "timestamp", "time", "ts"}
+
+// sets the line's event time from a parsed time field, if it has one
+func fieldTimestamp(logline *attach.Log) {
+	for _, field := range timestampFields {
+		value, ok := logline.Fields[field].(string)
+		if !ok {
+			continue
+		}
+		if t, ok := attach.ParseTimestamp(value, ""); ok {
+			logline.Timestamp = t.UTC()
+			return
+		}
+	}
+}
+
+// fields parsed lines commonly carry their level in
+var severityFields = []string{"level", "severity", "lvl", "log.level"}
+
+// sets the line's severity from a parsed level field, if it has one
+func fieldSeverity(logline *attach.Log) {
+	for _, field := range severityFields {
+		if level, ok := logline.Fields[field].(string); ok {
+			if severity := attach.NormalizeSeverity(level); severity != "" {
+				logline.Severity = severity
+				return
+			}
+		}
+	}
+}
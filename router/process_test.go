@@ -0,0 +1,88 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		data string
+		want map[string]interface{}
+	}{
+		{`level=info msg="request done" status=200`,
+			map[string]interface{}{"level": "info", "msg": "request done", "message": "request done", "status": "200"}},
+		{`  a=1   b=2  `, map[string]interface{}{"a": "1", "b": "2"}},
+		{"a=1\tb=2", map[string]interface{}{"a": "1", "b": "2"}},
+		{`a= b=2`, map[string]interface{}{"a": "", "b": "2"}},
+		{`a=`, map[string]interface{}{"a": ""}},
+		{`a=""`, map[string]interface{}{"a": ""}},
+		{`quote="say \"hi\"" n=1`, map[string]interface{}{"quote": `say "hi"`, "n": "1"}},
+		{`path="C:\\temp"`, map[string]interface{}{"path": `C:\temp`}},
+		{`url=http://host/?q=1`, map[string]interface{}{"url": "http://host/?q=1"}},
+		{`msg=hi message=kept`, map[string]interface{}{"msg": "hi", "message": "kept"}},
+		// not logfmt: left alone
+		{``, nil},
+		{`hello world`, nil},
+		{`starting server on port=8080`, nil},
+		{`=value`, nil},
+		{`a=1 trailing`, nil},
+		{`a="unterminated`, nil},
+		{`a="closed"b=2`, nil},
+		{`"a"=1`, nil},
+		{`a="bad \q escape"`, nil},
+	}
+	for _, test := range tests {
+		logline := &attach.Log{Data: test.data}
+		parseLogfmt(logline)
+		if !reflect.DeepEqual(logline.Fields, test.want) {
+			t.Errorf("parseLogfmt(%q) = %v, want %v", test.data, logline.Fields, test.want)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		data string
+		want map[string]interface{}
+	}{
+		{`{"level":"info","n":1}`, map[string]interface{}{"level": "info", "n": float64(1)}},
+		{` {"msg":"hi"} `, map[string]interface{}{"msg": "hi", "message": "hi"}},
+		{`{"msg":"hi","message":"kept"}`, map[string]interface{}{"msg": "hi", "message": "kept"}},
+		{`{"msg":1}`, map[string]interface{}{"msg": float64(1)}},
+		{`{}`, map[string]interface{}{}},
+		{`null`, nil},
+		{`[1,2]`, nil},
+		{`{"broken":`, nil},
+		{`plain text`, nil},
+	}
+	for _, test := range tests {
+		logline := &attach.Log{Data: test.data}
+		parseJSON(logline)
+		if !reflect.DeepEqual(logline.Fields, test.want) {
+			t.Errorf("parseJSON(%q) = %#v, want %#v", test.data, logline.Fields, test.want)
+		}
+		// documents it parsed aren't messages
+		if logline.Document != (test.want != nil) {
+			t.Errorf("parseJSON(%q): got document %v", test.data, logline.Document)
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields(" cluster = blue , env=prod,empty=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"cluster": "blue", "env": "prod", "empty": ""}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("got %v, want %v", fields, want)
+	}
+	for _, list := range []string{"novalue", "=x"} {
+		if _, err := ParseFields(list); err == nil {
+			t.Errorf("ParseFields(%q) succeeded", list)
+		}
+	}
+}
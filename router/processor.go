@@ -0,0 +1,164 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// ProcessorConfig sends a route's lines through an external HTTP service
+// before they're shipped. Batches are POSTed as NDJSON, one line per JSON
+// object, and the service answers with the lines to ship in the same form:
+// changed, fewer, more or none at all.
+type ProcessorConfig struct {
+	URL string `json:"url"`
+	// how long the service may take to answer a batch, as a duration.
+	// Defaults to 5s.
+	Timeout string `json:"timeout,omitempty"`
+	// what happens to a batch when the service fails: pass ships it
+	// unprocessed, drop drops it. Defaults to pass.
+	OnError string `json:"on_error,omitempty"`
+
+	timeout time.Duration
+}
+
+// fills in unset settings and checks them
+func (c *ProcessorConfig) normalize() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("processor url: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("processor url must be http or https, not %q", c.URL)
+	}
+	if c.Timeout == "" {
+		c.Timeout = "5s"
+	}
+	if c.timeout, err = time.ParseDuration(c.Timeout); err != nil || c.timeout <= 0 {
+		return fmt.Errorf("processor timeout: invalid duration %q", c.Timeout)
+	}
+	if c.OnError == "" {
+		c.OnError = "pass"
+	}
+	if c.OnError != "pass" && c.OnError != "drop" {
+		return fmt.Errorf("processor on_error must be pass or drop, not %q", c.OnError)
+	}
+	return nil
+}
+
+// processor runs a route's lines through its external processor, in
+// batches like the route's adapter sends
+type processor struct {
+	route  *Route
+	config ProcessorConfig
+	client *http.Client
+}
+
+func newProcessor(route *Route) (*processor, error) {
+	proxy, err := route.Target.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	return &processor{
+		route:  route,
+		config: *route.Target.Processor,
+		client: &http.Client{
+			Timeout: route.Target.Processor.timeout,
+			Transport: route.SigningTransport(&http.Transport{
+				Proxy:       proxy,
+				DialContext: route.conn.Dialer().DialContext,
+			}),
+		},
+	}, nil
+}
+
+// sends the lines from in through the route's pipeline, then the
+// processor, and what it returns to out, closing out once in is closed
+func (p *processor) run(in <-chan *attach.Log, out chan<- *attach.Log) {
+	defer close(out)
+	items := make(chan *BatchItem)
+	go func() {
+		defer close(items)
+		for logline := range in {
+			logline, ok := p.route.process(logline)
+			if !ok {
+				continue
+			}
+			item := getItem(logline)
+			if err := json.NewEncoder(item.Buf).Encode(logline); err != nil {
+				p.route.status.Dropped("encoding", 1)
+				putBatch([]*BatchItem{item})
+				continue
+			}
+			items <- item
+		}
+	}()
+	runBatches(items, p.route.batch, func(batch []*BatchItem) {
+		defer putBatch(batch)
+		processed, err := p.process(batch)
+		if err != nil {
+			logging.Logger("processor").Error("processing failed", "route", p.route.ID, "err", err)
+			p.route.status.Failed(err)
+			if p.config.OnError == "drop" {
+				p.route.status.Dropped("processor", len(batch))
+				return
+			}
+			for _, item := range batch {
+				out <- item.Log
+			}
+			return
+		}
+		if len(processed) < len(batch) {
+			p.route.status.Skipped("processor", len(batch)-len(processed))
+		}
+		for _, logline := range processed {
+			out <- logline
+		}
+	})
+}
+
+// posts a batch to the service and returns the lines it answers with
+func (p *processor) process(batch []*BatchItem) ([]*attach.Log, error) {
+	body := GetBuffer()
+	defer PutBuffer(body)
+	for _, item := range batch {
+		body.Write(item.Buf.Bytes())
+	}
+	resp, err := p.client.Post(p.config.URL, "application/x-ndjson", bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("processor returned %s", resp.Status)
+	}
+	// what isn't in the JSON, like the profile, is kept from the batch's
+	// lines of the same container
+	profiles := make(map[string]*attach.Profile)
+	for _, item := range batch {
+		profiles[item.Log.ID] = item.Log.Profile
+	}
+	var lines []*attach.Log
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	for decoder.More() {
+		logline := new(attach.Log)
+		if err := decoder.Decode(logline); err != nil {
+			return nil, fmt.Errorf("processor response: %s", err)
+		}
+		logline.Profile = profiles[logline.ID]
+		lines = append(lines, logline)
+	}
+	return lines, nil
+}
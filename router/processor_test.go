@@ -0,0 +1,90 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+func init() {
+	RegisterAdapter("test", AdapterType{
+		New:     func(*Route) (Adapter, error) { return discardAdapter{}, nil },
+		Signs:   true,
+		Options: "test",
+	})
+	RegisterTargetOptions("test", func() TargetOptions { return new(testOptions) })
+	RegisterTargetOptions("other", func() TargetOptions { return new(testOptions) })
+}
+
+// discardAdapter drops what it's sent
+type discardAdapter struct{}
+
+func (discardAdapter) Stream(logstream chan *attach.Log) {
+	for range logstream {
+	}
+}
+
+// lines reach the processor after the route's pipeline, and keep what
+// isn't in their JSON
+func TestProcessorAfterPipeline(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		scanner := bufio.NewScanner(req.Body)
+		for scanner.Scan() {
+			var logline attach.Log
+			json.Unmarshal(scanner.Bytes(), &logline)
+			received = append(received, logline.Data)
+			logline.Data = "processed " + logline.Data
+			json.NewEncoder(w).Encode(&logline)
+		}
+	}))
+	defer server.Close()
+
+	route := &Route{ID: "r", Target: Target{
+		Type:      "test",
+		Addr:      "localhost:1",
+		Where:     `data != "drop me"`,
+		Processor: &ProcessorConfig{URL: server.URL},
+	}}
+	if err := route.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	route.status = NewRouteStatus(route)
+	proc, err := newProcessor(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := &attach.Profile{Name: "web"}
+	in, out := make(chan *attach.Log), make(chan *attach.Log)
+	go proc.run(in, out)
+	go func() {
+		for _, data := range []string{"keep me", "drop me", "me too"} {
+			in <- &attach.Log{ID: "c1", Data: data, Profile: profile}
+		}
+		close(in)
+	}()
+	var shipped []*attach.Log
+	for logline := range out {
+		if processed, ok := route.Process(logline); ok {
+			shipped = append(shipped, processed)
+		}
+	}
+	if len(received) != 2 || received[0] != "keep me" || received[1] != "me too" {
+		t.Errorf("processor received %q, want the lines where keeps", received)
+	}
+	if len(shipped) != 2 {
+		t.Fatalf("shipped %d lines, want 2", len(shipped))
+	}
+	for _, logline := range shipped {
+		if logline.Data[:10] != "processed " {
+			t.Errorf("shipped %q, not the processor's line", logline.Data)
+		}
+		if logline.Profile != profile {
+			t.Errorf("processed line lost its profile")
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package router
+
+import (
+	"time"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// how often WatchQueues checks how full the routes' queues are
+const queueCheckInterval = time.Second
+
+// warns whenever a route's queue stays at least percent full for sustained,
+// a sign its target is slowing down before its containers' streams back up
+// behind it, and notes when it's drained again. The warning is logged, so
+// it can be routed like logspout's other records.
+func (rm *RouteManager) WatchQueues(percent float64, sustained time.Duration) {
+	// when each route's queue went over, and whether it's been warned about
+	above := make(map[string]time.Time)
+	warned := make(map[string]bool)
+	for range time.Tick(queueCheckInterval) {
+		routes, _ := rm.GetAll()
+		current := make(map[string]bool, len(routes))
+		for _, route := range routes {
+			current[route.ID] = true
+			usage := route.Status().QueueUsage() * 100
+			if usage < percent {
+				if warned[route.ID] {
+					logging.Logger("route").Info("queue back below high water", "route", route.ID, "percent", usage)
+				}
+				delete(above, route.ID)
+				delete(warned, route.ID)
+				continue
+			}
+			since, ok := above[route.ID]
+			if !ok {
+				above[route.ID] = time.Now()
+				since = above[route.ID]
+			}
+			if !warned[route.ID] && time.Since(since) >= sustained {
+				logging.Logger("route").Warn("queue above high water", "route", route.ID, "target", route.Target.Type,
+					"percent", usage, "for", time.Since(since).Round(time.Second))
+				queueHighWater.WithLabelValues(route.ID).Inc()
+				warned[route.ID] = true
+			}
+		}
+		for id := range above {
+			if !current[id] {
+				delete(above, id)
+				delete(warned, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package router_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/router"
+
+	_ "github.com/jimmidyson/logspout/adapters/azure"
+	_ "github.com/jimmidyson/logspout/adapters/kafka"
+	_ "github.com/jimmidyson/logspout/adapters/logentries"
+	_ "github.com/jimmidyson/logspout/adapters/mongodb"
+	_ "github.com/jimmidyson/logspout/adapters/postgres"
+	_ "github.com/jimmidyson/logspout/adapters/ws"
+)
+
+// adapters' options with secrets are redacted like the target's own
+// fields, see TestRedacted
+func TestRedactedOptions(t *testing.T) {
+	tests := []struct{ target, secret string }{
+		{`{"type":"azure","addr":"workspace","azure":{"shared_key":"c2VjcmV0"}}`, "c2VjcmV0"},
+		{`{"type":"kafka","addr":"broker:9092","kafka":{"encoding":"avro","sasl":{"mechanism":"plain","username":"user","password":"secret"},"schema_registry":{"url":"http://registry:8081","password":"secret"}}}`, "secret"},
+		{`{"type":"logentries+tls","addr":"data.logentries.com","logentries":{"token":"secret"}}`, "secret"},
+		{`{"type":"mongodb","addr":"mongo:27017","mongodb":{"username":"user","password":"secret"}}`, "secret"},
+		{`{"type":"postgres","addr":"db:5432","postgres":{"username":"user","password":"secret"}}`, "secret"},
+		{`{"type":"wss","addr":"logs.example.com/ingest","websocket":{"headers":{"Authorization":"Bearer secret"}}}`, "secret"},
+	}
+	for _, test := range tests {
+		var route router.Route
+		body := `{"id":"abc","target":` + test.target + `}`
+		if err := attach.Unmarshal(io.NopCloser(strings.NewReader(body)), &route); err != nil {
+			t.Errorf("%s: %v", test.target, err)
+			continue
+		}
+		if err := route.Validate(); err != nil {
+			t.Errorf("%s: %v", test.target, err)
+			continue
+		}
+		if shown := string(attach.Marshal(route.Redacted())); strings.Contains(shown, test.secret) {
+			t.Errorf("redacted route shows a secret: %s", shown)
+		}
+		if shown := string(attach.Marshal(route.Target)); strings.Count(shown, test.secret) != strings.Count(test.target, test.secret) {
+			t.Errorf("%s: redacting changed the route to %s", test.target, shown)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// fields replayed lines are tagged with: replayed is true, and
+// replayed_from is the ID of the route whose archive they were read from
+const (
+	ReplayedField     = "replayed"
+	ReplayedFromField = "replayed_from"
+)
+
+// reads the lines the route archived that were logged from since up to
+// until, passing each to emit in the order they're read. Returns an error
+// if the route's target type doesn't archive, see AdapterType.Replay.
+func (r *Route) Replay(ctx context.Context, since, until time.Time, emit func(*attach.Log)) error {
+	adapter, ok := adapters[r.Target.Type]
+	if !ok || adapter.Replay == nil {
+		return fmt.Errorf("%s targets can't be replayed", r.Target.Type)
+	}
+	return adapter.Replay(ctx, r, since, until, emit)
+}
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// how often target addresses are re-resolved, see RESOLVE_INTERVAL
+var ResolveInterval = 30 * time.Second
+
+// minimum time between refreshes triggered by send failures
+const resolveBackoff = time.Second
+
+// AddrResolver keeps a target's addresses resolved to IPs. Addresses without
+// a port are first looked up as SRV records, falling back to the default port
+// for the target type. Addresses are re-resolved every interval and whenever
+// a streamer reports a failure.
+type AddrResolver struct {
+	sync.Mutex
+	target  Target
+	hosts   []string
+	addrs   []string
+	next    int
+	updated time.Time
+	done    chan struct{}
+}
+
+func NewAddrResolver(target Target, interval time.Duration) (*AddrResolver, error) {
+	r := &AddrResolver{target: target, done: make(chan struct{})}
+	if err := r.Refresh(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := r.Refresh(); err != nil {
+						logging.Logger("resolver").Warn("resolving failed", "err", err)
+					}
+				case <-r.done:
+					return
+				}
+			}
+		}()
+	}
+	return r, nil
+}
+
+// returns the SRV-expanded host:port pairs without resolving hostnames
+func (r *AddrResolver) Hosts() []string {
+	r.Lock()
+	defer r.Unlock()
+	return r.hosts
+}
+
+// returns the resolved ip:port to send to
+func (r *AddrResolver) Addr() string {
+	r.Lock()
+	defer r.Unlock()
+	return r.addrs[r.next%len(r.addrs)]
+}
+
+// moves on to the next resolved address and re-resolves the target, unless
+// that was done very recently
+func (r *AddrResolver) Failed() {
+	r.Lock()
+	r.next++
+	recent := time.Since(r.updated) < resolveBackoff
+	r.Unlock()
+	if recent {
+		return
+	}
+	if err := r.Refresh(); err != nil {
+		logging.Logger("resolver").Warn("resolving failed", "err", err)
+	}
+}
+
+func (r *AddrResolver) Refresh() error {
+	hosts, err := lookupHostPorts(r.target)
+	if err != nil {
+		return err
+	}
+	var addrs []string
+	for _, hostport := range hosts {
+		host, port, _ := net.SplitHostPort(hostport)
+		ips, lookupErr := net.LookupHost(host)
+		if lookupErr != nil {
+			err = lookupErr
+			continue
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+	if len(addrs) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no addresses found for %q", r.target.Addr)
+		}
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	if strings.Join(addrs, ",") != strings.Join(r.addrs, ",") {
+		logging.Logger("resolver").Debug("resolved", "addr", r.target.Addr, "addrs", addrs)
+		r.next = 0
+	}
+	r.hosts = hosts
+	r.addrs = addrs
+	r.updated = time.Now()
+	return nil
+}
+
+func (r *AddrResolver) Stop() {
+	close(r.done)
+}
+
+// expands the target's addresses to host:port pairs, using SRV records for
+// hostnames given without a port
+func lookupHostPorts(target Target) ([]string, error) {
+	var hostports []string
+	for _, addr := range strings.Split(target.Addr, ",") {
+		addr = strings.TrimSpace(addr)
+		if _, _, err := net.SplitHostPort(addr); err != nil && net.ParseIP(strings.Trim(addr, "[]")) == nil {
+			_, srvs, err := net.LookupSRV("", "", addr)
+			if err == nil && len(srvs) > 0 {
+				for _, srv := range srvs {
+					hostports = append(hostports,
+						net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+				}
+				continue
+			}
+		}
+		hostport, err := Target{Type: target.Type, Addr: addr}.HostPort()
+		if err != nil {
+			return nil, err
+		}
+		hostports = append(hostports, hostport)
+	}
+	return hostports, nil
+}
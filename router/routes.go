@@ -0,0 +1,342 @@
+// Package router runs routes, which ship the lines of the containers they
+// select to a target through an adapter.
+package router
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+// RouteStore persists the routes added through the API, such as a
+// RouteFileStore. Stores of other kinds are opened by URI, see
+// OpenRouteStore.
+type RouteStore interface {
+	Get(id string) (*Route, error)
+	GetAll() ([]*Route, error)
+	Add(route *Route) error
+	Remove(id string) bool
+}
+
+type RouteManager struct {
+	sync.Mutex
+	persistor RouteStore
+	attacher  *attach.AttachManager
+	routes    map[string]*Route
+	// IDs of the routes that are in the persistor
+	stored map[string]bool
+}
+
+func NewRouteManager(attacher *attach.AttachManager) *RouteManager {
+	return &RouteManager{attacher: attacher, routes: make(map[string]*Route), stored: make(map[string]bool)}
+}
+
+func (rm *RouteManager) Load(persistor RouteStore) error {
+	routes, err := persistor.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		if err := rm.Add(route); err != nil {
+			logging.Logger("persistor").Warn("skipping route", "route", route.ID, "err", err)
+			continue
+		}
+		rm.Lock()
+		rm.stored[route.ID] = true
+		rm.Unlock()
+	}
+	rm.persistor = persistor
+	if watcher, ok := persistor.(RouteWatcher); ok {
+		go watcher.Watch(context.Background(), func() {
+			if err := rm.Reload(); err != nil {
+				logging.Logger("persistor").Error("reloading routes failed", "err", err)
+			}
+		})
+	}
+	return nil
+}
+
+// re-reads the persistor, starting routes added to it, restarting changed
+// ones, including those whose credential files hold something else, and
+// stopping removed ones. Routes that were never persisted, like the one
+// from the command line, are left alone, as are unchanged ones.
+func (rm *RouteManager) Reload() error {
+	if rm.persistor == nil {
+		return nil
+	}
+	routes, err := rm.persistor.GetAll()
+	if err != nil {
+		return err
+	}
+	log := logging.Logger("persistor")
+	rm.Lock()
+	defer rm.Unlock()
+	found := make(map[string]bool)
+	for _, route := range routes {
+		found[route.ID] = true
+		if err := route.Validate(); err != nil {
+			log.Warn("skipping route", "route", route.ID, "err", err)
+			continue
+		}
+		// compared once validating has filled in defaults, as the running
+		// route's are, and with what its credential files hold, as they can
+		// change without its JSON
+		existing, ok := rm.routes[route.ID]
+		if ok && string(attach.Marshal(existing)) == string(attach.Marshal(route)) &&
+			existing.credentials == route.credentials {
+			continue
+		}
+		if ok {
+			existing.cancel()
+			delete(rm.routes, route.ID)
+			log.Info("restarting changed route", "route", route.ID)
+		} else {
+			log.Info("starting route", "route", route.ID)
+		}
+		if err := rm.start(route); err != nil {
+			log.Warn("skipping route", "route", route.ID, "err", err)
+			continue
+		}
+		rm.stored[route.ID] = true
+	}
+	for id := range rm.stored {
+		if found[id] {
+			continue
+		}
+		if route, ok := rm.routes[id]; ok {
+			route.cancel()
+			delete(rm.routes, id)
+			log.Info("stopping removed route", "route", id)
+		}
+		delete(rm.stored, id)
+	}
+	return nil
+}
+
+// restarts the running routes, persisted or not, whose credential files
+// hold something else than when they were validated, so rotated keys and
+// passwords are put into effect. Routes that don't validate with the new
+// files, as while they're being replaced, carry on as they were.
+func (rm *RouteManager) RestartChangedCredentials() {
+	log := logging.Logger("route")
+	rm.Lock()
+	defer rm.Unlock()
+	for id, existing := range rm.routes {
+		if existing.Target.credentialsDigest() == existing.credentials {
+			continue
+		}
+		route := new(Route)
+		if err := json.Unmarshal(attach.Marshal(existing), route); err != nil {
+			log.Error("copying route failed", "route", id, "err", err)
+			continue
+		}
+		if err := route.Validate(); err != nil {
+			log.Warn("not restarting route with changed credentials", "route", id, "err", err)
+			continue
+		}
+		existing.cancel()
+		delete(rm.routes, id)
+		log.Info("restarting route with changed credentials", "route", id)
+		if err := rm.start(route); err != nil {
+			log.Warn("skipping route", "route", id, "err", err)
+		}
+	}
+}
+
+func (rm *RouteManager) Get(id string) (*Route, error) {
+	rm.Lock()
+	defer rm.Unlock()
+	route, ok := rm.routes[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return route, nil
+}
+
+func (rm *RouteManager) GetAll() ([]*Route, error) {
+	rm.Lock()
+	defer rm.Unlock()
+	routes := make([]*Route, 0)
+	for _, route := range rm.routes {
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// reports whether there are routes and every one's most recent delivery
+// attempt failed, so logs aren't being shipped anywhere
+func (rm *RouteManager) AllFailing() bool {
+	routes, _ := rm.GetAll()
+	for _, route := range routes {
+		if route.Status().Healthy() {
+			return false
+		}
+	}
+	return len(routes) > 0
+}
+
+func (rm *RouteManager) Add(route *Route) error {
+	if err := route.Validate(); err != nil {
+		return err
+	}
+	rm.Lock()
+	defer rm.Unlock()
+	if route.ID == "" {
+		h := sha1.New()
+		io.WriteString(h, strconv.Itoa(int(time.Now().UnixNano())))
+		route.ID = fmt.Sprintf("%x", h.Sum(nil))[:12]
+	}
+	if route.CreatedAt == nil {
+		now := time.Now().UTC().Truncate(time.Second)
+		route.CreatedAt = &now
+	}
+	if err := rm.start(route); err != nil {
+		return err
+	}
+	if rm.persistor != nil {
+		if err := rm.persistor.Add(route); err != nil {
+			logging.Logger("persistor").Error("saving route failed", "route", route.ID, "err", err)
+		} else {
+			rm.stored[route.ID] = true
+		}
+	}
+	return nil
+}
+
+// starts shipping a validated route's logs with a new adapter for its
+// target. Must be called with the lock held.
+func (rm *RouteManager) start(route *Route) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	route.cancel = cancel
+	route.done = make(chan struct{})
+	route.status = NewRouteStatus(route)
+	if route.Source != nil {
+		// pipeline stages can change a line's severity
+		route.Source.LaterSeverity = len(route.stages) > 0
+	}
+	adapter, err := newAdapter(route)
+	if err != nil {
+		cancel()
+		return err
+	}
+	var proc *processor
+	if route.Target.Processor != nil {
+		if proc, err = newProcessor(route); err != nil {
+			cancel()
+			return err
+		}
+	}
+	rm.routes[route.ID] = route
+	go func() {
+		logstream := make(chan *attach.Log)
+		defer close(logstream)
+		stream := logstream
+		if proc != nil {
+			stream = make(chan *attach.Log)
+			go proc.run(logstream, stream)
+		}
+		go func() {
+			adapter.Stream(stream)
+			close(route.done)
+		}()
+		rm.attacher.Listen(ctx, route.Source, logstream)
+	}()
+	return nil
+}
+
+// stops every route and waits up to timeout for their adapters to send
+// what they have, returning false if some didn't finish in time. Persisted
+// routes stay persisted.
+func (rm *RouteManager) Shutdown(timeout time.Duration) bool {
+	rm.Lock()
+	var routes []*Route
+	for _, route := range rm.routes {
+		route.cancel()
+		routes = append(routes, route)
+	}
+	rm.Unlock()
+	deadline := time.After(timeout)
+	for _, route := range routes {
+		select {
+		case <-route.done:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+func (rm *RouteManager) Remove(id string) bool {
+	rm.Lock()
+	defer rm.Unlock()
+	route, ok := rm.routes[id]
+	if ok && route.cancel != nil {
+		route.cancel()
+	}
+	delete(rm.routes, id)
+	delete(rm.stored, id)
+	if rm.persistor != nil {
+		rm.persistor.Remove(id)
+	}
+	return ok
+}
+
+type RouteFileStore string
+
+func (fs RouteFileStore) Filename(id string) string {
+	return string(fs) + "/" + id + ".json"
+}
+
+func (fs RouteFileStore) Get(id string) (*Route, error) {
+	file, err := os.Open(fs.Filename(id))
+	if err != nil {
+		return nil, err
+	}
+	route := new(Route)
+	if err = attach.Unmarshal(file, route); err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+func (fs RouteFileStore) GetAll() ([]*Route, error) {
+	files, err := ioutil.ReadDir(string(fs))
+	if err != nil {
+		return nil, err
+	}
+	var routes []*Route
+	for _, file := range files {
+		fileparts := strings.Split(file.Name(), ".")
+		if len(fileparts) > 1 && fileparts[1] == "json" {
+			route, err := fs.Get(fileparts[0])
+			if err == nil {
+				routes = append(routes, route)
+			}
+		}
+	}
+	return routes, nil
+}
+
+func (fs RouteFileStore) Add(route *Route) error {
+	return ioutil.WriteFile(fs.Filename(route.ID), attach.Marshal(route), 0644)
+}
+
+func (fs RouteFileStore) Remove(id string) bool {
+	if _, err := os.Stat(fs.Filename(id)); err == nil {
+		if err := os.Remove(fs.Filename(id)); err != nil {
+			return true
+		}
+	}
+	return false
+}
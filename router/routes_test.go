@@ -0,0 +1,142 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// memStore keeps routes as JSON, so each read gets new routes, as it does
+// from files
+type memStore struct {
+	sync.Mutex
+	routes map[string][]byte
+}
+
+func (s *memStore) Get(id string) (*Route, error) {
+	s.Lock()
+	defer s.Unlock()
+	data, ok := s.routes[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	route := new(Route)
+	return route, attach.Unmarshal(io.NopCloser(bytes.NewReader(data)), route)
+}
+
+func (s *memStore) GetAll() ([]*Route, error) {
+	s.Lock()
+	ids := make([]string, 0, len(s.routes))
+	for id := range s.routes {
+		ids = append(ids, id)
+	}
+	s.Unlock()
+	var routes []*Route
+	for _, id := range ids {
+		route, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+func (s *memStore) Add(route *Route) error {
+	s.Lock()
+	defer s.Unlock()
+	s.routes[route.ID] = attach.Marshal(route)
+	return nil
+}
+
+func (s *memStore) Remove(id string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.routes[id]
+	delete(s.routes, id)
+	return ok
+}
+
+func TestReloadCredentialFiles(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().UTC().Truncate(time.Second)
+	store := &memStore{routes: make(map[string][]byte)}
+	store.Add(&Route{ID: "signed", CreatedAt: &created, Target: Target{Type: "test", Addr: "host:1", Sign: &SignConfig{KeyFile: keyFile}}})
+	store.Add(&Route{ID: "plain", CreatedAt: &created, Target: Target{Type: "test", Addr: "host:1"}})
+	rm := NewRouteManager(attach.NewInputManager())
+	if err := rm.Load(store); err != nil {
+		t.Fatal(err)
+	}
+	signed, _ := rm.Get("signed")
+	plain, _ := rm.Get("plain")
+
+	// unchanged routes keep running
+	if err := rm.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if route, _ := rm.Get("signed"); route != signed {
+		t.Error("unchanged route was restarted")
+	}
+
+	// a new key, with the same JSON, restarts the route that reads it
+	if err := os.WriteFile(keyFile, []byte("second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	route, _ := rm.Get("signed")
+	if route == signed {
+		t.Fatal("route wasn't restarted when its key file changed")
+	}
+	if string(route.Target.Sign.key) != "second" {
+		t.Errorf("restarted route signs with %q, want the new key", route.Target.Sign.key)
+	}
+	if route, _ := rm.Get("plain"); route != plain {
+		t.Error("route without credential files was restarted")
+	}
+}
+
+func TestRestartChangedCredentials(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rm := NewRouteManager(attach.NewInputManager())
+	// not persisted, as routes from the command line aren't
+	if err := rm.Add(&Route{ID: "signed", Target: Target{Type: "test", Addr: "host:1", Sign: &SignConfig{KeyFile: keyFile}}}); err != nil {
+		t.Fatal(err)
+	}
+	signed, _ := rm.Get("signed")
+	rm.RestartChangedCredentials()
+	if route, _ := rm.Get("signed"); route != signed {
+		t.Fatal("route was restarted without its credentials changing")
+	}
+
+	// while the file is being replaced, the route carries on
+	if err := os.WriteFile(keyFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	rm.RestartChangedCredentials()
+	if route, _ := rm.Get("signed"); route != signed {
+		t.Fatal("route was restarted with an empty key file")
+	}
+
+	if err := os.WriteFile(keyFile, []byte("second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rm.RestartChangedCredentials()
+	route, _ := rm.Get("signed")
+	if route == signed || string(route.Target.Sign.key) != "second" {
+		t.Error("route wasn't restarted with its new key")
+	}
+}
@@ -0,0 +1,107 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// versions of the shape of JSON lines. v1, the default, is attach.Log's
+// own fields. v2 has the message and its times at the top, and where it
+// came from in docker, k8s and host objects. Versions are only added, so
+// consumers of a version keep getting what they expect.
+var schemas = []string{"v1", "v2"}
+
+// adds the fields of a line in the v1 shape to doc
+func v1Doc(logline *attach.Log, doc map[string]interface{}) {
+	doc["id"] = logline.ID
+	doc["name"] = logline.Name
+	doc["image"] = logline.Image
+	doc["type"] = logline.Type
+	doc["data"] = logline.Data
+	doc["time"] = logline.Time
+	doc["timestamp"] = logline.Timestamp
+	if logline.Severity != "" {
+		doc["severity"] = logline.Severity
+	}
+	if logline.Truncated {
+		doc["truncated"] = true
+	}
+	if len(logline.Fields) > 0 {
+		doc["fields"] = logline.Fields
+	}
+}
+
+// adds the fields of a line in the v2 shape to doc, host being the one
+// logspout runs on
+func v2Doc(logline *attach.Log, host map[string]interface{}, doc map[string]interface{}) {
+	doc["schema"] = "v2"
+	doc["message"] = logline.Data
+	doc["stream"] = logline.Type
+	doc["time"] = logline.Time
+	doc["timestamp"] = logline.Timestamp
+	if logline.Severity != "" {
+		doc["severity"] = logline.Severity
+	}
+	if logline.Truncated {
+		doc["truncated"] = true
+	}
+	if len(logline.Fields) > 0 {
+		doc["fields"] = logline.Fields
+	}
+	doc["docker"] = map[string]interface{}{"container_id": logline.ID, "container_name": logline.Name, "image": logline.Image}
+	if k8sContainer := attach.CachedK8sContainer(logline.Name); k8sContainer != nil {
+		doc["k8s"] = map[string]interface{}{"namespace": k8sContainer.Namespace, "pod": k8sContainer.Pod, "container": k8sContainer.Name}
+	}
+	doc["host"] = host
+}
+
+// returns an encoder of JSON lines in the target's Schema, with top level
+// keys renamed by FieldNames, which are matched ignoring case, and the keys
+// that aren't, along with those of the objects below them, changed to
+// FieldCase
+func (t Target) shapedEncoder() func(*attach.Log, *bytes.Buffer) error {
+	cased := func(key string) string {
+		switch t.FieldCase {
+		case "lower":
+			return strings.ToLower(key)
+		case "upper":
+			return strings.ToUpper(key)
+		}
+		return key
+	}
+	renames := make(map[string]string, len(t.FieldNames))
+	for field, renamed := range t.FieldNames {
+		if renamed != "" {
+			renames[strings.ToLower(field)] = renamed
+		}
+	}
+	host := map[string]interface{}{"name": Hostname}
+	return func(logline *attach.Log, buf *bytes.Buffer) error {
+		doc, shaped := GetDoc(), GetDoc()
+		defer PutDoc(doc)
+		defer PutDoc(shaped)
+		if t.Schema == "v2" {
+			v2Doc(logline, host, doc)
+		} else {
+			v1Doc(logline, doc)
+		}
+		for key, value := range doc {
+			if object, ok := value.(map[string]interface{}); ok && t.FieldCase != "" {
+				casedObject := make(map[string]interface{}, len(object))
+				for field, value := range object {
+					casedObject[cased(field)] = value
+				}
+				value = casedObject
+			}
+			if renamed, ok := renames[key]; ok {
+				shaped[renamed] = value
+			} else {
+				shaped[cased(key)] = value
+			}
+		}
+		return json.NewEncoder(buf).Encode(shaped)
+	}
+}
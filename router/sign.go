@@ -0,0 +1,94 @@
+package router
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// header signatures are sent in by default
+const defaultSignHeader = "X-Logspout-Signature"
+
+// SignConfig signs what's sent to a target with HMAC-SHA256, so receivers
+// holding the key can verify it came from logspout. Signatures are
+// sha256= followed by the hex HMAC of the payload: the body of HTTP
+// requests, or the value of Kafka records.
+type SignConfig struct {
+	// the key, or a file it's read from when the route is loaded
+	Key     string `json:"key,omitempty"`
+	KeyFile string `json:"key_file,omitempty"`
+	// header the signature is sent in, X-Logspout-Signature by default
+	Header string `json:"header,omitempty"`
+
+	key []byte
+}
+
+func (c *SignConfig) normalize() error {
+	if (c.Key == "") == (c.KeyFile == "") {
+		return fmt.Errorf("sign must set one of key and key_file")
+	}
+	c.key = []byte(c.Key)
+	if c.KeyFile != "" {
+		data, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("sign key_file: %s", err)
+		}
+		c.key = []byte(strings.TrimRight(string(data), "\r\n"))
+		if len(c.key) == 0 {
+			return fmt.Errorf("sign key_file: %s is empty", c.KeyFile)
+		}
+	}
+	if c.Header == "" {
+		c.Header = defaultSignHeader
+	}
+	return nil
+}
+
+// returns the header to send and the signature of payload, or empty
+// strings if the target isn't signed
+func (r *Route) Sign(payload []byte) (header, signature string) {
+	if r.Target.Sign == nil {
+		return "", ""
+	}
+	mac := hmac.New(sha256.New, r.Target.Sign.key)
+	mac.Write(payload)
+	return r.Target.Sign.Header, "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// returns a transport that signs the bodies of requests next sends, if the
+// target is signed
+func (r *Route) SigningTransport(next http.RoundTripper) http.RoundTripper {
+	if r.Target.Sign == nil {
+		return next
+	}
+	return signingTransport{route: r, next: next}
+}
+
+type signingTransport struct {
+	route *Route
+	next  http.RoundTripper
+}
+
+func (t signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	header, signature := t.route.Sign(body)
+	req.Header.Set(header, signature)
+	return t.next.RoundTrip(req)
+}
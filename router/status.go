@@ -0,0 +1,220 @@
+package router
+
+import (
+	"maps"
+	"math"
+	"sync"
+	"time"
+)
+
+// RouteStatus tracks how a route's deliveries are going, as reported by its
+// streamer
+type RouteStatus struct {
+	sync.Mutex
+	routeID     string
+	targetType  string
+	received    int64
+	shipped     int64
+	dropped     int64
+	errors      int64
+	LastError   string
+	LastErrorAt time.Time
+	LastSentAt  time.Time
+
+	// lines lost by reason, adding up to dropped
+	droppedBy map[string]int64
+	// lines the route's filters chose not to ship, by reason
+	skipped map[string]int64
+	// lines and bytes handed to the adapter's sender that it hasn't
+	// delivered or dropped yet, and the most it can hold, zero for no limit
+	queuedLines, queuedBytes   int64
+	lineCapacity, byteCapacity int64
+}
+
+func NewRouteStatus(route *Route) *RouteStatus {
+	return &RouteStatus{
+		routeID:    route.ID,
+		targetType: route.Target.Type,
+		droppedBy:  make(map[string]int64),
+		skipped:    make(map[string]int64),
+	}
+}
+
+// records a line arriving at the route's streamer
+func (s *RouteStatus) Received() {
+	linesReceived.WithLabelValues(s.routeID).Inc()
+	s.Lock()
+	defer s.Unlock()
+	s.received++
+}
+
+// records a line delivered to the target, size bytes once encoded
+func (s *RouteStatus) Sent(size int) {
+	linesShipped.WithLabelValues(s.routeID).Inc()
+	bytesShipped.WithLabelValues(s.routeID).Add(float64(size))
+	s.Lock()
+	defer s.Unlock()
+	s.shipped++
+	s.LastSentAt = time.Now()
+}
+
+// records how long the adapter took to send a batch, whether or not it was
+// delivered. RunWorkers records it for adapters that use it.
+func (s *RouteStatus) SendDuration(took time.Duration) {
+	sendDuration.WithLabelValues(s.routeID, s.targetType).Observe(took.Seconds())
+}
+
+// records an adapter error. Lines lost to it are recorded with Dropped.
+func (s *RouteStatus) Failed(err error) {
+	adapterErrors.WithLabelValues(s.routeID, s.targetType).Inc()
+	s.Lock()
+	defer s.Unlock()
+	s.errors++
+	s.LastError = err.Error()
+	s.LastErrorAt = time.Now()
+}
+
+// records lines lost before reaching the target: encoding for lines that
+// couldn't be encoded, error for lines the target didn't accept and
+// processor for lines a failing processor dropped
+func (s *RouteStatus) Dropped(reason string, lines int) {
+	linesDropped.WithLabelValues(s.routeID, reason).Add(float64(lines))
+	s.Lock()
+	defer s.Unlock()
+	s.dropped += int64(lines)
+	s.droppedBy[reason] += int64(lines)
+}
+
+// records lines the route chose not to ship: where, sampled or plugin for
+// the pipeline stage that dropped them, source for lines that didn't match
+// the route's source after the pipeline, processor for lines a processor
+// didn't return and replayed for lines replayed from the route's own archive
+func (s *RouteStatus) Skipped(reason string, lines int) {
+	linesSkipped.WithLabelValues(s.routeID, reason).Add(float64(lines))
+	s.Lock()
+	defer s.Unlock()
+	s.skipped[reason] += int64(lines)
+}
+
+// returns the lines dropped and skipped so far, by reason
+func (s *RouteStatus) Counts() (dropped, skipped map[string]int64) {
+	s.Lock()
+	defer s.Unlock()
+	return maps.Clone(s.droppedBy), maps.Clone(s.skipped)
+}
+
+func (s *RouteStatus) Reconnected() {
+	routeReconnects.WithLabelValues(s.routeID).Inc()
+}
+
+// sets the most lines and bytes the adapter holds waiting to be sent before
+// it stops taking more. Zero doesn't limit either.
+func (s *RouteStatus) SetQueueCapacity(lines, bytes int) {
+	s.Lock()
+	defer s.Unlock()
+	s.lineCapacity, s.byteCapacity = int64(lines), int64(bytes)
+	s.updateQueue()
+}
+
+// records lines handed to the adapter's sender, bytes once encoded
+func (s *RouteStatus) Queued(lines, bytes int) {
+	s.Lock()
+	defer s.Unlock()
+	s.queuedLines += int64(lines)
+	s.queuedBytes += int64(bytes)
+	s.updateQueue()
+}
+
+// records queued lines the sender has delivered or dropped
+func (s *RouteStatus) Dequeued(lines, bytes int) {
+	s.Queued(-lines, -bytes)
+}
+
+// returns how full the adapter's queue is by whichever of lines and bytes
+// is nearer its capacity. Must be called with the lock held.
+func (s *RouteStatus) queueUsage() float64 {
+	usage := 0.0
+	if s.lineCapacity > 0 {
+		usage = float64(s.queuedLines) / float64(s.lineCapacity)
+	}
+	if s.byteCapacity > 0 {
+		usage = max(usage, float64(s.queuedBytes)/float64(s.byteCapacity))
+	}
+	return min(usage, 1)
+}
+
+// returns how full the adapter's queue is, from 0 to 1
+func (s *RouteStatus) QueueUsage() float64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.queueUsage()
+}
+
+func (s *RouteStatus) updateQueue() {
+	queuedLines.WithLabelValues(s.routeID).Set(float64(s.queuedLines))
+	queueUsage.WithLabelValues(s.routeID).Set(s.queueUsage())
+}
+
+// a route is healthy unless its most recent delivery attempt failed
+func (s *RouteStatus) Healthy() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.healthy()
+}
+
+func (s *RouteStatus) healthy() bool {
+	return s.LastErrorAt.IsZero() || s.LastSentAt.After(s.LastErrorAt)
+}
+
+type RouteReport struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name,omitempty"`
+	Target      Target     `json:"target"`
+	Healthy     bool       `json:"healthy"`
+	Received    int64      `json:"received"`
+	Shipped     int64      `json:"shipped"`
+	Dropped     int64      `json:"dropped"`
+	Errors      int64      `json:"errors"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	LastSentAt  *time.Time `json:"last_sent_at,omitempty"`
+
+	// dropped and skipped lines by reason, see Dropped and Skipped
+	DroppedBy map[string]int64 `json:"dropped_by_reason,omitempty"`
+	Skipped   map[string]int64 `json:"skipped,omitempty"`
+	// lines waiting to be sent, and how full the queue is as a percentage
+	QueuedLines  int64   `json:"queued_lines"`
+	QueuedBytes  int64   `json:"queued_bytes"`
+	QueuePercent float64 `json:"queue_percent"`
+}
+
+func (s *RouteStatus) Report(route *Route) RouteReport {
+	s.Lock()
+	defer s.Unlock()
+	report := RouteReport{
+		ID:        route.ID,
+		Name:      route.Name,
+		Target:    route.Target,
+		Healthy:   s.healthy(),
+		Received:  s.received,
+		Shipped:   s.shipped,
+		Dropped:   s.dropped,
+		DroppedBy: maps.Clone(s.droppedBy),
+		Skipped:   maps.Clone(s.skipped),
+
+		QueuedLines:  s.queuedLines,
+		QueuedBytes:  s.queuedBytes,
+		QueuePercent: math.Round(s.queueUsage() * 100),
+		Errors:       s.errors,
+		LastError:    s.LastError,
+	}
+	if !s.LastErrorAt.IsZero() {
+		lastErrorAt := s.LastErrorAt
+		report.LastErrorAt = &lastErrorAt
+	}
+	if !s.LastSentAt.IsZero() {
+		lastSentAt := s.LastSentAt
+		report.LastSentAt = &lastSentAt
+	}
+	return report
+}
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// RouteWatcher is a RouteStore that can tell when its routes are changed
+// from elsewhere, so they're reloaded without a SIGHUP. Watch calls changed
+// after each change until ctx is done, carrying on through errors.
+type RouteWatcher interface {
+	RouteStore
+	Watch(ctx context.Context, changed func())
+}
+
+// RouteStoreType opens the route stores of a URI scheme
+type RouteStoreType func(u *url.URL) (RouteStore, error)
+
+// registered route store types by URI scheme
+var routeStores = map[string]RouteStoreType{
+	"file": func(u *url.URL) (RouteStore, error) {
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("file route store must be on this host, not %q", u.Host)
+		}
+		return RouteFileStore(u.Path), nil
+	},
+}
+
+// registers a route store type for URIs with scheme. Stores register from
+// init functions, as adapters do.
+func RegisterRouteStore(scheme string, open RouteStoreType) {
+	if _, exists := routeStores[scheme]; exists {
+		panic("route store " + scheme + " registered twice")
+	}
+	routeStores[scheme] = open
+}
+
+// returns the schemes of the registered route store types, sorted
+func RouteStoreTypes() []string {
+	var names []string
+	for name := range routeStores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// opens the route store a URI names, such as file:///var/lib/logspout or
+// consul://localhost:8500/logspout/routes. A URI without a scheme is a
+// directory, as for file.
+func OpenRouteStore(uri string) (RouteStore, error) {
+	if !strings.Contains(uri, "://") {
+		return RouteFileStore(uri), nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	open, ok := routeStores[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown route store %q, must be one of %s", u.Scheme, strings.Join(RouteStoreTypes(), ", "))
+	}
+	return open(u)
+}
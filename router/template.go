@@ -0,0 +1,64 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+// funcs available to route templates
+var templateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	"rfc3339": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339Nano)
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// templateData is what a route template renders: the log's fields plus
+// the Kubernetes naming of its container, which is empty for other containers
+type templateData struct {
+	*attach.Log
+	K8s     *attach.K8sContainer
+	Message string
+}
+
+// compiles the text of a route template, with the functions and data
+// Target.Template has. Adapters compile theirs, like kafka's topic, with it.
+func CompileTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("route").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template: %s", err)
+	}
+	return tmpl, nil
+}
+
+// renders a line with the route's template, or with its message and
+// parsed fields if it doesn't have one
+func (r *Route) Render(logline *attach.Log) (string, error) {
+	if r.template == nil {
+		return TextWithFields(logline), nil
+	}
+	return RenderTemplate(r.template, logline)
+}
+
+// renders a line with a template from CompileTemplate
+func RenderTemplate(tmpl *template.Template, logline *attach.Log) (string, error) {
+	data := templateData{Log: logline, K8s: attach.CachedK8sContainer(logline.Name), Message: logline.Message()}
+	if data.K8s == nil {
+		data.K8s = new(attach.K8sContainer)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,167 @@
+package router
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// TLSConfig secures a target's connections, for the *+tls target types.
+// Settings a target leaves unset are taken from DefaultTLS, and targets
+// without either still verify the target's certificate against the system's
+// CAs.
+type TLSConfig struct {
+	// PEM file of the CAs the target's certificate must be signed by, the
+	// system's by default
+	CA string `json:"ca,omitempty"`
+	// PEM files of the certificate and key presented to the target, for
+	// mutual TLS
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// name the target's certificate must be for, the host dialed by default
+	ServerName string `json:"server_name,omitempty"`
+	// oldest TLS version negotiated, "1.0" to "1.3", 1.2 by default
+	MinVersion string `json:"min_version,omitempty"`
+	// cipher suites offered for TLS 1.2 and older, named as in crypto/tls,
+	// Go's defaults if empty. TLS 1.3's aren't configurable.
+	CipherSuites []string `json:"cipher_suites,omitempty"`
+}
+
+// TLS settings of targets that don't set them, from the TARGET_TLS_*
+// settings, see SetDefaultTLS
+var DefaultTLS *TLSConfig
+
+// checks the settings of targets that don't set them and makes them the
+// default
+func SetDefaultTLS(c *TLSConfig) error {
+	if _, err := c.config(); err != nil {
+		return err
+	}
+	DefaultTLS = c
+	return nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// returns the target's settings, with those it leaves unset taken from
+// DefaultTLS
+func (c *TLSConfig) withDefaults() *TLSConfig {
+	merged := TLSConfig{}
+	if DefaultTLS != nil {
+		merged = *DefaultTLS
+	}
+	if c == nil {
+		return &merged
+	}
+	if c.CA != "" {
+		merged.CA = c.CA
+	}
+	if c.Cert != "" || c.Key != "" {
+		merged.Cert, merged.Key = c.Cert, c.Key
+	}
+	if c.ServerName != "" {
+		merged.ServerName = c.ServerName
+	}
+	if c.MinVersion != "" {
+		merged.MinVersion = c.MinVersion
+	}
+	if c.CipherSuites != nil {
+		merged.CipherSuites = c.CipherSuites
+	}
+	return &merged
+}
+
+// loads the files and checks the settings go together
+func (c *TLSConfig) config() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.ServerName, MinVersion: tls.VersionTLS12}
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("tls min_version must be 1.0, 1.1, 1.2 or 1.3, not %q", c.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	if len(c.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range c.CipherSuites {
+			id, ok := suites[strings.ToUpper(name)]
+			if !ok {
+				return nil, fmt.Errorf("tls cipher_suites: unknown suite %q", name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+	if c.CA != "" {
+		pem, err := os.ReadFile(c.CA)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca: %s", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls ca: no certificates found in %s", c.CA)
+		}
+	}
+	if (c.Cert == "") != (c.Key == "") {
+		return nil, fmt.Errorf("tls cert and key must be set together")
+	}
+	if c.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("tls cert: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// reports whether the target's type is a *+tls one
+func (t Target) UsesTLS() bool {
+	return strings.HasSuffix(t.Type, "+tls")
+}
+
+// returns the TLS config for connecting to host, following the target's TLS
+// settings. An empty host leaves the server name to the caller, as
+// http.Transport sets it.
+func (r *Route) TLSConfig(host string) *tls.Config {
+	config := r.tlsConfig.Clone()
+	if config == nil {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	return config
+}
+
+// dials addr over TCP, completing a TLS handshake for *+tls targets with
+// the first of the target's hosts as the server name
+func (r *Route) DialTCP(addr string) (net.Conn, error) {
+	conn, err := r.conn.Dialer().Dial("tcp", addr)
+	if err != nil || !r.Target.UsesTLS() {
+		return conn, err
+	}
+	host := ""
+	if hostports, err := r.Target.HostPorts(); err == nil {
+		host, _, _ = net.SplitHostPort(hostports[0])
+	}
+	tlsConn := tls.Client(conn, r.TLSConfig(host))
+	tlsConn.SetDeadline(r.conn.WriteDeadline())
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
@@ -0,0 +1,405 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+)
+
+type Route struct {
+	ID string `json:"id"`
+	// what the route is for, for those managing a host's routes
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// when the route was added, and who by if it was through the API
+	CreatedAt *time.Time     `json:"created_at,omitempty"`
+	CreatedBy string         `json:"created_by,omitempty"`
+	Source    *attach.Source `json:"source,omitempty"`
+	Target    Target         `json:"target"`
+	cancel    context.CancelFunc
+	status    *RouteStatus
+	stages    []Stage
+	template  *template.Template
+	batch     BatchConfig
+	conn      ConnConfig
+	// the TLS settings of *+tls targets, see TLSConfig
+	tlsConfig *tls.Config
+	// digest of what the target's credential files held when the route was
+	// validated
+	credentials string
+	// closed once the adapter has sent everything the route gave it
+	done chan struct{}
+}
+
+// longest name a route can have
+const maxRouteName = 200
+
+// what secrets in routes are replaced with by Redacted
+const RedactedSecret = "[redacted]"
+
+// returns a copy of the route with sign keys and the secrets of its target
+// options, see SecretOptions, replaced, and credentials in the userinfo of
+// its addresses, proxy and processor removed, for showing to those who
+// shouldn't see them
+func (r *Route) Redacted() *Route {
+	copied := *r
+	copied.Target.Addr = stripUserinfo(r.Target.Addr)
+	copied.Target.Proxy = stripUserinfo(r.Target.Proxy)
+	if processor := r.Target.Processor; processor != nil {
+		processor := *processor
+		processor.URL = stripUserinfo(processor.URL)
+		copied.Target.Processor = &processor
+	}
+	if sign := r.Target.Sign; sign != nil && sign.Key != "" {
+		sign := *sign
+		sign.Key = RedactedSecret
+		copied.Target.Sign = &sign
+	}
+	if len(r.Target.options) > 0 {
+		copied.Target.options = make(map[string]TargetOptions, len(r.Target.options))
+		for key, options := range r.Target.options {
+			if secret, ok := options.(SecretOptions); ok {
+				options = secret.Redacted()
+			}
+			copied.Target.options[key] = options
+		}
+	}
+	return &copied
+}
+
+// returns a comma-separated list of URLs or addresses without the
+// user:password@ of any of them
+func stripUserinfo(addrs string) string {
+	if !strings.Contains(addrs, "@") {
+		return addrs
+	}
+	parts := strings.Split(addrs, ",")
+	for i, part := range parts {
+		if u, err := url.Parse(strings.TrimSpace(part)); err == nil && u.Scheme != "" && u.Host != "" {
+			u.User = nil
+			parts[i] = u.String()
+		} else if at := strings.LastIndex(part, "@"); at >= 0 {
+			parts[i] = part[at+1:]
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// returns the delivery status the route's adapter reports to
+func (r *Route) Status() *RouteStatus {
+	return r.status
+}
+
+// returns the route's batching, with defaults filled in by Validate
+func (r *Route) Batch() BatchConfig {
+	return r.batch
+}
+
+// returns the route's connection tuning, with defaults filled in by
+// Validate
+func (r *Route) Conn() ConnConfig {
+	return r.conn
+}
+
+// checks the route has a known target type with a usable address and a
+// valid source
+func (r *Route) Validate() error {
+	adapter, ok := adapters[r.Target.Type]
+	if !ok {
+		return fmt.Errorf("unknown target type %q", r.Target.Type)
+	}
+	if r.Target.Addr == "" {
+		return fmt.Errorf("target addr is required")
+	}
+	if len(r.Name) > maxRouteName {
+		return fmt.Errorf("name must be at most %d characters", maxRouteName)
+	}
+	for label := range r.Labels {
+		if label == "" {
+			return fmt.Errorf("label names can't be empty")
+		}
+	}
+	if adapter.ValidateAddr != nil {
+		if err := adapter.ValidateAddr(r.Target.Addr); err != nil {
+			return err
+		}
+	} else if _, err := r.Target.HostPorts(); err != nil {
+		return err
+	}
+	if c := r.Target.Compression; c != "" && c != "none" && !slices.Contains(adapter.Compressions, c) {
+		return fmt.Errorf("%s targets don't support %q compression", r.Target.Type, c)
+	}
+	if e := r.Target.Encoding; e != "" && e != "json" && !slices.Contains(adapter.Encodings, e) {
+		return fmt.Errorf("%s targets don't support %q encoding", r.Target.Type, e)
+	}
+	switch r.Target.FieldCase {
+	case "", "lower", "upper":
+	default:
+		return fmt.Errorf("field_case must be lower or upper")
+	}
+	if r.Target.FieldCase != "" && !adapter.ShapesJSON {
+		return fmt.Errorf("%s targets don't support field_case", r.Target.Type)
+	}
+	if s := r.Target.Schema; s != "" {
+		if !slices.Contains(schemas, s) {
+			return fmt.Errorf("schema must be one of %s", strings.Join(schemas, ", "))
+		}
+		if !adapter.ShapesJSON {
+			return fmt.Errorf("%s targets don't support schema", r.Target.Type)
+		}
+		if r.Target.Encoding == "protobuf" {
+			return fmt.Errorf("schema only applies to json encoding")
+		}
+	}
+	if _, err := r.Target.ProxyFunc(); err != nil {
+		return err
+	}
+	// before the files are read, so a change made while they are is noticed
+	r.credentials = r.Target.credentialsDigest()
+	if r.Target.Sign != nil {
+		if !adapter.Signs {
+			return fmt.Errorf("%s targets don't support sign", r.Target.Type)
+		}
+		if err := r.Target.Sign.normalize(); err != nil {
+			return err
+		}
+	}
+	if r.Target.MaxDatagram < 0 || r.Target.MaxDatagram > maxUDPPayload {
+		return fmt.Errorf("max_datagram must be at most %d", maxUDPPayload)
+	}
+	if r.Target.PackDatagrams && r.Target.Type != "udp+json" {
+		return fmt.Errorf("pack_datagrams is only supported by udp+json targets")
+	}
+	workers := r.Target.Workers
+	if workers == 0 {
+		workers = 1
+	}
+	if workers < 1 || workers > maxWorkers {
+		return fmt.Errorf("workers must be between 1 and %d", maxWorkers)
+	}
+	if r.Target.Batch != nil {
+		if r.Target.Batch.MaxEvents != 0 && adapter.BatchesByBytes {
+			return fmt.Errorf("%s targets don't support batch max_events", r.Target.Type)
+		}
+		r.batch = *r.Target.Batch
+	}
+	if err := r.batch.normalize(); err != nil {
+		return err
+	}
+	if r.Target.Conn != nil {
+		r.conn = *r.Target.Conn
+	}
+	if err := r.conn.normalize(); err != nil {
+		return err
+	}
+	if r.Target.Pipeline != nil && (r.Target.Parsers != nil || r.Target.Extract != nil ||
+		r.Target.Enrich != nil || r.Target.Compute != nil || r.Target.Plugins != nil ||
+		r.Target.Where != "" || r.Target.Sample != nil) {
+		return fmt.Errorf("pipeline replaces parsers, extract, enrich, compute, plugins, where and sample, set one or the other")
+	}
+	r.stages = nil
+	for i, config := range r.Target.stageConfigs() {
+		stage, err := r.newStage(config)
+		if err != nil {
+			if r.Target.Pipeline != nil {
+				return fmt.Errorf("pipeline stage %d: %s", i+1, err)
+			}
+			return err
+		}
+		r.stages = append(r.stages, stage)
+	}
+	if r.Target.UsesTLS() || r.Target.TLS != nil {
+		config, err := r.Target.TLS.withDefaults().config()
+		if err != nil {
+			return err
+		}
+		r.tlsConfig = config
+	}
+	for key, options := range r.Target.options {
+		if key != adapter.Options {
+			return fmt.Errorf("%s targets don't support %s", r.Target.Type, key)
+		}
+		if err := options.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Target.Processor != nil {
+		if err := r.Target.Processor.normalize(); err != nil {
+			return err
+		}
+	}
+	if r.Target.Template != "" {
+		tmpl, err := CompileTemplate(r.Target.Template)
+		if err != nil {
+			return err
+		}
+		r.template = tmpl
+	}
+	if r.Source != nil {
+		return r.Source.Validate()
+	}
+	return nil
+}
+
+type Target struct {
+	Type       string            `json:"type"`
+	Addr       string            `json:"addr"`
+	AppendTag  string            `json:"append_tag,omitempty"`
+	FieldNames map[string]string `json:"field_names,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	// case of the keys of JSON lines that aren't renamed by FieldNames,
+	// "lower" or "upper", for the adapters that shape JSON lines
+	FieldCase string `json:"field_case,omitempty"`
+	// version of the shape of JSON lines, see schemas
+	Schema string `json:"schema,omitempty"`
+	// parsers run on each line before it's shipped, see parsers. Unset
+	// means the target type's default, "none" means no parsing.
+	Parsers []string `json:"parsers,omitempty"`
+	// rules run after the parsers to pull fields out of the message
+	Extract []attach.ExtractRule `json:"extract,omitempty"`
+	// enrichers run after extraction, see enrichers
+	Enrich []string `json:"enrich,omitempty"`
+	// expression lines must match to be shipped, see Expr
+	Where string `json:"where,omitempty"`
+	// fields set from expressions, in order of their names
+	Compute map[string]string `json:"compute,omitempty"`
+	// files of plugins lines are run through after compute, see Plugin
+	Plugins []string `json:"plugins,omitempty"`
+	// stages lines go through in order, instead of the settings above
+	Pipeline []StageConfig `json:"pipeline,omitempty"`
+	// Go template text targets render each line with, see templateData
+	Template string `json:"template,omitempty"`
+	// fraction of lines kept by severity, with "default" for the rest.
+	// Severities not listed are always kept.
+	Sample map[string]float64 `json:"sample,omitempty"`
+	// how lines are batched for sending, see defaultBatch for defaults
+	Batch *BatchConfig `json:"batch,omitempty"`
+	// number of batches sent in parallel, at the cost of ordering
+	Workers int `json:"workers,omitempty"`
+	// connection timeouts, see defaultConn for defaults
+	Conn *ConnConfig `json:"conn,omitempty"`
+	// how connections to *+tls targets are secured, overriding DefaultTLS
+	TLS *TLSConfig `json:"tls,omitempty"`
+	// external service lines are sent through before they're shipped
+	Processor *ProcessorConfig `json:"processor,omitempty"`
+	// proxy URL for HTTP based targets, overriding HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY. http, https and socks5 proxies are supported.
+	Proxy string `json:"proxy,omitempty"`
+	// how what's sent is compressed, see AdapterType.Compressions
+	Compression string `json:"compression,omitempty"`
+	// how lines are encoded, json by default, see AdapterType.Encodings
+	Encoding string `json:"encoding,omitempty"`
+	// HMAC signing of what's sent, for the adapters that support it, see
+	// AdapterType.Signs
+	Sign *SignConfig `json:"sign,omitempty"`
+	// largest UDP payload sent, see DatagramSize
+	MaxDatagram int `json:"max_datagram,omitempty"`
+	// whether udp+json targets send several lines per datagram
+	PackDatagrams bool `json:"pack_datagrams,omitempty"`
+	// settings only the target type's adapter uses, by their key, see
+	// TargetOptions
+	options map[string]TargetOptions
+}
+
+// returns the target of a route URI like syslog://host:514. Its path is
+// kept as part of the address, for targets like s3://bucket/prefix.
+func URITarget(u *url.URL) Target {
+	return Target{Type: u.Scheme, Addr: u.Host + strings.TrimRight(u.Path, "/")}
+}
+
+// returns the encoder of the target's lines: JSON lines, or for protobuf,
+// LogEntry messages prefixed with their length, see attach/log.proto. For
+// adapters that shape JSON lines, they're in the Schema's shape, with keys
+// renamed by FieldNames and cased by FieldCase.
+func (t Target) LineEncoder() func(*attach.Log, *bytes.Buffer) error {
+	if t.Encoding == "protobuf" {
+		return attach.EncodeProto
+	}
+	if adapters[t.Type].ShapesJSON && (t.Schema == "v2" || len(t.FieldNames) > 0 || t.FieldCase != "") {
+		return t.shapedEncoder()
+	}
+	return func(logline *attach.Log, buf *bytes.Buffer) error {
+		return json.NewEncoder(buf).Encode(logline)
+	}
+}
+
+// returns the proxy selection for the target's HTTP requests, the one from
+// the environment unless Proxy is set
+func (t Target) ProxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if t.Proxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxy, err := url.Parse(t.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %s", err)
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy: unsupported scheme %q", proxy.Scheme)
+	}
+	return http.ProxyURL(proxy), nil
+}
+
+// the largest payload a UDP datagram can carry
+const maxUDPPayload = 65507
+
+// returns the most bytes sent in one datagram, the largest payload UDP
+// allows unless MaxDatagram is set
+func (t Target) DatagramSize() int {
+	if t.MaxDatagram > 0 {
+		return t.MaxDatagram
+	}
+	return maxUDPPayload
+}
+
+// returns the target's comma-separated addresses as host:port pairs, with
+// IPv6 literals bracketed and the default port for the target type filled in
+func (t Target) HostPorts() ([]string, error) {
+	var addrs []string
+	for _, addr := range strings.Split(t.Addr, ",") {
+		addr = strings.TrimSpace(addr)
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			// no port, or a bare IPv6 literal
+			host, port = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), adapters[t.Type].DefaultPort
+		}
+		if host == "" {
+			return nil, fmt.Errorf("missing host in address %q", addr)
+		}
+		if port == "" {
+			return nil, fmt.Errorf("missing port in address %q", addr)
+		}
+		addrs = append(addrs, net.JoinHostPort(host, port))
+	}
+	return addrs, nil
+}
+
+// returns the target's first address as a host:port pair
+func (t Target) HostPort() (string, error) {
+	addrs, err := t.HostPorts()
+	if err != nil {
+		return "", err
+	}
+	return addrs[0], nil
+}
+
+// returns the name to emit for a field, honoring any rename in FieldNames
+func (t Target) FieldName(name string) string {
+	if renamed, ok := t.FieldNames[name]; ok && renamed != "" {
+		return renamed
+	}
+	return name
+}
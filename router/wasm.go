@@ -0,0 +1,131 @@
+//go:build wasm_plugins
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+)
+
+func init() {
+	loadPlugin = loadWASMPlugin
+}
+
+// wasmPlugin is a WebAssembly module implementing the plugin ABI:
+//
+//	alloc(size i32) i32            returns size bytes of module memory
+//	process(ptr i32, len i32) i64  processes the JSON line at ptr
+//	free(ptr i32, size i32)        optional, releases alloc'd memory
+//
+// process returns the result's address in the high 32 bits and its length
+// in the low ones. The result is the line as JSON, changed or not, and a
+// zero length drops the line. Modules may import WASI, for example to log
+// to stderr, and are sandboxed otherwise.
+type wasmPlugin struct {
+	// module instances aren't safe for concurrent calls
+	sync.Mutex
+	path    string
+	module  api.Module
+	alloc   api.Function
+	process api.Function
+	free    api.Function
+}
+
+func loadWASMPlugin(path string) (Plugin, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	// reactor modules, like TinyGo's and Rust's, initialize in _initialize
+	config := wazero.NewModuleConfig().WithName(path).WithStderr(os.Stderr).WithStartFunctions("_initialize")
+	module, err := runtime.InstantiateWithConfig(ctx, code, config)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	p := &wasmPlugin{
+		path:    path,
+		module:  module,
+		alloc:   module.ExportedFunction("alloc"),
+		process: module.ExportedFunction("process"),
+		free:    module.ExportedFunction("free"),
+	}
+	if p.alloc == nil || p.process == nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("module must export alloc and process")
+	}
+	return p, nil
+}
+
+// runs the line through the module, leaving it as it was if the module
+// fails
+func (p *wasmPlugin) Process(logline *attach.Log) bool {
+	input, err := json.Marshal(logline)
+	if err != nil {
+		return true
+	}
+	p.Lock()
+	defer p.Unlock()
+	processed, keep, err := p.call(input)
+	if err != nil {
+		logging.Logger("plugin").Error("plugin failed", "plugin", p.path, "err", err)
+		return true
+	}
+	if keep {
+		// what isn't in the JSON stays as it was
+		processed.Profile = logline.Profile
+		*logline = processed
+	}
+	return keep
+}
+
+func (p *wasmPlugin) call(input []byte) (attach.Log, bool, error) {
+	var processed attach.Log
+	ctx := context.Background()
+	memory := p.module.Memory()
+	results, err := p.alloc.Call(ctx, uint64(len(input)))
+	if err != nil {
+		return processed, false, err
+	}
+	ptr := uint32(results[0])
+	if !memory.Write(ptr, input) {
+		return processed, false, fmt.Errorf("alloc returned memory out of range")
+	}
+	results, err = p.process.Call(ctx, uint64(ptr), uint64(len(input)))
+	p.release(ptr, uint32(len(input)))
+	if err != nil {
+		return processed, false, err
+	}
+	outPtr, outLen := uint32(results[0]>>32), uint32(results[0])
+	if outLen == 0 {
+		return processed, false, nil
+	}
+	defer p.release(outPtr, outLen)
+	output, ok := memory.Read(outPtr, outLen)
+	if !ok {
+		return processed, false, fmt.Errorf("process returned memory out of range")
+	}
+	if err := json.Unmarshal(output, &processed); err != nil {
+		return processed, false, fmt.Errorf("process returned invalid JSON: %s", err)
+	}
+	return processed, true, nil
+}
+
+func (p *wasmPlugin) release(ptr, size uint32) {
+	if p.free != nil {
+		p.free.Call(context.Background(), uint64(ptr), uint64(size))
+	}
+}
@@ -0,0 +1,183 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func init() {
+	router.RegisterRouteStore("consul", newConsulStore)
+	router.RegisterRouteStore("consul+tls", newConsulStore)
+}
+
+// consulStore keeps routes in Consul's KV store, a key each under its
+// prefix, watched with blocking queries
+type consulStore struct {
+	client *http.Client
+	// the agent's URL, and the prefix of the keys
+	base   string
+	prefix string
+	token  string
+}
+
+// opens a store at consul://host:port/prefix, the port 8500 by default.
+// The ACL token is the token param, or CONSUL_HTTP_TOKEN.
+func newConsulStore(u *url.URL) (router.RouteStore, error) {
+	prefix := strings.Trim(u.Path, "/")
+	if u.Host == "" || prefix == "" {
+		return nil, fmt.Errorf("consul route store must be consul://host[:port]/prefix")
+	}
+	client, err := newClient(u)
+	if err != nil {
+		return nil, err
+	}
+	scheme, host := "http", u.Host
+	if u.Scheme == "consul+tls" {
+		scheme = "https"
+	}
+	if u.Port() == "" {
+		host += ":8500"
+	}
+	token := u.Query().Get("token")
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	return &consulStore{client: client, base: scheme + "://" + host, prefix: prefix, token: token}, nil
+}
+
+// makes a request of the agent's KV endpoint for key, relative to the
+// prefix
+func (s *consulStore) do(ctx context.Context, method, key, query string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.base+"/v1/kv/"+s.prefix+"/"+key+"?"+query, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	return s.client.Do(req)
+}
+
+func (s *consulStore) Get(id string) (*router.Route, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, "GET", url.PathEscape(id), "raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRoute(id, data)
+}
+
+func (s *consulStore) GetAll() ([]*router.Route, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	stored, _, err := s.list(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return decodeRoutes(s.base+"/"+s.prefix, stored), nil
+}
+
+// returns the routes under the prefix by ID, and the index of the KV
+// store they're as of. Further query params make it a blocking query.
+func (s *consulStore) list(ctx context.Context, query string) (map[string][]byte, uint64, error) {
+	resp, err := s.do(ctx, "GET", "", "recurse=true"+query, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	stored := make(map[string][]byte)
+	if resp.StatusCode == http.StatusNotFound {
+		return stored, index, nil
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, 0, err
+	}
+	var entries []struct {
+		Key   string
+		Value []byte
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+	for _, entry := range entries {
+		id := strings.TrimPrefix(entry.Key, s.prefix+"/")
+		// folders and keys of nested prefixes aren't routes
+		if id != "" && !strings.Contains(id, "/") {
+			stored[id] = entry.Value
+		}
+	}
+	return stored, index, nil
+}
+
+func (s *consulStore) Add(route *router.Route) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, "PUT", url.PathEscape(route.ID), "", attach.Marshal(route))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func (s *consulStore) Remove(id string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, "DELETE", url.PathEscape(id), "", nil)
+	if err != nil {
+		logging.Logger("persistor").Error("removing route failed", "route", id, "err", err)
+		return false
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp) == nil
+}
+
+// waits for the prefix's index to change with blocking queries, which
+// Consul answers when a key under it changes or after some minutes
+func (s *consulStore) Watch(ctx context.Context, changed func()) {
+	var index uint64
+	for ctx.Err() == nil {
+		_, next, err := s.list(ctx, "&wait=5m&index="+strconv.FormatUint(index, 10))
+		if err != nil {
+			if ctx.Err() == nil {
+				logging.Logger("persistor").Warn("watching consul failed", "err", err)
+				sleep(ctx, watchRetryDelay)
+			}
+			continue
+		}
+		if index != 0 && next != index {
+			changed()
+		}
+		// the index can go backwards when Consul's state is restored,
+		// when watching starts over
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
@@ -0,0 +1,254 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+func init() {
+	router.RegisterRouteStore("etcd", newEtcdStore)
+	router.RegisterRouteStore("etcd+tls", newEtcdStore)
+}
+
+// etcdStore keeps routes in etcd, a key each under its prefix, through
+// etcd's v3 JSON gateway
+type etcdStore struct {
+	client *http.Client
+	// the member's URL, and the prefix of the keys with a trailing slash
+	base   string
+	prefix string
+	// credentials of etcd's authentication, if it's enabled, and the
+	// token they were last exchanged for
+	user *url.Userinfo
+	sync.Mutex
+	token string
+}
+
+// opens a store at etcd://[user:password@]host:port/prefix, the port 2379
+// by default
+func newEtcdStore(u *url.URL) (router.RouteStore, error) {
+	prefix := strings.Trim(u.Path, "/")
+	if u.Host == "" || prefix == "" {
+		return nil, fmt.Errorf("etcd route store must be etcd://host[:port]/prefix")
+	}
+	client, err := newClient(u)
+	if err != nil {
+		return nil, err
+	}
+	scheme, host := "http", u.Host
+	if u.Scheme == "etcd+tls" {
+		scheme = "https"
+	}
+	if u.Port() == "" {
+		host += ":2379"
+	}
+	return &etcdStore{client: client, base: scheme + "://" + host, prefix: prefix + "/", user: u.User}, nil
+}
+
+// the end of the range of keys with a prefix, which etcd takes as the
+// prefix with its last byte incremented
+func rangeEnd(prefix string) []byte {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return end
+}
+
+// posts a request to an endpoint of the gateway, decoding the response
+// into out. A token that's expired is replaced once.
+func (s *etcdStore) call(ctx context.Context, endpoint string, in, out interface{}) error {
+	resp, err := s.post(ctx, endpoint, in)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && s.user != nil {
+		resp.Body.Close()
+		s.Lock()
+		s.token = ""
+		s.Unlock()
+		resp, err = s.post(ctx, endpoint, in)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (s *etcdStore) post(ctx context.Context, endpoint string, in interface{}) (*http.Response, error) {
+	token, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := json.Marshal(in)
+	req, err := http.NewRequestWithContext(ctx, "POST", s.base+"/v3/"+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return s.client.Do(req)
+}
+
+// returns the token requests are authenticated with, getting one if
+// there are credentials and it hasn't yet
+func (s *etcdStore) authenticate(ctx context.Context) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.user == nil || s.token != "" {
+		return s.token, nil
+	}
+	password, _ := s.user.Password()
+	body, _ := json.Marshal(map[string]string{"name": s.user.Username(), "password": password})
+	req, err := http.NewRequestWithContext(ctx, "POST", s.base+"/v3/auth/authenticate", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", fmt.Errorf("etcd authentication failed: %s", err)
+	}
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return "", err
+	}
+	s.token = auth.Token
+	return s.token, nil
+}
+
+// etcdKV is a key and value as the gateway encodes them, in base64
+type etcdKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+func (s *etcdStore) Get(id string) (*router.Route, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	var resp struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := s.call(ctx, "kv/range", map[string]interface{}{"key": []byte(s.prefix + id)}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.KVs) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return decodeRoute(id, resp.KVs[0].Value)
+}
+
+func (s *etcdStore) GetAll() ([]*router.Route, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	var resp struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := s.call(ctx, "kv/range", map[string]interface{}{"key": []byte(s.prefix), "range_end": rangeEnd(s.prefix)}, &resp); err != nil {
+		return nil, err
+	}
+	stored := make(map[string][]byte)
+	for _, kv := range resp.KVs {
+		// keys of nested prefixes aren't routes
+		if id := strings.TrimPrefix(string(kv.Key), s.prefix); !strings.Contains(id, "/") {
+			stored[id] = kv.Value
+		}
+	}
+	return decodeRoutes(s.base+"/"+s.prefix, stored), nil
+}
+
+func (s *etcdStore) Add(route *router.Route) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return s.call(ctx, "kv/put", etcdKV{Key: []byte(s.prefix + route.ID), Value: attach.Marshal(route)}, nil)
+}
+
+func (s *etcdStore) Remove(id string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	var resp struct {
+		Deleted string `json:"deleted"`
+	}
+	if err := s.call(ctx, "kv/deleterange", map[string]interface{}{"key": []byte(s.prefix + id)}, &resp); err != nil {
+		logging.Logger("persistor").Error("removing route failed", "route", id, "err", err)
+		return false
+	}
+	return resp.Deleted != "" && resp.Deleted != "0"
+}
+
+// streams the prefix's changes from a watch, calling changed for each
+// batch of events, and again after watching again, since changes may have
+// been missed meanwhile
+func (s *etcdStore) Watch(ctx context.Context, changed func()) {
+	for watched := false; ctx.Err() == nil; watched = true {
+		if watched {
+			changed()
+		}
+		err := s.watch(ctx, changed)
+		if ctx.Err() == nil {
+			logging.Logger("persistor").Warn("watching etcd failed", "err", err)
+			sleep(ctx, watchRetryDelay)
+		}
+	}
+}
+
+func (s *etcdStore) watch(ctx context.Context, changed func()) error {
+	create := map[string]interface{}{"create_request": map[string]interface{}{"key": []byte(s.prefix), "range_end": rangeEnd(s.prefix)}}
+	resp, err := s.post(ctx, "watch", create)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		s.Lock()
+		s.token = ""
+		s.Unlock()
+	}
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Events   []json.RawMessage `json:"events"`
+				Canceled bool              `json:"canceled"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := decoder.Decode(&msg); err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("%s", msg.Error.Message)
+		}
+		if msg.Result.Canceled {
+			return fmt.Errorf("watch canceled")
+		}
+		if len(msg.Result.Events) > 0 {
+			changed()
+		}
+	}
+}
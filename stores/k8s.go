@@ -0,0 +1,217 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// where pods find their service account's credentials and namespace
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+func init() {
+	router.RegisterRouteStore("k8s", newK8sStore)
+}
+
+// k8sStore keeps routes in a ConfigMap, a key of <id>.json each, through
+// the API server of the cluster logspout runs in
+type k8sStore struct {
+	client *http.Client
+	// the ConfigMap's URL
+	url  string
+	name string
+}
+
+// opens a store at k8s://namespace/name, or k8s:///name for a ConfigMap in
+// logspout's own namespace, authenticating as the pod's service account,
+// which needs get, watch, create and patch on it
+func newK8sStore(u *url.URL) (router.RouteStore, error) {
+	namespace, name := u.Host, strings.Trim(u.Path, "/")
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("k8s route store must be k8s://[namespace]/configmap")
+	}
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("k8s route store namespace: %s", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("k8s route store only works in a pod, KUBERNETES_SERVICE_HOST isn't set")
+	}
+	pem, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &k8sStore{
+		client: &http.Client{Transport: transport},
+		url:    "https://" + net.JoinHostPort(host, port) + "/api/v1/namespaces/" + url.PathEscape(namespace) + "/configmaps",
+		name:   name,
+	}, nil
+}
+
+// makes a request of the API server, as the service account. Its token is
+// read each time, as the kubelet rotates it.
+func (s *k8sStore) do(ctx context.Context, method, uri, contentType string, body interface{}) (*http.Response, error) {
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return s.client.Do(req)
+}
+
+// returns the ConfigMap's data, empty if it doesn't exist
+func (s *k8sStore) data(ctx context.Context) (map[string]string, error) {
+	resp, err := s.do(ctx, "GET", s.url+"/"+url.PathEscape(s.name), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	var configMap struct {
+		Data map[string]string `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&configMap)
+	return configMap.Data, err
+}
+
+func (s *k8sStore) Get(id string) (*router.Route, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	data, err := s.data(ctx)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := data[id+".json"]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return decodeRoute(id, []byte(value))
+}
+
+func (s *k8sStore) GetAll() ([]*router.Route, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	data, err := s.data(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string][]byte)
+	for key, value := range data {
+		if id, ok := strings.CutSuffix(key, ".json"); ok {
+			stored[id] = []byte(value)
+		}
+	}
+	return decodeRoutes("configmap "+s.name, stored), nil
+}
+
+// merge patches the ConfigMap's data, creating it if it doesn't exist
+func (s *k8sStore) patch(data map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, "PATCH", s.url+"/"+url.PathEscape(s.name), "application/merge-patch+json",
+		map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		return checkResponse(resp)
+	}
+	resp, err = s.do(ctx, "POST", s.url, "application/json", map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]string{"name": s.name},
+		"data":       data,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func (s *k8sStore) Add(route *router.Route) error {
+	return s.patch(map[string]interface{}{route.ID + ".json": string(attach.Marshal(route))})
+}
+
+func (s *k8sStore) Remove(id string) bool {
+	// a null value deletes the key
+	if err := s.patch(map[string]interface{}{id + ".json": nil}); err != nil {
+		logging.Logger("persistor").Error("removing route failed", "route", id, "err", err)
+		return false
+	}
+	return true
+}
+
+// watches the ConfigMap, calling changed for each event, including the
+// one for its current state each watch starts with
+func (s *k8sStore) Watch(ctx context.Context, changed func()) {
+	query := "?watch=true&fieldSelector=" + url.QueryEscape("metadata.name="+s.name)
+	for ctx.Err() == nil {
+		err := s.watch(ctx, s.url+query, changed)
+		if ctx.Err() == nil {
+			logging.Logger("persistor").Warn("watching configmap failed", "configmap", s.name, "err", err)
+			sleep(ctx, watchRetryDelay)
+		}
+	}
+}
+
+func (s *k8sStore) watch(ctx context.Context, uri string, changed func()) error {
+	resp, err := s.do(ctx, "GET", uri, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type string `json:"type"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+		if event.Type == "ERROR" {
+			return fmt.Errorf("watch ended with an error")
+		}
+		changed()
+	}
+}
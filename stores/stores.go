@@ -0,0 +1,93 @@
+// Package stores adds route stores kept in Consul, etcd and Kubernetes, for
+// ROUTES_STORE URIs like consul://localhost:8500/logspout/routes, so hosts
+// share their routes and changes reach them all.
+package stores
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jimmidyson/logspout/attach"
+	"github.com/jimmidyson/logspout/logging"
+	"github.com/jimmidyson/logspout/router"
+)
+
+// how long requests other than watches may take
+const requestTimeout = 10 * time.Second
+
+// how long to wait before watching again after watching failed
+const watchRetryDelay = 5 * time.Second
+
+// waits d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+}
+
+// decodes a stored route, giving it the ID it's stored under if it
+// doesn't have one
+func decodeRoute(id string, data []byte) (*router.Route, error) {
+	route := new(router.Route)
+	if err := attach.Unmarshal(io.NopCloser(bytes.NewReader(data)), route); err != nil {
+		return nil, err
+	}
+	if route.ID == "" {
+		route.ID = id
+	}
+	return route, nil
+}
+
+// decodes stored routes by ID, skipping those that can't be decoded as
+// RouteFileStore skips files
+func decodeRoutes(store string, stored map[string][]byte) []*router.Route {
+	var routes []*router.Route
+	for id, data := range stored {
+		route, err := decodeRoute(id, data)
+		if err != nil {
+			logging.Logger("persistor").Warn("skipping stored route", "store", store, "route", id, "err", err)
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// returns an error with the status and start of the body of a response
+// that didn't succeed
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// returns a client for the store a URI names, trusting the PEM bundle its
+// ca param names instead of the system's CAs if it has one. Timeouts are
+// left to requests, as watches last.
+func newClient(u *url.URL) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if path := u.Query().Get("ca"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", path)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Transport: transport}, nil
+}